@@ -0,0 +1,54 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAckFailed is matched by errors.Is for an AckError.
+var ErrAckFailed = errors.New("failed to acknowledge messages")
+
+// AckError is returned by Process if some of the batch's messages couldn't be
+// acked or nacked after processing. The messages are redelivered once the
+// consumer's AckWait elapses, so they may be processed again.
+type AckError struct {
+	// Failed is the number of messages that couldn't be acked or nacked.
+	Failed int
+	// Total is the number of messages that were acked or nacked.
+	Total int
+	// Errs contains the error for each message that failed.
+	Errs []error
+}
+
+// AllFailed returns true if none of the messages could be acked or nacked,
+// which usually means that the connection to the server was lost.
+func (e AckError) AllFailed() bool {
+	return e.Failed > 0 && e.Failed == e.Total
+}
+
+func (e AckError) Error() string {
+	return fmt.Sprintf("failed to acknowledge %d of %d messages: %v", e.Failed, e.Total, errors.Join(e.Errs...))
+}
+
+func (e AckError) Unwrap() []error {
+	return e.Errs
+}
+
+func (e AckError) Is(target error) bool {
+	return target == ErrAckFailed
+}
+
+// newAckError returns an AckError if any of the errors are non-nil.
+func newAckError(errs []error) error {
+	e := AckError{Total: len(errs)}
+	for _, err := range errs {
+		if err != nil {
+			e.Failed++
+			e.Errs = append(e.Errs, err)
+		}
+	}
+	if e.Failed == 0 {
+		return nil
+	}
+	return e
+}
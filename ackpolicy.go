@@ -0,0 +1,45 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrIncompatibleAckPolicy is returned when a processor is created for a
+// consumer with the AckNone policy, since the server ignores acks and nacks,
+// so messages that fail processing aren't redelivered.
+var ErrIncompatibleAckPolicy = errors.New("consumer ack policy is incompatible with the processor")
+
+// WithSkipAckPolicyCheck allows the processor to be used with a consumer that
+// has the AckNone policy, e.g. where losing messages that fail processing is
+// acceptable.
+func WithSkipAckPolicyCheck[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.skipAckPolicyCheck = true
+	}
+}
+
+// checkAckPolicy sets optErr if the consumer's ack policy means that acks and
+// nacks are ignored, so that the misconfiguration is reported by Process. A
+// warning is logged for the AckAll policy, because acking a message also acks
+// the messages before it, including messages that were nacked.
+func (b *BatchProcessor[T]) checkAckPolicy(consumer jetstream.Consumer) {
+	if b.skipAckPolicyCheck {
+		return
+	}
+	info := consumer.CachedInfo()
+	if info == nil {
+		return
+	}
+	switch info.Config.AckPolicy {
+	case jetstream.AckNonePolicy:
+		err := fmt.Errorf("%w: consumer %q has ack policy %v, use AckExplicit, or WithSkipAckPolicyCheck", ErrIncompatibleAckPolicy, info.Name, info.Config.AckPolicy)
+		b.Log.Error("Consumer ack policy is incompatible with the processor", slog.String("consumer", info.Name), slog.Any("error", err))
+		b.optErr = errors.Join(b.optErr, err)
+	case jetstream.AckAllPolicy:
+		b.Log.Warn("Consumer ack policy acks all previous messages, so failed messages may not be redelivered", slog.String("consumer", info.Name), slog.String("ackPolicy", info.Config.AckPolicy.String()))
+	}
+}
@@ -0,0 +1,57 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestAckPolicyCheck(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	if _, err = EnsureStream(ctx, js, jetstream.StreamConfig{Name: "ack_policy", Storage: jetstream.MemoryStorage}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	ackNone := jetstream.ConsumerConfig{Durable: "ackNone", AckPolicy: jetstream.AckNonePolicy}
+	consumer, err := EnsureConsumer(ctx, js, "ack_policy", ackNone)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	p := func(ctx context.Context, msgs []int) []error { return nil }
+
+	t.Run("Process returns an error for consumers with the AckNone policy", func(t *testing.T) {
+		bp := NewBatchProcessor(consumer, 10, p)
+		if err := bp.Process(ctx); !errors.Is(err, ErrIncompatibleAckPolicy) {
+			t.Errorf("expected ErrIncompatibleAckPolicy, got %v", err)
+		}
+	})
+	t.Run("Run returns the error instead of retrying", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		bp := NewBatchProcessor(consumer, 10, p)
+		if err := bp.Run(ctx); !errors.Is(err, ErrIncompatibleAckPolicy) {
+			t.Errorf("expected ErrIncompatibleAckPolicy, got %v", err)
+		}
+	})
+	t.Run("NewBatchProcessorFromConfig returns an error for the AckNone policy", func(t *testing.T) {
+		_, err := NewBatchProcessorFromConfig(ctx, js, "ack_policy", ackNone, 10, p)
+		if !errors.Is(err, ErrIncompatibleAckPolicy) {
+			t.Errorf("expected ErrIncompatibleAckPolicy, got %v", err)
+		}
+	})
+	t.Run("WithSkipAckPolicyCheck allows the AckNone policy", func(t *testing.T) {
+		bp := NewBatchProcessor(consumer, 10, p, WithSkipAckPolicyCheck[int](), WithFetchNoWait[int]())
+		if err := bp.Process(ctx); err != nil && !errors.Is(err, ErrNoMessages) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithAdaptiveBatch scales the size of each fetch between min and max
+// according to the number of messages pending on the consumer, instead of
+// using a fixed batch size. When there's a large backlog, full batches of max
+// messages are fetched for throughput, and as the backlog drains, smaller
+// batches are fetched, so that a fetch doesn't wait for messages that aren't
+// there.
+//
+// The number of pending messages is read from the metadata of the last message
+// of each batch, so no extra requests are made. The first batch is fetched
+// with the min size. The batchSize passed to the constructor is ignored.
+func WithAdaptiveBatch[T any](min, max int) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		if min <= 0 || max < min {
+			bp.optErr = errors.Join(bp.optErr, fmt.Errorf("%w: adaptive batch requires 0 < min <= max, got min %d, max %d", ErrInvalidOption, min, max))
+			return
+		}
+		bp.adaptive = &adaptiveBatch{min: min, max: max}
+	}
+}
+
+type adaptiveBatch struct {
+	min, max int
+	// pending is the number of messages pending on the consumer after the
+	// last batch.
+	pending atomic.Uint64
+}
+
+// size returns the number of messages to fetch.
+func (a *adaptiveBatch) size() int {
+	pending := a.pending.Load()
+	if pending < uint64(a.min) {
+		return a.min
+	}
+	if pending > uint64(a.max) {
+		return a.max
+	}
+	return int(pending)
+}
+
+// observe records the number of messages that are pending after the last
+// message of a batch. If the batch was empty, last is nil.
+func (a *adaptiveBatch) observe(last jetstream.Msg) {
+	if last == nil {
+		a.pending.Store(0)
+		return
+	}
+	if md, err := last.Metadata(); err == nil {
+		a.pending.Store(md.NumPending)
+	}
+}
@@ -2,109 +2,688 @@ package natsjson
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 type BatchProcessorOpt[T any] func(*BatchProcessor[T])
 
+// BatchFunc processes a batch of messages, returning an error for each message.
+// A nil or empty slice means that every message was processed successfully.
+type BatchFunc[T any] func(ctx context.Context, messages []T) []error
+
+// Middleware wraps a BatchFunc to add behaviour, e.g. logging or recovery.
+type Middleware[T any] func(next BatchFunc[T]) BatchFunc[T]
+
+// WithMiddleware wraps the processor with the middleware. The first middleware
+// is the outermost, so it's called first.
+func WithMiddleware[T any](mw ...Middleware[T]) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.middleware = append(bp.middleware, mw...)
+	}
+}
+
 func WithLogger[T any](log *slog.Logger) BatchProcessorOpt[T] {
 	return func(bp *BatchProcessor[T]) {
 		bp.Log = log
 	}
 }
 
+// WithFetchOpts sets the options passed to the consumer's Fetch method. The
+// options are applied after the default max wait of DefaultBatchMaxWait, so
+// that they can override it.
 func WithFetchOpts[T any](opts ...jetstream.FetchOpt) BatchProcessorOpt[T] {
 	return func(bp *BatchProcessor[T]) {
 		bp.fetchOpts = append(bp.fetchOpts, opts...)
 	}
 }
 
+// DefaultBatchMaxWait is how long a fetch waits for a full batch if the wait
+// isn't set by WithBatchMaxWait or WithFetchOpts.
+const DefaultBatchMaxWait = 5 * time.Second
+
+// WithBatchMaxWait sets how long a fetch waits for a full batch before
+// returning the messages that are available. The wait must be greater than
+// zero, and defaults to DefaultBatchMaxWait. For waits of 10 seconds or more,
+// the client requests idle heartbeats from the server every 5 seconds, so that
+// a fetch doesn't hang if the connection to the server is lost. Shorter
+// fetches aren't sent heartbeats, but the client stops waiting if no messages
+// are received for a second longer than the wait.
+//
+// The heartbeat interval can't be configured, because the version of the
+// jetstream package that this module depends on doesn't provide a
+// FetchHeartbeat option.
+func WithBatchMaxWait[T any](d time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		if d <= 0 {
+			bp.optErr = errors.Join(bp.optErr, fmt.Errorf("%w: max wait must be greater than 0, got %v", ErrInvalidOption, d))
+			return
+		}
+		bp.fetchOpts = append(bp.fetchOpts, jetstream.FetchMaxWait(d))
+	}
+}
+
+// ErrInvalidOption is returned by Process and Run if the processor was created
+// with an invalid option, e.g. WithBatchMaxWait(0).
+var ErrInvalidOption = errors.New("invalid option")
+
+// WithMetrics records metrics for each batch, labelled with the consumer's
+// stream and name.
+func WithMetrics[T any](metrics BatchMetrics) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.metrics = metrics
+	}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{
+		AddSource: true,
+		Level:     slog.LevelError,
+	}))
+}
+
+// WithRateLimit limits the rate that messages are passed to the processor to
+// rps messages per second, with bursts of up to burst messages. If the context
+// is cancelled while waiting, the batch is nacked so that it can be redelivered.
+func WithRateLimit[T any](rps float64, burst int) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+	}
+}
+
+// WithFetchNoWait fetches the messages that are available immediately, instead
+// of waiting for a full batch. When no messages are available, Process returns
+// ErrNoMessages, which allows a job to drain a consumer and exit. Fetch options
+// are ignored.
+func WithFetchNoWait[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.fetchNoWait = true
+	}
+}
+
+// WithOrdered stops at the first message in a batch that the processor returns
+// an error for. The failed message and all messages after it are nacked, and
+// only the messages before it are acked, so that messages are handled in stream
+// order. To avoid later batches being delivered before the redelivered
+// messages, configure the consumer with a MaxAckPending of the batch size.
+func WithOrdered[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.ordered = true
+	}
+}
+
+// WithAckSync waits for the server to confirm each ack of a processed message,
+// so that a message is only considered done once the server has received the
+// ack. Acks that aren't confirmed are included in the error returned by Process.
+//
+// Each ack requires a round trip to the server, so processing a batch takes
+// longer than with the default fire-and-forget acks.
+func WithAckSync[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.ackSync = true
+	}
+}
+
+// ErrNoMessages is returned by Process when no messages were available, e.g.
+// because the fetch timed out while waiting for messages. Other fetch failures
+// are returned as a different error.
+var ErrNoMessages = errors.New("no messages")
+
+// ErrProcessorResultMismatch is returned by Process when the processor doesn't
+// return one error for each message. The error is a ResultMismatchError.
+var ErrProcessorResultMismatch = errors.New("processor result mismatch")
+
+// ResultMismatchError is returned by Process when the processor doesn't return
+// one error for each message. It's a programming error, rather than a
+// transient failure.
+type ResultMismatchError struct {
+	// Expected is the number of messages passed to the processor.
+	Expected int
+	// Actual is the number of errors returned by the processor.
+	Actual int
+}
+
+func (e ResultMismatchError) Error() string {
+	return fmt.Sprintf("expected a slice of %d errors - one for each msg, but got %d", e.Expected, e.Actual)
+}
+
+func (e ResultMismatchError) Is(target error) bool {
+	return target == ErrProcessorResultMismatch
+}
+
+// NewBatchProcessor creates a processor that fetches batches of messages from
+// the consumer. Each fetch waits up to DefaultBatchMaxWait for a full batch,
+// unless WithBatchMaxWait is set. If the consumer has the AckNone policy,
+// Process returns ErrIncompatibleAckPolicy, unless WithSkipAckPolicyCheck is
+// set.
 func NewBatchProcessor[T any](consumer jetstream.Consumer, batchSize int, processor func(ctx context.Context, messages []T) []error, opts ...BatchProcessorOpt[T]) *BatchProcessor[T] {
+	bp := newBatchProcessor(batchSize, processor, opts...)
+	bp.setConsumer(consumer)
+	bp.checkAckPolicy(consumer)
+	return bp
+}
+
+// newBatchProcessor creates a processor without a consumer, so that the options
+// can be used to configure a consumer before calling setConsumer.
+func newBatchProcessor[T any](batchSize int, processor BatchFunc[T], opts ...BatchProcessorOpt[T]) *BatchProcessor[T] {
 	bp := &BatchProcessor[T]{
-		consumer:  consumer,
 		batchSize: batchSize,
 		processor: processor,
+		fetchOpts: []jetstream.FetchOpt{jetstream.FetchMaxWait(DefaultBatchMaxWait)},
 	}
 	for _, opt := range opts {
 		opt(bp)
 	}
+	for i := len(bp.middleware) - 1; i >= 0; i-- {
+		bp.processor = bp.middleware[i](bp.processor)
+	}
+	if bp.idempotency != nil {
+		bp.idempotency.claimFirst = bp.idempotencyClaimFirst
+	}
 	if bp.Log == nil {
-		bp.Log = slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{
-			AddSource: true,
-			Level:     slog.LevelError,
-		}))
+		bp.Log = discardLogger()
 	}
 	return bp
 }
 
+func (b *BatchProcessor[T]) setConsumer(consumer jetstream.Consumer) {
+	b.consumer = consumer
+	if b.metrics != nil {
+		if info := consumer.CachedInfo(); info != nil {
+			b.metricLabels = MetricLabels{
+				Stream:   info.Stream,
+				Consumer: info.Name,
+			}
+		}
+	}
+}
+
 type BatchProcessor[T any] struct {
-	Log          *slog.Logger
-	consumer     jetstream.Consumer
-	batchSize    int
-	processor    func(ctx context.Context, messages []T) []error
-	fetchOpts    []jetstream.FetchOpt
+	Log        *slog.Logger
+	consumer   jetstream.Consumer
+	batchSize  int
+	processor  BatchFunc[T]
+	middleware []Middleware[T]
+	fetchOpts  []jetstream.FetchOpt
+	// optErr is set if an option is invalid.
+	optErr                 error
+	metrics                BatchMetrics
+	metricLabels           MetricLabels
+	tracing                *tracing
+	schema                 Schema
+	schemaViolationPolicy  SchemaViolationPolicy
+	limiter                *rate.Limiter
+	fetchNoWait            bool
+	window                 time.Duration
+	adaptive               *adaptiveBatch
+	ordered                bool
+	filterSubjects         []string
+	unmatchedSubjectPolicy UnmatchedSubjectPolicy
+	headerFilters          map[string][]string
+	nakBackoff             func(attempt int) time.Duration
+	ackSync                bool
+	maxBackoff             time.Duration
+	nc                     *nats.Conn
+	idempotency            *idempotency[T]
+	idempotencyClaimFirst  bool
+	processTimeout         time.Duration
+	maxAge                 time.Duration
+	skipAckPolicyCheck     bool
+	redeliveryThreshold    int
+	// stream is set if the processor owns its consumer.
+	stream        jetstream.Stream
+	startTime     time.Time
+	startSequence uint64
+	decoder[T]
+	// ErrorHandler is called with each message that couldn't be processed.
 	ErrorHandler func(msg T, err error)
+	// MessageErrorHandler is called with each message that couldn't be
+	// processed, along with the message's metadata. If tracing is enabled, the
+	// context contains the message's span. If both ErrorHandler and
+	// MessageErrorHandler are set, both are called.
+	MessageErrorHandler func(ctx context.Context, info MessageInfo[T], err error)
+	// OnDecodeError is called with the raw data of each message that couldn't
+	// be decoded, and returns what to do with the message. If not set, the
+	// message is acked and skipped.
+	OnDecodeError func(raw []byte, subject string, err error) DecodeAction
+	// OnStale is called with the raw data of each message that's older than
+	// the maximum age set by WithMaxAge, and returns what to do with the
+	// message. If not set, the message is acked and skipped, even if
+	// WithQuarantine is set.
+	OnStale func(raw []byte, subject string, age time.Duration) DecodeAction
+	// OnRedelivery is called with each decoded message that has been delivered
+	// at least as many times as the threshold set by WithRedeliveryThreshold,
+	// before it's passed to the processor.
+	OnRedelivery func(info MessageInfo[T], deliveries int)
+}
+
+// Consumer returns the consumer that messages are fetched from, e.g. to get
+// the consumer's info.
+func (b *BatchProcessor[T]) Consumer() jetstream.Consumer {
+	return b.consumer
+}
+
+// DecodeAction is what to do with a message that couldn't be decoded.
+type DecodeAction int
+
+const (
+	// DecodeAck acks the message, so that it's skipped.
+	DecodeAck DecodeAction = iota
+	// DecodeNak nacks the message, so that it's redelivered.
+	DecodeNak
+	// DecodeTerm terminates the message, so that it's not redelivered,
+	// regardless of the consumer's MaxDeliver setting.
+	DecodeTerm
+	// DecodeQuarantine republishes the message to the quarantine subject set
+	// by WithQuarantine or WithStreamProcessorQuarantine, then acks it. If the
+	// message can't be republished, it's nacked. If no quarantine subject is
+	// set, the message is terminated.
+	DecodeQuarantine
+)
+
+// MessageInfo is a message's value and metadata.
+type MessageInfo[T any] struct {
+	Value   T
+	Subject string
+	Headers nats.Header
+	// Sequence is the message's sequence number in the stream.
+	Sequence uint64
+	// NumDelivered is the number of times that the message has been delivered,
+	// including this delivery.
+	NumDelivered uint64
+	// Timestamp is the time that the message was stored in the stream.
+	Timestamp time.Time
 }
 
+func newMessageInfo[T any](msg jetstream.Msg, value T) (info MessageInfo[T]) {
+	info = MessageInfo[T]{
+		Value:   value,
+		Subject: msg.Subject(),
+		Headers: msg.Headers(),
+	}
+	if md, err := msg.Metadata(); err == nil {
+		info.Sequence = md.Sequence.Stream
+		info.NumDelivered = md.NumDelivered
+		info.Timestamp = md.Timestamp
+	}
+	return info
+}
+
+func (b *BatchProcessor[T]) handleError(ctx context.Context, msg jetstream.Msg, value T, span trace.Span, err error) {
+	if b.ErrorHandler != nil {
+		b.ErrorHandler(value, err)
+	}
+	if b.MessageErrorHandler != nil {
+		if span != nil {
+			ctx = trace.ContextWithSpan(ctx, span)
+		}
+		b.MessageErrorHandler(ctx, newMessageInfo(msg, value), err)
+	}
+}
+
+// ProcessResult contains the number of messages handled by a call to Process.
+type ProcessResult struct {
+	// Fetched is the number of messages fetched from the consumer.
+	Fetched int
+	// Acked is the number of messages that were processed successfully.
+	Acked int
+	// Nacked is the number of messages that were passed to the processor, but
+	// will be redelivered.
+	Nacked int
+	// Termed is the number of messages that were passed to the processor, and
+	// terminated, so that they won't be redelivered.
+	Termed int
+	// Skipped is the number of messages that were not passed to the processor,
+	// e.g. because they couldn't be decoded.
+	Skipped int
+	// Stale is the number of skipped messages that were older than the maximum
+	// age set by WithMaxAge.
+	Stale int
+	// Filtered is the number of skipped messages that didn't match the header
+	// filter set by WithHeaderFilter.
+	Filtered int
+}
+
+// Process fetches a batch of messages, passes them to the processor, and acks
+// or nacks each message depending on the result. If any of the messages can't
+// be acked or nacked, the error is an AckError.
+//
+// If the context is cancelled while the batch is being fetched, Process stops
+// waiting for the rest of the batch and returns the context's error. The
+// messages that have been received are nacked in the background once the fetch
+// has finished, so they're redelivered after the fetch's max wait.
 func (b *BatchProcessor[T]) Process(ctx context.Context) (err error) {
+	_, err = b.ProcessWithResult(ctx)
+	return err
+}
+
+// ProcessWithResult is the same as Process, but also returns the number of
+// messages that were handled.
+func (b *BatchProcessor[T]) ProcessWithResult(ctx context.Context) (result ProcessResult, err error) {
+	if b.optErr != nil {
+		return result, b.optErr
+	}
 	// Fetch a batch.
 	b.Log.Debug("Fetching batch")
-	mb, err := b.consumer.Fetch(b.batchSize, b.fetchOpts...)
+	fetchStart := time.Now()
+	batchSize := b.batchSize
+	if b.adaptive != nil {
+		batchSize = b.adaptive.size()
+	}
+	var mb jetstream.MessageBatch
+	if b.fetchNoWait {
+		mb, err = b.consumer.FetchNoWait(batchSize)
+	} else if b.window > 0 {
+		mb, err = b.fetchWindow(batchSize)
+	} else {
+		mb, err = b.consumer.Fetch(batchSize, b.fetchOpts...)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to fetch: %w", err)
+		return result, fmt.Errorf("failed to fetch: %w", err)
 	}
 
 	// Convert JSON messages to type.
 	b.Log.Debug("Reading messages")
 	var msgBodies []T
 	var msgs []jetstream.Msg
-	for msg := range mb.Messages() {
-		var fr T
-		if err := json.Unmarshal(msg.Data(), &fr); err != nil {
-			unmarshalErr := fmt.Errorf("failed to unmarshal, skipping invalid message: %v", err)
-			if ackErr := msg.Ack(); ackErr != nil {
-				return errors.Join(unmarshalErr, ackErr)
+	var claims []uint64
+	var spans []trace.Span
+	var last jetstream.Msg
+	var cancelled bool
+	seen := map[string]struct{}{}
+	received := mb.Messages()
+	for {
+		var msg jetstream.Msg
+		var ok bool
+		if ctx.Err() == nil {
+			select {
+			case msg, ok = <-received:
+			case <-ctx.Done():
+			}
+		}
+		if msg == nil && ctx.Err() != nil {
+			// Stop waiting for the rest of the batch, and redeliver it.
+			cancelled = true
+			break
+		}
+		if !ok {
+			break
+		}
+		result.Fetched++
+		last = msg
+		if !matchesAnySubject(b.filterSubjects, msg.Subject()) {
+			result.Skipped++
+			if b.unmatchedSubjectPolicy == IgnoreUnmatched {
+				continue
+			}
+			if err := msg.Ack(); err != nil {
+				return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, fmt.Errorf("failed to ack message with unmatched subject: %w", err))
+			}
+			continue
+		}
+		if !matchesHeaders(b.headerFilters, msg.Headers()) {
+			b.Log.Debug("Skipping message that doesn't match the header filter", slog.String("subject", msg.Subject()))
+			result.Skipped++
+			result.Filtered++
+			if err := msg.Ack(); err != nil {
+				return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, fmt.Errorf("failed to ack message with unmatched headers: %w", err))
+			}
+			continue
+		}
+		if age, stale := b.isStale(msg, fetchStart); stale {
+			b.Log.Debug("Skipping stale message", slog.String("subject", msg.Subject()), slog.Duration("age", age))
+			result.Skipped++
+			result.Stale++
+			// Unlike decode errors, stale messages are acked by default, even
+			// if WithQuarantine is set.
+			onStale := func(raw []byte, subject string, err error) DecodeAction {
+				if b.OnStale == nil {
+					return DecodeAck
+				}
+				return b.OnStale(raw, subject, age)
+			}
+			if err := b.skip(ctx, b.Log, msg, onStale, ErrMessageTooOld); err != nil {
+				return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, fmt.Errorf("failed to ack stale message: %w", err))
 			}
 			continue
 		}
+		var span trace.Span
+		if b.tracing != nil {
+			span = b.tracing.startConsumerSpan(ctx, msg.Subject(), msg.Headers())
+		}
+		fr, data, skipSchema, err := b.decodeMsg(msg)
+		if err != nil {
+			unmarshalErr := fmt.Errorf("failed to unmarshal, skipping invalid message: %w", err)
+			if span != nil {
+				recordSpanError(span, unmarshalErr)
+				span.End()
+			}
+			if errors.Is(err, ErrUnknownSchemaVersion) {
+				b.handleError(ctx, msg, fr, span, err)
+			}
+			result.Skipped++
+			if ackErr := b.skip(ctx, b.Log, msg, b.OnDecodeError, err); ackErr != nil {
+				return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, errors.Join(unmarshalErr, ackErr))
+			}
+			continue
+		}
+		if b.schema != nil && !skipSchema {
+			if err := validateSchema(b.schema, data); err != nil {
+				b.Log.Warn("Message failed schema validation", slog.Any("error", err))
+				if span != nil {
+					recordSpanError(span, err)
+					span.End()
+				}
+				b.handleError(ctx, msg, fr, span, err)
+				op := msg.Ack
+				if b.schemaViolationPolicy == NakSchemaViolations {
+					op = msg.Nak
+				}
+				result.Skipped++
+				if ackErr := op(); ackErr != nil {
+					return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, errors.Join(err, ackErr))
+				}
+				continue
+			}
+		}
+		var claim uint64
+		if b.idempotency != nil {
+			id := b.idempotency.id(msg, fr)
+			_, duplicate := seen[id]
+			if !duplicate {
+				claim, duplicate, err = b.idempotency.begin(ctx, msg, fr)
+			}
+			if err != nil || duplicate {
+				op := msg.Ack
+				if err != nil {
+					// Redeliver the message, so that it can be checked again.
+					b.Log.Warn("Failed to check whether message was processed", slog.Any("error", err))
+					op = msg.Nak
+				} else {
+					b.Log.Debug("Skipping duplicate message", slog.String("subject", msg.Subject()))
+				}
+				if span != nil {
+					recordSpanError(span, err)
+					span.End()
+				}
+				result.Skipped++
+				if ackErr := op(); ackErr != nil {
+					return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, errors.Join(err, ackErr))
+				}
+				continue
+			}
+			seen[id] = struct{}{}
+		}
+		b.observeRedelivery(msg, fr)
 		msgBodies = append(msgBodies, fr)
 		msgs = append(msgs, msg)
+		spans = append(spans, span)
+		claims = append(claims, claim)
+	}
+	if b.metrics != nil {
+		b.metrics.ObserveFetch(b.metricLabels, time.Since(fetchStart))
+	}
+	if b.adaptive != nil {
+		b.adaptive.observe(last)
+	}
+	if cancelled {
+		b.Log.Debug("Context cancelled while reading messages, redelivering batch", slog.Int("count", len(msgs)))
+		return b.abandon(ctx, result, received, msgs, msgBodies, claims, spans, fmt.Errorf("stopped reading messages: %w", ctx.Err()))
+	}
+	if err := mb.Error(); err != nil {
+		if result.Fetched == 0 {
+			return result, fmt.Errorf("failed to fetch: %w", err)
+		}
+		b.Log.Warn("Fetch ended early", slog.Int("count", result.Fetched), slog.Any("error", err))
+	}
+	if result.Fetched == 0 {
+		b.Log.Debug("No messages available, returning")
+		return result, ErrNoMessages
 	}
 	if len(msgs) == 0 {
 		b.Log.Debug("No messages, returning")
-		return nil
+		return result, nil
+	}
+
+	// Wait for the rate limiter.
+	if b.limiter != nil {
+		b.Log.Debug("Waiting for rate limiter", slog.Int("count", len(msgs)))
+		for range msgs {
+			if err = b.limiter.Wait(ctx); err != nil {
+				err = fmt.Errorf("failed to wait for rate limiter: %w", err)
+				endSpans(spans, err)
+				result.Nacked = len(msgs)
+				b.releaseClaims(ctx, msgs, msgBodies, claims)
+				return result, errors.Join(err, nakAll(msgs))
+			}
+		}
 	}
 
 	// Process messages.
 	b.Log.Debug("Processing messages", slog.Int("count", len(msgs)))
-	errs := b.processor(ctx, msgBodies)
+	processStart := time.Now()
+	errs, err := b.process(ctx, msgBodies)
+	if b.metrics != nil {
+		b.metrics.ObserveBatchSize(b.metricLabels, len(msgs))
+		b.metrics.ObserveProcess(b.metricLabels, time.Since(processStart))
+	}
+	if err != nil {
+		endSpans(spans, err)
+		if b.metrics != nil {
+			b.metrics.AddFailed(b.metricLabels, len(msgs))
+		}
+		result.Nacked = len(msgs)
+		b.releaseClaims(ctx, msgs, msgBodies, claims)
+		return result, errors.Join(err, nakAll(msgs))
+	}
+	if len(errs) == 0 {
+		// All of the messages were processed successfully.
+		errs = make([]error, len(msgs))
+	}
 	if len(errs) != len(msgs) {
-		return fmt.Errorf("expected a slice of %d errors - one for each msg, but got %d", len(msgs), len(errs))
+		err = ResultMismatchError{Expected: len(msgs), Actual: len(errs)}
+		endSpans(spans, err)
+		b.releaseClaims(ctx, msgs, msgBodies, claims)
+		return result, err
 	}
 
 	// Ack or nack messages based on their error state.
-	var errCount int
+	var errCount, termCount int
 	b.Log.Debug("Acknowledging messages", slog.Int("count", len(msgs)))
 	nackAckErrs := make([]error, len(errs))
+	var stopped bool
 	for i, err := range errs {
-		op := msgs[i].Ack
-		if err != nil {
+		decision, isDecision := decisionFromError(err)
+		if isDecision {
+			// The processor chose what to do with the message, so it's not an error.
+			err = nil
+		}
+		if stopped {
+			// In ordered mode, everything after the first failure is redelivered.
+			decision = Nak
+		} else if err != nil {
 			b.Log.Warn("Error processing message", slog.Any("error", err))
+			// Call the error handler hooks.
+			b.handleError(ctx, msgs[i], msgBodies[i], spans[i], err)
+			decision = b.nakDecision(msgs[i])
+		}
+		if decision != Ack {
 			errCount++
-			// Call the error handler hook.
-			if b.ErrorHandler != nil {
-				b.ErrorHandler(msgBodies[i], err)
+			if decision == Term {
+				termCount++
 			}
-			op = msgs[i].Nak
+			stopped = b.ordered
+		}
+		if b.idempotency != nil {
+			b.idempotency.end(context.WithoutCancel(ctx), b.Log, msgs[i], msgBodies[i], claims[i], decision == Ack)
 		}
-		nackAckErrs[i] = op()
+		nackAckErrs[i] = decision.apply(ctx, msgs[i], b.ackSync)
+		if spans[i] != nil {
+			recordSpanError(spans[i], err)
+			spans[i].End()
+		}
+	}
+	b.Log.Debug("Acknowledged messages", slog.Int("acks", len(msgs)-errCount), slog.Int("nacks", errCount-termCount), slog.Int("terms", termCount))
+	if b.metrics != nil {
+		b.metrics.AddProcessed(b.metricLabels, len(msgs)-errCount)
+		b.metrics.AddFailed(b.metricLabels, errCount)
+	}
+	result.Acked = len(msgs) - errCount
+	result.Nacked = errCount - termCount
+	result.Termed = termCount
+	return result, newAckError(nackAckErrs)
+}
+
+// abandon stops reading the batch, and returns the error. The messages that
+// were read are redelivered: their spans are ended, their claims are released,
+// and they're nacked, along with the rest of the batch, once the fetch has
+// finished.
+func (b *BatchProcessor[T]) abandon(ctx context.Context, result ProcessResult, received <-chan jetstream.Msg, msgs []jetstream.Msg, values []T, claims []uint64, spans []trace.Span, err error) (ProcessResult, error) {
+	endSpans(spans, err)
+	result.Nacked += len(msgs)
+	b.releaseClaims(ctx, msgs, values, claims)
+	go b.nakAfterFetch(received, msgs)
+	return result, err
+}
+
+// nakAfterFetch nacks the messages that were read from a batch before reading
+// was stopped, and the rest of the batch, once the fetch has finished. If they
+// were nacked while the fetch was still waiting for a full batch, they'd be
+// redelivered to the same fetch.
+func (b *BatchProcessor[T]) nakAfterFetch(received <-chan jetstream.Msg, msgs []jetstream.Msg) {
+	for msg := range received {
+		msgs = append(msgs, msg)
+	}
+	if err := nakAll(msgs); err != nil {
+		b.Log.Warn("Failed to nack messages", slog.Int("count", len(msgs)), slog.Any("error", err))
+	}
+}
+
+// releaseClaims releases the idempotency claims, if any, on messages that are
+// being redelivered. The claims are released even if the context has been cancelled,
+// so that the messages aren't skipped when they're redelivered.
+func (b *BatchProcessor[T]) releaseClaims(ctx context.Context, msgs []jetstream.Msg, values []T, claims []uint64) {
+	if b.idempotency == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for i, msg := range msgs {
+		b.idempotency.end(ctx, b.Log, msg, values[i], claims[i], false)
+	}
+}
+
+func nakAll(msgs []jetstream.Msg) error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = msg.Nak()
 	}
-	b.Log.Debug("Acknowledged messages", slog.Int("acks", len(msgs)-errCount), slog.Int("nacks", errCount))
-	return errors.Join(nackAckErrs...)
+	return errors.Join(errs...)
 }
@@ -3,13 +3,24 @@ package natsjson
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/a-h/natsjson/natsjsontest"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type BatchMessage struct {
@@ -18,7 +29,7 @@ type BatchMessage struct {
 
 func TestBatchProcessor(t *testing.T) {
 	// Arrange.
-	conn, js, shutdown, err := NewInProcessNATSServer()
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -46,14 +57,20 @@ func TestBatchProcessor(t *testing.T) {
 
 	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
 
+	t.Run("Consumer returns the underlying consumer", func(t *testing.T) {
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, func(ctx context.Context, msgs []BatchMessage) []error { return nil })
+		if bp.Consumer() != consumer {
+			t.Error("expected the consumer passed to NewBatchProcessor")
+		}
+	})
 	t.Run("if no messages are present in the stream, the processor function is not called", func(t *testing.T) {
 		p := func(ctx context.Context, msgs []BatchMessage) []error {
 			t.Errorf("unexpected call to process function")
 			return make([]error, len(msgs))
 		}
 		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithLogger[BatchMessage](log))
-		if err := bp.Process(ctx); err != nil {
-			t.Fatalf("unexpected error processing batch: %v", err)
+		if err := bp.Process(ctx); !errors.Is(err, ErrNoMessages) {
+			t.Fatalf("expected ErrNoMessages, got %v", err)
 		}
 	})
 	t.Run("the batch processor receives batches of messages", func(t *testing.T) {
@@ -132,7 +149,7 @@ func TestBatchProcessor(t *testing.T) {
 		}
 		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
 		for i := 0; i < 3; i++ {
-			if err := bp.Process(ctx); err != nil {
+			if err := bp.Process(ctx); err != nil && !errors.Is(err, ErrNoMessages) {
 				t.Fatalf("unexpected error processing batch: %v", err)
 			}
 		}
@@ -205,4 +222,1616 @@ func TestBatchProcessor(t *testing.T) {
 			t.Error(diff)
 		}
 	})
+	t.Run("metrics are recorded for each batch", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}, BatchMessage{Index: 2})
+		if err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		metrics := &testBatchMetrics{}
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithMetrics[BatchMessage](metrics))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		expectedLabels := MetricLabels{Stream: streamName, Consumer: "testBatchProcessor"}
+		if diff := cmp.Diff(expectedLabels, metrics.labels); diff != "" {
+			t.Error(diff)
+		}
+		if metrics.fetches != 1 {
+			t.Errorf("expected 1 fetch, got %d", metrics.fetches)
+		}
+		if diff := cmp.Diff([]int{3}, metrics.batchSizes); diff != "" {
+			t.Error(diff)
+		}
+		if metrics.processed != 3 {
+			t.Errorf("expected 3 processed, got %d", metrics.processed)
+		}
+		if metrics.failed != 0 {
+			t.Errorf("expected 0 failed, got %d", metrics.failed)
+		}
+	})
+	t.Run("trace context is propagated from the publisher to the processor", func(t *testing.T) {
+		// Arrange.
+		tp := &testTracerProvider{}
+		propagator := propagation.TraceContext{}
+		traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		publishCtx := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			TraceFlags: trace.FlagsSampled,
+		}))
+		pub := NewPublisher[BatchMessage](conn, WithPublisherTracing[BatchMessage](tp, propagator))
+		if err := pub.PublishWithContext(publishCtx, "batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		if err := conn.Publish("batch-message", []byte("{ _this_is_not_json_ }")); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithTracing[BatchMessage](tp, propagator))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if len(tp.spans) != 3 {
+			t.Fatalf("expected 3 spans, got %d", len(tp.spans))
+		}
+		producer, consumer, invalid := tp.spans[0], tp.spans[1], tp.spans[2]
+		if producer.name != "batch-message publish" || producer.parent.TraceID() != traceID {
+			t.Errorf("unexpected producer span %q with trace ID %v", producer.name, producer.parent.TraceID())
+		}
+		if consumer.name != "batch-message process" || consumer.parent.TraceID() != traceID || !consumer.parent.IsRemote() {
+			t.Errorf("unexpected consumer span %q with trace ID %v", consumer.name, consumer.parent.TraceID())
+		}
+		if consumer.status != codes.Unset {
+			t.Errorf("expected consumer span status to be unset, got %v", consumer.status)
+		}
+		if invalid.status != codes.Error {
+			t.Errorf("expected invalid message span status to be an error, got %v", invalid.status)
+		}
+	})
+	t.Run("MessageErrorHandler receives the context of the message's span", func(t *testing.T) {
+		// Arrange.
+		tp := &testTracerProvider{}
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var calls int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			calls++
+			errs := make([]error, len(msgs))
+			if calls == 1 {
+				errs[0] = errors.New("failed")
+			}
+			return errs
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithTracing[BatchMessage](tp, propagation.TraceContext{}))
+		var spans []trace.Span
+		bp.MessageErrorHandler = func(ctx context.Context, info MessageInfo[BatchMessage], err error) {
+			spans = append(spans, trace.SpanFromContext(ctx))
+		}
+		for i := 0; i < 2; i++ {
+			if err := bp.Process(ctx); err != nil {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+		}
+
+		// Assert.
+		if len(spans) != 1 {
+			t.Fatalf("expected 1 error handler call, got %d", len(spans))
+		}
+		if spans[0] != tp.spans[0] {
+			t.Errorf("expected the message's span, got %v", spans[0])
+		}
+	})
+	t.Run("the rate limiter respects context cancellation", func(t *testing.T) {
+		// Arrange.
+		expected := []BatchMessage{{Index: 0}, {Index: 1}}
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", expected...); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		// The limiter allows a single message, then has to wait a second for the next.
+		limited := func(ctx context.Context, msgs []BatchMessage) []error {
+			t.Errorf("unexpected call to process function")
+			return make([]error, len(msgs))
+		}
+		bpLimited := NewBatchProcessor[BatchMessage](consumer, 10, limited, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithRateLimit[BatchMessage](1, 1))
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+		defer cancel()
+		if err := bpLimited.Process(timeoutCtx); err == nil {
+			t.Error("expected an error waiting for the rate limiter, got nil")
+		}
+
+		// Assert.
+		// The messages were nacked, so they can be processed again.
+		var actual []BatchMessage
+		succeed := func(ctx context.Context, msgs []BatchMessage) []error {
+			actual = append(actual, msgs...)
+			return make([]error, len(msgs))
+		}
+		bpSucceed := NewBatchProcessor[BatchMessage](consumer, 10, succeed, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
+		if err := bpSucceed.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with FetchNoWait, ErrNoMessages is returned when the consumer is drained", func(t *testing.T) {
+		// Arrange.
+		expected := []BatchMessage{{Index: 0}, {Index: 1}, {Index: 2}}
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", expected...); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		if err := conn.Flush(); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+
+		// Act.
+		var actual []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			actual = append(actual, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 2, p, WithFetchNoWait[BatchMessage]())
+		var batches int
+		for {
+			err := bp.Process(ctx)
+			if err == ErrNoMessages {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+			batches++
+			if batches > 10 {
+				t.Fatal("expected the consumer to be drained")
+			}
+		}
+
+		// Assert.
+		if batches != 2 {
+			t.Errorf("expected 2 batches, got %d", batches)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("ProcessWithResult returns the number of messages handled", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		if err := conn.Publish("batch-message", []byte("{ _this_is_not_json_ }")); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		errFailed := errors.New("failed")
+		var calls int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			calls++
+			errs := make([]error, len(msgs))
+			if calls == 1 {
+				errs[1] = errFailed
+			}
+			return errs
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		expected := ProcessResult{Fetched: 3, Acked: 1, Nacked: 1, Skipped: 1}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Error(diff)
+		}
+
+		// Process the nacked message.
+		result, err = bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		expected = ProcessResult{Fetched: 1, Acked: 1}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithOrdered, messages after the first failure are nacked", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}, BatchMessage{Index: 2}, BatchMessage{Index: 3}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		var batches [][]BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			batches = append(batches, msgs)
+			errs := make([]error, len(msgs))
+			if len(batches) == 1 {
+				// Fail the second message of the first batch.
+				errs[1] = errors.New("failed")
+			}
+			return errs
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithOrdered[BatchMessage]())
+		var errorHandlerCalls int
+		bp.ErrorHandler = func(msg BatchMessage, err error) {
+			errorHandlerCalls++
+		}
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 4, Acked: 1, Nacked: 3}, result); diff != "" {
+			t.Error(diff)
+		}
+		if _, err := bp.ProcessWithResult(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		expected := [][]BatchMessage{
+			{{Index: 0}, {Index: 1}, {Index: 2}, {Index: 3}},
+			{{Index: 1}, {Index: 2}, {Index: 3}},
+		}
+		if diff := cmp.Diff(expected, batches); diff != "" {
+			t.Error(diff)
+		}
+		if errorHandlerCalls != 1 {
+			t.Errorf("expected 1 error handler call, got %d", errorHandlerCalls)
+		}
+	})
+	t.Run("middleware wraps the processor in order", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var calls []string
+		record := func(name string) Middleware[BatchMessage] {
+			return func(next BatchFunc[BatchMessage]) BatchFunc[BatchMessage] {
+				return func(ctx context.Context, msgs []BatchMessage) []error {
+					calls = append(calls, name+" before")
+					errs := next(ctx, msgs)
+					calls = append(calls, name+" after")
+					return errs
+				}
+			}
+		}
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			calls = append(calls, "processor")
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithMiddleware(record("a"), record("b")))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		expected := []string{"a before", "b before", "processor", "b after", "a after"}
+		if diff := cmp.Diff(expected, calls); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("MessageErrorHandler receives the message metadata", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		errFailed := errors.New("failed")
+		var calls int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			calls++
+			errs := make([]error, len(msgs))
+			if calls == 1 {
+				errs[0] = errFailed
+			}
+			return errs
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
+		var infos []MessageInfo[BatchMessage]
+		bp.MessageErrorHandler = func(ctx context.Context, info MessageInfo[BatchMessage], err error) {
+			if err != errFailed {
+				t.Errorf("expected %v, got %v", errFailed, err)
+			}
+			infos = append(infos, info)
+		}
+		for i := 0; i < 2; i++ {
+			if err := bp.Process(ctx); err != nil {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+		}
+
+		// Assert.
+		if len(infos) != 1 {
+			t.Fatalf("expected 1 error handler call, got %d", len(infos))
+		}
+		info := infos[0]
+		if info.Subject != "batch-message" {
+			t.Errorf("expected subject %q, got %q", "batch-message", info.Subject)
+		}
+		if info.NumDelivered != 1 {
+			t.Errorf("expected 1 delivery, got %d", info.NumDelivered)
+		}
+		if info.Sequence == 0 {
+			t.Error("expected a non-zero sequence number")
+		}
+		if diff := cmp.Diff(BatchMessage{Index: 0}, info.Value); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("OnDecodeError receives the raw data of invalid messages", func(t *testing.T) {
+		// Arrange.
+		invalid := []byte("{ _this_is_not_json_ }")
+		if err := conn.Publish("batch-message", invalid); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			t.Errorf("unexpected call to process function")
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
+		var raw [][]byte
+		bp.OnDecodeError = func(data []byte, subject string, err error) DecodeAction {
+			if subject != "batch-message" {
+				t.Errorf("expected subject %q, got %q", "batch-message", subject)
+			}
+			raw = append(raw, data)
+			if len(raw) == 1 {
+				return DecodeNak
+			}
+			return DecodeTerm
+		}
+		for i := 0; i < 3; i++ {
+			if err := bp.Process(ctx); err != nil && !errors.Is(err, ErrNoMessages) {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+		}
+
+		// Assert.
+		// The message was nacked, redelivered, then terminated.
+		expected := [][]byte{invalid, invalid}
+		if diff := cmp.Diff(expected, raw); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithQuarantine, invalid messages are republished to the quarantine subject", func(t *testing.T) {
+		// Arrange.
+		quarantine, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "quarantine",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create quarantine stream: %v", err)
+		}
+		invalid := []byte("{ _this_is_not_json_ }")
+		if err := conn.Publish("batch-message", invalid); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			t.Errorf("unexpected call to process function")
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithQuarantine[BatchMessage](js, "quarantine.batch-message"))
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if result.Skipped != 1 {
+			t.Errorf("expected 1 skipped message, got %d", result.Skipped)
+		}
+		msg, err := quarantine.GetLastMsgForSubject(ctx, "quarantine.batch-message")
+		if err != nil {
+			t.Fatalf("failed to get quarantined message: %v", err)
+		}
+		if diff := cmp.Diff(invalid, msg.Data); diff != "" {
+			t.Error(diff)
+		}
+		if subject := msg.Header.Get(QuarantineSubjectHeader); subject != "batch-message" {
+			t.Errorf("expected original subject %q, got %q", "batch-message", subject)
+		}
+		if msg.Header.Get(QuarantineErrorHeader) == "" {
+			t.Error("expected the error header to be set")
+		}
+	})
+	t.Run("ReplayQuarantined republishes quarantined messages to their original subject", func(t *testing.T) {
+		// Arrange.
+		quarantine, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "quarantine",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create quarantine stream: %v", err)
+		}
+		qmsg := nats.NewMsg("quarantine.replay")
+		qmsg.Data = []byte(`{"Index":5}`)
+		qmsg.Header.Set("Tenant-Id", "tenantA")
+		qmsg.Header.Set(QuarantineErrorHeader, "failed to unmarshal")
+		qmsg.Header.Set(QuarantineSubjectHeader, "batch-message")
+		if _, err := js.PublishMsg(ctx, qmsg); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithQuarantine[BatchMessage](js, "quarantine.replay"))
+
+		// Act.
+		if err := bp.ReplayQuarantined(ctx, ""); err != nil {
+			t.Fatalf("unexpected error replaying messages: %v", err)
+		}
+
+		// Assert.
+		stream, err := js.Stream(ctx, streamName)
+		if err != nil {
+			t.Fatalf("failed to get stream: %v", err)
+		}
+		replayed, err := stream.GetLastMsgForSubject(ctx, "batch-message")
+		if err != nil {
+			t.Fatalf("failed to get replayed message: %v", err)
+		}
+		if tenant := replayed.Header.Get("Tenant-Id"); tenant != "tenantA" {
+			t.Errorf("expected the original headers to be kept, got tenant %q", tenant)
+		}
+		if replayed.Header.Get(QuarantineErrorHeader) != "" || replayed.Header.Get(QuarantineSubjectHeader) != "" {
+			t.Errorf("expected the quarantine headers to be removed, got %v", replayed.Header)
+		}
+		if _, err := quarantine.GetLastMsgForSubject(ctx, "quarantine.replay"); !errors.Is(err, jetstream.ErrMsgNotFound) {
+			t.Errorf("expected the replayed message to be removed from the quarantine, got %v", err)
+		}
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: 5}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("ReplayQuarantined requires WithQuarantine", func(t *testing.T) {
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, func(ctx context.Context, msgs []BatchMessage) []error { return nil })
+		if err := bp.ReplayQuarantined(ctx, ""); !errors.Is(err, ErrQuarantineNotSet) {
+			t.Errorf("expected ErrQuarantineNotSet, got %v", err)
+		}
+	})
+	t.Run("with WithFilterSubjects, only messages on matching subjects are processed", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("orders-created", BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		if err := pub.Publish("orders-cancelled", BatchMessage{Index: 2}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithFilterSubjects[BatchMessage]("orders-created"))
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("NewBatchDecisionProcessor acks, terms or nacks each message based on the decision", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}, BatchMessage{Index: 2}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		var batches [][]BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []AckDecision {
+			batches = append(batches, msgs)
+			decisions := make([]AckDecision, len(msgs))
+			for i, msg := range msgs {
+				switch msg.Index {
+				case 0:
+					decisions[i] = Ack
+				case 1:
+					decisions[i] = Term
+				case 2:
+					decisions[i] = NakWithDelay(time.Millisecond * 50)
+				}
+			}
+			return decisions
+		}
+		var handledErrs []error
+		bp := NewBatchDecisionProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)))
+		bp.ErrorHandler = func(msg BatchMessage, err error) {
+			handledErrs = append(handledErrs, err)
+		}
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff(ProcessResult{Fetched: 3, Acked: 1, Nacked: 1, Termed: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+		if len(handledErrs) != 0 {
+			t.Errorf("expected decisions not to be passed to the error handler, got %v", handledErrs)
+		}
+		// Only the nacked message is redelivered, after the delay.
+		p2 := func(ctx context.Context, msgs []BatchMessage) []AckDecision {
+			batches = append(batches, msgs)
+			decisions := make([]AckDecision, len(msgs))
+			for i := range decisions {
+				decisions[i] = Ack
+			}
+			return decisions
+		}
+		bp = NewBatchDecisionProcessor[BatchMessage](consumer, 10, p2, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second)))
+		if err = bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		expected := [][]BatchMessage{
+			{{Index: 0}, {Index: 1}, {Index: 2}},
+			{{Index: 2}},
+		}
+		if diff := cmp.Diff(expected, batches); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithNakBackoff, failed messages are redelivered after the delay", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var attempts []int
+		backoff := func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond * 300 * time.Duration(attempt)
+		}
+		var calls int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			calls++
+			errs := make([]error, len(msgs))
+			if calls == 1 {
+				errs[0] = errors.New("failed")
+			}
+			return errs
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithNakBackoff[BatchMessage](backoff))
+
+		// Act.
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil && !errors.Is(err, ErrNoMessages) {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if result.Fetched != 0 {
+			t.Errorf("expected the message not to be redelivered before the delay, but fetched %d", result.Fetched)
+		}
+		bp = NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second)))
+		result, err = bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 1, Acked: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff([]int{1}, attempts); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithAckSync, acks are confirmed by the server", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithAckSync[BatchMessage]())
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+		// As the acks were confirmed, the consumer info is up to date without waiting.
+		info, err := consumer.Info(ctx)
+		if err != nil {
+			t.Fatalf("failed to get consumer info: %v", err)
+		}
+		if info.NumAckPending != 0 {
+			t.Errorf("expected no pending acks, got %d", info.NumAckPending)
+		}
+	})
+	t.Run("with WithDisallowUnknownFields, messages with unknown fields are skipped", func(t *testing.T) {
+		// Arrange.
+		if err := conn.Publish("batch-message", []byte(`{"Index":1,"Unknown":true}`)); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		if err := conn.Publish("batch-message", []byte(`{"Index":2}`)); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithDisallowUnknownFields[BatchMessage]())
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 2}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithIdempotency, messages that have already been processed are skipped", func(t *testing.T) {
+		// Arrange.
+		kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  "idempotency",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		id := func(msg BatchMessage) string {
+			return strconv.Itoa(msg.Index)
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithIdempotency[BatchMessage](kv, id))
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 10}, BatchMessage{Index: 11}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Act.
+		if err := pub.Publish("batch-message", BatchMessage{Index: 10}, BatchMessage{Index: 12}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 10}, {Index: 11}, {Index: 12}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithIdempotency, duplicates within a batch are only processed once", func(t *testing.T) {
+		// Arrange.
+		kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  "idempotency_batch",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		id := func(msg BatchMessage) string {
+			return strconv.Itoa(msg.Index)
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithIdempotency[BatchMessage](kv, id), WithAckSync[BatchMessage]())
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 1}, BatchMessage{Index: 1}, BatchMessage{Index: 2}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}, {Index: 2}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 3, Acked: 2, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	for _, claimFirst := range []bool{false, true} {
+		t.Run(fmt.Sprintf("with WithIdempotency, messages that fail are processed when they're redelivered (claim first: %v)", claimFirst), func(t *testing.T) {
+			// Arrange.
+			kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+				Bucket:  fmt.Sprintf("idempotency_failure_%v", claimFirst),
+				Storage: jetstream.MemoryStorage,
+			})
+			if err != nil {
+				t.Fatalf("failed to create bucket: %v", err)
+			}
+			var attempts int
+			p := func(ctx context.Context, msgs []BatchMessage) []error {
+				attempts++
+				if attempts == 1 {
+					return []error{errors.New("failed")}
+				}
+				return nil
+			}
+			id := func(msg BatchMessage) string {
+				return strconv.Itoa(msg.Index)
+			}
+			opts := []BatchProcessorOpt[BatchMessage]{WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond * 100)), WithIdempotency[BatchMessage](kv, id), WithAckSync[BatchMessage]()}
+			if claimFirst {
+				opts = append(opts, WithIdempotencyClaimFirst[BatchMessage]())
+			}
+			bp := NewBatchProcessor[BatchMessage](consumer, 10, p, opts...)
+			if err := NewPublisher[BatchMessage](conn).Publish("batch-message", BatchMessage{Index: 1}); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+			if _, err := bp.ProcessWithResult(ctx); err != nil {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+
+			// Act.
+			result, err := bp.ProcessWithResult(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+
+			// Assert.
+			if attempts != 2 {
+				t.Errorf("expected the message to be processed again, got %d attempts", attempts)
+			}
+			if diff := cmp.Diff(ProcessResult{Fetched: 1, Acked: 1}, result); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+	t.Run("with WithIdempotencyClaimFirst, processors that share a bucket only process each message once", func(t *testing.T) {
+		// Arrange.
+		kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  "idempotency_shared",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+		// Both consumers receive every message.
+		other, err := js.CreateOrUpdateConsumer(ctx, streamName, jetstream.ConsumerConfig{
+			Durable:       "testBatchProcessorShared",
+			DeliverPolicy: jetstream.DeliverNewPolicy,
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("unexpected failure creating consumer: %v", err)
+		}
+		defer js.DeleteConsumer(ctx, streamName, "testBatchProcessorShared")
+		var m sync.Mutex
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			m.Lock()
+			defer m.Unlock()
+			processed = append(processed, msgs...)
+			return nil
+		}
+		id := func(msg BatchMessage) string {
+			return strconv.Itoa(msg.Index)
+		}
+		processors := []*BatchProcessor[BatchMessage]{
+			NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithIdempotency[BatchMessage](kv, id), WithIdempotencyClaimFirst[BatchMessage](), WithAckSync[BatchMessage]()),
+			NewBatchProcessor[BatchMessage](other, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithIdempotency[BatchMessage](kv, id), WithIdempotencyClaimFirst[BatchMessage](), WithAckSync[BatchMessage]()),
+		}
+		msgs := make([]BatchMessage, 10)
+		for i := range msgs {
+			msgs[i] = BatchMessage{Index: i}
+		}
+		if err := NewPublisher[BatchMessage](conn).Publish("batch-message", msgs...); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		var wg sync.WaitGroup
+		for _, bp := range processors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bp.Process(ctx); err != nil {
+					t.Errorf("unexpected error processing batch: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Assert.
+		slices.SortFunc(processed, func(a, b BatchMessage) int { return a.Index - b.Index })
+		if diff := cmp.Diff(msgs, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithProcessTimeout, the batch is nacked if the processor doesn't return in time", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		unblock := make(chan struct{})
+		defer close(unblock)
+		hung := func(ctx context.Context, msgs []BatchMessage) []error {
+			// Ignore the context.
+			<-unblock
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, hung, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithProcessTimeout[BatchMessage](time.Millisecond*50))
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+
+		// Assert.
+		if !errors.Is(err, ErrProcessTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected ErrProcessTimeout, got %v", err)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Nacked: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+		// The messages are redelivered.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("expected the context to have a deadline")
+			}
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp = NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithProcessTimeout[BatchMessage](time.Second))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: 0}, {Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("a nil slice of errors acks every message", func(t *testing.T) {
+		// Arrange.
+		if err := NewPublisher[BatchMessage](conn).Publish("batch-message", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithAckSync[BatchMessage]())
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("WithBatchMaxWait limits how long a fetch waits for messages", func(t *testing.T) {
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithBatchMaxWait[BatchMessage](time.Millisecond*50))
+		start := time.Now()
+		if err := bp.Process(ctx); !errors.Is(err, ErrNoMessages) {
+			t.Fatalf("expected ErrNoMessages, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected the fetch to return after the max wait, took %v", elapsed)
+		}
+	})
+	t.Run("WithBatchMaxWait rejects waits that aren't greater than zero", func(t *testing.T) {
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			t.Errorf("unexpected call to process function")
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithBatchMaxWait[BatchMessage](0))
+		if err := bp.Process(ctx); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption, got %v", err)
+		}
+		if err := bp.Run(ctx); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected Run to return ErrInvalidOption, got %v", err)
+		}
+	})
+	t.Run("if the context is cancelled while reading messages, the batch is nacked without waiting for the rest of it", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js))
+		if _, err := pub.PublishJetStream(ctx, "batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		if _, err := js.Publish(ctx, "batch-message", []byte("{ _this_is_not_json_ }")); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		for i := 1; i < 3; i++ {
+			if _, err := pub.PublishJetStream(ctx, "batch-message", BatchMessage{Index: i}); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		// The batch is larger than the number of messages, so the fetch waits
+		// for the max wait unless reading stops when the context is cancelled.
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second)))
+		cancelCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		bp.OnDecodeError = func(raw []byte, subject string, err error) DecodeAction {
+			cancel()
+			return DecodeAck
+		}
+
+		// Act.
+		start := time.Now()
+		result, err := bp.ProcessWithResult(cancelCtx)
+
+		// Assert.
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected processing to stop when the context was cancelled, took %v", elapsed)
+		}
+		// Messages that weren't read before the context was cancelled are
+		// nacked in the background, so they're not counted.
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Skipped: 1, Nacked: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+		if len(processed) != 0 {
+			t.Errorf("expected the processor not to be called, got %v", processed)
+		}
+		// The messages are nacked once the fetch has finished, and redelivered.
+		bp = NewBatchProcessor[BatchMessage](consumer, 3, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second*5)))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		sortByIndex := cmpopts.SortSlices(func(a, b BatchMessage) bool { return a.Index < b.Index })
+		if diff := cmp.Diff([]BatchMessage{{Index: 0}, {Index: 1}, {Index: 2}}, processed, sortByIndex); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithMaxAge, stale messages are skipped", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithMaxAge[BatchMessage](100*time.Millisecond), WithAckSync[BatchMessage]())
+		var ages []time.Duration
+		bp.OnStale = func(raw []byte, subject string, age time.Duration) DecodeAction {
+			ages = append(ages, age)
+			return DecodeAck
+		}
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1, Stale: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+		if len(ages) != 1 || ages[0] < 100*time.Millisecond {
+			t.Errorf("expected OnStale to be called with an age over 100ms, got %v", ages)
+		}
+	})
+	t.Run("with WithMaxAge and WithQuarantine, stale messages are acked rather than quarantined by default", func(t *testing.T) {
+		// Arrange.
+		quarantine, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "quarantine",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create quarantine stream: %v", err)
+		}
+		if err := NewPublisher[BatchMessage](conn).Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+
+		// Act.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			t.Errorf("unexpected call to process function")
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithMaxAge[BatchMessage](100*time.Millisecond), WithQuarantine[BatchMessage](js, "quarantine.stale"), WithAckSync[BatchMessage]())
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff(ProcessResult{Fetched: 1, Skipped: 1, Stale: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+		if _, err := quarantine.GetLastMsgForSubject(ctx, "quarantine.stale"); !errors.Is(err, jetstream.ErrMsgNotFound) {
+			t.Errorf("expected the message not to be quarantined, got %v", err)
+		}
+	})
+	t.Run("with WithEmptyAsZeroValue, empty messages are passed to the processor as the zero value", func(t *testing.T) {
+		// Arrange.
+		if err := conn.Publish("batch-message", nil); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		if err := conn.Publish("batch-message", []byte(`{"index":1}`)); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)), WithEmptyAsZeroValue[BatchMessage](), WithAckSync[BatchMessage]())
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{}, {Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithBatchWindow, a partial batch is returned once the window elapses", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		time.AfterFunc(50*time.Millisecond, func() {
+			if err := pub.Publish("batch-message", BatchMessage{Index: 1}); err != nil {
+				t.Errorf("unexpected failure sending test message: %v", err)
+			}
+		})
+
+		// Act.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithBatchMaxWait[BatchMessage](5*time.Second), WithBatchWindow[BatchMessage](300*time.Millisecond), WithAckSync[BatchMessage]())
+		start := time.Now()
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected the batch to be returned after the window, took %v", elapsed)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: 0}, {Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("WithBatchWindow rejects windows that aren't greater than zero", func(t *testing.T) {
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, nil, WithBatchWindow[BatchMessage](0))
+		if err := bp.Process(ctx); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption, got %v", err)
+		}
+	})
+	t.Run("with WithHeaderFilter, only messages with a matching header are processed", func(t *testing.T) {
+		// Arrange.
+		for _, typ := range []string{"order", "invoice", "", "refund"} {
+			msg := nats.NewMsg("batch-message")
+			msg.Data = []byte(`{"index":1}`)
+			if typ != "" {
+				msg.Header.Set("type", typ)
+			}
+			if err := conn.PublishMsg(msg); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+
+		// Act.
+		var processed int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed += len(msgs)
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)),
+			WithHeaderFilter[BatchMessage]("type", "order"), WithHeaderFilter[BatchMessage]("type", "refund"), WithAckSync[BatchMessage]())
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if processed != 2 {
+			t.Errorf("expected 2 messages to be processed, got %d", processed)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 4, Acked: 2, Skipped: 2, Filtered: 2}, result); diff != "" {
+			t.Error(diff)
+		}
+		if err := WaitForDrain(ctx, consumer); err != nil {
+			t.Errorf("expected skipped messages to be acked: %v", err)
+		}
+	})
+	t.Run("with WithIdempotencyFromMsg, the ID can be read from the message headers", func(t *testing.T) {
+		// Arrange.
+		kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:  "idempotency_headers",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+		id := func(msg jetstream.Msg, value BatchMessage) string {
+			return msg.Headers().Get("Correlation-Id")
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithIdempotencyFromMsg[BatchMessage](kv, id), WithAckSync[BatchMessage]())
+		publish := func(t *testing.T, correlationID string, index int) {
+			t.Helper()
+			msg := nats.NewMsg("batch-message")
+			msg.Header.Set("Correlation-Id", correlationID)
+			msg.Data = []byte(`{"index":` + strconv.Itoa(index) + `}`)
+			if err := conn.PublishMsg(msg); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+		publish(t, "a", 1)
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Act.
+		publish(t, "a", 2)
+		publish(t, "b", 3)
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}, {Index: 3}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithAdaptiveBatch, the batch size follows the number of pending messages", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		for i := 0; i < 5; i++ {
+			if err := pub.Publish("batch-message", BatchMessage{Index: i}); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+		if err := pub.Flush(ctx); err != nil {
+			t.Fatalf("unexpected error flushing: %v", err)
+		}
+
+		// Act.
+		var sizes []int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			sizes = append(sizes, len(msgs))
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(100*time.Millisecond)), WithAdaptiveBatch[BatchMessage](1, 3), WithAckSync[BatchMessage]())
+		for i := 0; i < 3; i++ {
+			if err := bp.Process(ctx); err != nil {
+				t.Fatalf("unexpected error processing batch %d: %v", i, err)
+			}
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]int{1, 3, 1}, sizes); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("WithAdaptiveBatch rejects invalid sizes", func(t *testing.T) {
+		for _, size := range [][2]int{{0, 1}, {2, 1}} {
+			bp := NewBatchProcessor[BatchMessage](consumer, 10, nil, WithAdaptiveBatch[BatchMessage](size[0], size[1]))
+			if err := bp.Process(ctx); !errors.Is(err, ErrInvalidOption) {
+				t.Errorf("%v: expected ErrInvalidOption, got %v", size, err)
+			}
+		}
+	})
+	t.Run("OnRedelivery is called once a message reaches the redelivery threshold", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("batch-message", BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var attempts int
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			attempts++
+			if attempts < 3 {
+				return []error{errors.New("failed")}
+			}
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(100*time.Millisecond)), WithRedeliveryThreshold[BatchMessage](3), WithAckSync[BatchMessage]())
+		var deliveries []int
+		bp.OnRedelivery = func(info MessageInfo[BatchMessage], n int) {
+			if info.Value.Index != 1 {
+				t.Errorf("unexpected message %+v", info.Value)
+			}
+			deliveries = append(deliveries, n)
+		}
+
+		// Act.
+		for i := 0; i < 3; i++ {
+			if err := bp.Process(ctx); err != nil {
+				t.Fatalf("unexpected error processing batch %d: %v", i, err)
+			}
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]int{3}, deliveries); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestProcessorResultMismatch(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "mismatch",
+		Subjects: []string{"mismatch"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "mismatch", jetstream.ConsumerConfig{
+		Durable:       "mismatchProcessor",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	if err := NewPublisher[BatchMessage](conn).Publish("mismatch", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+		t.Fatalf("unexpected failure sending test messages: %v", err)
+	}
+	p := func(ctx context.Context, msgs []BatchMessage) []error {
+		return make([]error, 1)
+	}
+	bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second)))
+
+	// Act.
+	err = bp.Process(ctx)
+
+	// Assert.
+	if !errors.Is(err, ErrProcessorResultMismatch) {
+		t.Fatalf("expected ErrProcessorResultMismatch, got %v", err)
+	}
+	var mismatch ResultMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a ResultMismatchError, got %T", err)
+	}
+	if diff := cmp.Diff(ResultMismatchError{Expected: 2, Actual: 1}, mismatch); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestDefaultBatchMaxWait(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the default max wait")
+	}
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	if _, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "default_max_wait",
+		Subjects: []string{"default_max_wait"},
+		Storage:  jetstream.MemoryStorage,
+	}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "default_max_wait", jetstream.ConsumerConfig{
+		Durable:       "defaultMaxWait",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	bp := NewBatchProcessor[BatchMessage](consumer, 10, nil)
+
+	start := time.Now()
+	if err := bp.Process(ctx); !errors.Is(err, ErrNoMessages) {
+		t.Fatalf("expected ErrNoMessages, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < DefaultBatchMaxWait || elapsed > DefaultBatchMaxWait+2*time.Second {
+		t.Errorf("expected the fetch to wait for %v, took %v", DefaultBatchMaxWait, elapsed)
+	}
+}
+
+func TestAckError(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	if _, err = EnsureStream(ctx, js, jetstream.StreamConfig{Name: "ack_error", Storage: jetstream.MemoryStorage}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "ack_error", jetstream.ConsumerConfig{Durable: "ackError"})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](conn)
+	if err := pub.Publish("ack_error.a", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+		t.Fatalf("unexpected failure sending test messages: %v", err)
+	}
+	p := func(ctx context.Context, msgs []BatchMessage) []error {
+		// Simulate losing the connection during processing.
+		conn.Close()
+		return nil
+	}
+	bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Second)))
+
+	// Act.
+	err = bp.Process(ctx)
+
+	// Assert.
+	if !errors.Is(err, ErrAckFailed) {
+		t.Fatalf("expected ErrAckFailed, got %v", err)
+	}
+	var ackErr AckError
+	if !errors.As(err, &ackErr) {
+		t.Fatalf("expected an AckError, got %T", err)
+	}
+	if !ackErr.AllFailed() || ackErr.Total != 2 {
+		t.Errorf("expected all 2 acks to fail, got %d of %d", ackErr.Failed, ackErr.Total)
+	}
+	if !errors.Is(err, nats.ErrConnectionClosed) {
+		t.Errorf("expected the error to wrap nats.ErrConnectionClosed, got %v", err)
+	}
+}
+
+type testTracerProvider struct {
+	noop.TracerProvider
+	spans []*testSpan
+}
+
+func (tp *testTracerProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return testTracer{tp: tp}
+}
+
+type testTracer struct {
+	noop.Tracer
+	tp *testTracerProvider
+}
+
+func (t testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	ctx, span := t.Tracer.Start(ctx, name, opts...)
+	s := &testSpan{Span: span, name: name, parent: trace.SpanContextFromContext(ctx)}
+	t.tp.spans = append(t.tp.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type testSpan struct {
+	trace.Span
+	name   string
+	parent trace.SpanContext
+	status codes.Code
+}
+
+func (s *testSpan) SetStatus(code codes.Code, description string) {
+	s.status = code
+}
+
+type testBatchMetrics struct {
+	labels     MetricLabels
+	fetches    int
+	batchSizes []int
+	processed  int
+	failed     int
+}
+
+func (m *testBatchMetrics) ObserveFetch(labels MetricLabels, d time.Duration) {
+	m.labels = labels
+	m.fetches++
+}
+
+func (m *testBatchMetrics) ObserveBatchSize(labels MetricLabels, size int) {
+	m.batchSizes = append(m.batchSizes, size)
+}
+
+func (m *testBatchMetrics) ObserveProcess(labels MetricLabels, d time.Duration) {}
+
+func (m *testBatchMetrics) AddProcessed(labels MetricLabels, n int) {
+	m.processed += n
+}
+
+func (m *testBatchMetrics) AddFailed(labels MetricLabels, n int) {
+	m.failed += n
+}
+
+// benchmarkMsg is a message that can be decoded without a server.
+type benchmarkMsg struct {
+	jetstream.Msg
+	data []byte
+}
+
+func (m benchmarkMsg) Data() []byte         { return m.data }
+func (m benchmarkMsg) Headers() nats.Header { return nil }
+
+func BenchmarkDecodeMsg(b *testing.B) {
+	msg := benchmarkMsg{data: []byte(`{"name":"user","age":42}`)}
+	decoders := []struct {
+		name string
+		d    decoder[User]
+	}{
+		{name: "default"},
+		{name: "DisallowUnknownFields", d: decoder[User]{json: jsonDecodeOpts{disallowUnknownFields: true}}},
+	}
+	for _, d := range decoders {
+		b.Run(d.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := d.d.decodeMsg(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkProcess(b *testing.B) {
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "benchmark",
+		Subjects: []string{"benchmark"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		b.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "benchmark", jetstream.ConsumerConfig{
+		Durable:       "benchmarkProcessor",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		b.Fatalf("failed to create consumer: %v", err)
+	}
+	const batchSize = 100
+	batch := make([]User, batchSize)
+	for i := range batch {
+		batch[i] = User{Name: fmt.Sprintf("user %d", i), Age: i}
+	}
+	pub := NewPublisher[User](conn, WithPublisherJetStream[User](js))
+	p := func(ctx context.Context, msgs []User) []error {
+		return nil
+	}
+	bp := NewBatchProcessor[User](consumer, batchSize, p, WithBatchMaxWait[User](time.Second), WithAckSync[User]())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for _, u := range batch {
+			if _, err := pub.PublishJetStream(ctx, "benchmark", u); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Acked != batchSize {
+			b.Fatalf("expected %d messages to be acked, got %d", batchSize, result.Acked)
+		}
+	}
 }
@@ -0,0 +1,53 @@
+package natsjson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	contentEncodingHeader = "Content-Encoding"
+	gzipContentEncoding   = "gzip"
+)
+
+// WithPublisherCompression gzip compresses the JSON body of each message, and
+// sets a "Content-Encoding: gzip" header.
+//
+// The batch processor decompresses messages with the header automatically,
+// and continues to decode messages without it, so consumers can be upgraded
+// before publishers start compressing messages.
+func WithPublisherCompression[T any]() PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.compress = true
+	}
+}
+
+func compress(data []byte) (compressed []byte, err error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns the message data, decompressing it if the headers show
+// that it's compressed.
+func decompress(header nats.Header, data []byte) (decompressed []byte, err error) {
+	if header == nil || header.Get(contentEncodingHeader) != gzipContentEncoding {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip header: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
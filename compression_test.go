@@ -0,0 +1,130 @@
+package natsjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestCompression(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	stream, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "compression",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "compression", jetstream.ConsumerConfig{
+		Durable:       "compressionProcessor",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	t.Run("compressed messages are published with a content encoding header", func(t *testing.T) {
+		pub := NewPublisher[BatchMessage](conn, WithPublisherCompression[BatchMessage]())
+		if err := pub.Publish("compression.compressed", BatchMessage{Index: 0}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		// The message is stored asynchronously, so wait for it to be available.
+		var msg *jetstream.RawStreamMsg
+		for i := 0; i < 50; i++ {
+			if msg, err = stream.GetLastMsgForSubject(ctx, "compression.compressed"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("failed to get message: %v", err)
+		}
+		if encoding := msg.Header.Get(contentEncodingHeader); encoding != gzipContentEncoding {
+			t.Errorf("expected content encoding %q, got %q", gzipContentEncoding, encoding)
+		}
+		if json.Valid(msg.Data) {
+			t.Error("expected the message body to be compressed")
+		}
+	})
+	t.Run("the batch processor decodes compressed and uncompressed messages", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](conn)
+		if err := pub.Publish("compression.uncompressed", BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Act.
+		var actual []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			actual = append(actual, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		expected := []BatchMessage{{Index: 0}, {Index: 1}}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+type compressionBenchmarkOrder struct {
+	ID       string                         `json:"id"`
+	Customer string                         `json:"customer"`
+	Status   string                         `json:"status"`
+	Lines    []compressionBenchmarkLineItem `json:"lines"`
+}
+
+type compressionBenchmarkLineItem struct {
+	SKU         string  `json:"sku"`
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unitPrice"`
+}
+
+func BenchmarkCompression(b *testing.B) {
+	order := compressionBenchmarkOrder{
+		ID:       "order-123",
+		Customer: "customer-456",
+		Status:   "pending",
+	}
+	for i := 0; i < 50; i++ {
+		order.Lines = append(order.Lines, compressionBenchmarkLineItem{
+			SKU:         fmt.Sprintf("sku-%d", i),
+			Description: "A widget of the standard size and shape",
+			Quantity:    i,
+			UnitPrice:   9.99,
+		})
+	}
+	data, err := json.Marshal(order)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var compressed []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compressed, err = compress(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/uncompressed")
+	b.ReportMetric(float64(len(compressed)), "bytes/compressed")
+}
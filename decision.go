@@ -0,0 +1,132 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type ackAction int
+
+const (
+	ackAck ackAction = iota
+	ackNak
+	ackTerm
+	ackInProgress
+)
+
+// AckDecision is what to do with a message once it has been processed.
+type AckDecision struct {
+	action ackAction
+	delay  time.Duration
+}
+
+var (
+	// Ack marks the message as processed.
+	Ack = AckDecision{action: ackAck}
+	// Nak redelivers the message.
+	Nak = AckDecision{action: ackNak}
+	// Term stops the message from being redelivered, regardless of the
+	// consumer's MaxDeliver setting.
+	Term = AckDecision{action: ackTerm}
+	// InProgress resets the message's AckWait timer without acking it, so that
+	// it's redelivered once the timer elapses.
+	InProgress = AckDecision{action: ackInProgress}
+)
+
+// NakWithDelay redelivers the message after the delay.
+func NakWithDelay(d time.Duration) AckDecision {
+	return AckDecision{action: ackNak, delay: d}
+}
+
+func (d AckDecision) String() string {
+	switch d.action {
+	case ackAck:
+		return "ack"
+	case ackNak:
+		if d.delay > 0 {
+			return fmt.Sprintf("nak with delay %v", d.delay)
+		}
+		return "nak"
+	case ackTerm:
+		return "term"
+	case ackInProgress:
+		return "in progress"
+	}
+	return "unknown"
+}
+
+// apply carries out the decision. If ackSync is set, acks wait for the server to
+// confirm that the ack was received.
+func (d AckDecision) apply(ctx context.Context, msg jetstream.Msg, ackSync bool) error {
+	switch d.action {
+	case ackNak:
+		if d.delay > 0 {
+			return msg.NakWithDelay(d.delay)
+		}
+		return msg.Nak()
+	case ackTerm:
+		return msg.Term()
+	case ackInProgress:
+		return msg.InProgress()
+	default:
+		if ackSync {
+			return msg.DoubleAck(ctx)
+		}
+		return msg.Ack()
+	}
+}
+
+// DecisionFunc processes a batch of messages, returning what to do with each
+// message. A nil or empty slice acks every message.
+type DecisionFunc[T any] func(ctx context.Context, messages []T) []AckDecision
+
+// NewBatchDecisionProcessor creates a BatchProcessor where the processor decides
+// what to do with each message, instead of returning an error, e.g. to terminate
+// messages that can never be processed.
+//
+// Middleware sees each decision other than Ack as an error.
+func NewBatchDecisionProcessor[T any](consumer jetstream.Consumer, batchSize int, processor DecisionFunc[T], opts ...BatchProcessorOpt[T]) *BatchProcessor[T] {
+	return NewBatchProcessor(consumer, batchSize, decisionsToErrors(processor), opts...)
+}
+
+// decisionError carries a decision through middleware as an error.
+type decisionError struct {
+	decision AckDecision
+}
+
+func (e decisionError) Error() string {
+	return "ack decision: " + e.decision.String()
+}
+
+func decisionsToErrors[T any](processor DecisionFunc[T]) BatchFunc[T] {
+	return func(ctx context.Context, messages []T) []error {
+		decisions := processor(ctx, messages)
+		if decisions == nil {
+			return nil
+		}
+		errs := make([]error, len(decisions))
+		for i, d := range decisions {
+			if d != Ack {
+				errs[i] = decisionError{decision: d}
+			}
+		}
+		return errs
+	}
+}
+
+// decisionFromError returns the decision for a processor result. Errors are
+// nacked, unless the error carries a decision from a DecisionFunc.
+func decisionFromError(err error) (d AckDecision, isDecision bool) {
+	if err == nil {
+		return Ack, false
+	}
+	var de decisionError
+	if errors.As(err, &de) {
+		return de.decision, true
+	}
+	return Nak, false
+}
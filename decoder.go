@@ -0,0 +1,81 @@
+package natsjson
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// decoder converts messages to T, and decides what to do with messages that
+// can't be decoded. It's shared by the batch and stream processors.
+type decoder[T any] struct {
+	// decode replaces the default JSON decoding if set. It returns the part of
+	// the data that's validated against the schema, e.g. the data of an
+	// envelope.
+	decode               func(data []byte, v *T) (payload []byte, err error)
+	json                 jsonDecodeOpts
+	currentSchemaVersion int
+	migrations           map[int]Migration[T]
+	emptyAsZero          bool
+	js                   jetstream.JetStream
+	quarantineSubject    string
+}
+
+// decodeMsg decompresses, migrates and decodes the message. The decompressed
+// data, or the payload returned by decode, is returned so that it can be
+// validated against a schema, unless
+// skipSchema is true, because the data was migrated, or is an empty message
+// that was decoded as the zero value.
+func (d *decoder[T]) decodeMsg(msg jetstream.Msg) (value T, data []byte, skipSchema bool, err error) {
+	data, err = decompress(msg.Headers(), msg.Data())
+	if err != nil {
+		return value, data, false, err
+	}
+	if len(data) == 0 && d.emptyAsZero {
+		return value, data, true, nil
+	}
+	value, migrated, err := d.migrate(msg.Headers(), data)
+	if err != nil || migrated {
+		return value, data, migrated, err
+	}
+	if d.decode != nil {
+		data, err = d.decode(data, &value)
+	} else {
+		err = unmarshalJSON(data, &value, d.json)
+	}
+	return value, data, false, err
+}
+
+// skip handles a message that couldn't be decoded. By default, the message is
+// acked, or quarantined if a quarantine subject is set, unless onDecodeError
+// returns a different action.
+func (d *decoder[T]) skip(ctx context.Context, log *slog.Logger, msg jetstream.Msg, onDecodeError func(raw []byte, subject string, err error) DecodeAction, decodeErr error) error {
+	action := DecodeAck
+	if d.quarantineSubject != "" {
+		action = DecodeQuarantine
+	}
+	if onDecodeError != nil {
+		action = onDecodeError(msg.Data(), msg.Subject(), decodeErr)
+	}
+	switch action {
+	case DecodeNak:
+		return msg.Nak()
+	case DecodeTerm:
+		return msg.Term()
+	case DecodeQuarantine:
+		if d.quarantineSubject == "" {
+			// Retrying won't help, so terminate the message instead of
+			// redelivering it forever.
+			log.Error("Can't quarantine message, because no quarantine subject is set, terminating it", slog.String("subject", msg.Subject()))
+			return msg.Term()
+		}
+		if err := d.quarantine(ctx, msg, decodeErr); err != nil {
+			log.Warn("Failed to quarantine message", slog.String("subject", msg.Subject()), slog.Any("error", err))
+			return msg.Nak()
+		}
+		return msg.Ack()
+	default:
+		return msg.Ack()
+	}
+}
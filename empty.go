@@ -0,0 +1,21 @@
+package natsjson
+
+// WithEmptyAsZeroValue passes messages with an empty body to the processor as
+// the zero value of T, e.g. to use empty messages as tombstones, instead of
+// treating them as messages that can't be decoded. Compressed messages are
+// checked after they're decompressed, and empty messages aren't migrated or
+// validated against a schema.
+func WithEmptyAsZeroValue[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.emptyAsZero = true
+	}
+}
+
+// WithStreamProcessorEmptyAsZeroValue passes messages with an empty body to the
+// handler as the zero value of T, instead of treating them as messages that
+// can't be decoded.
+func WithStreamProcessorEmptyAsZeroValue[T any]() StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.emptyAsZero = true
+	}
+}
@@ -0,0 +1,127 @@
+package natsjson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EnvelopeVersion is the version of the envelope format written by publishers.
+const EnvelopeVersion = 1
+
+// Envelope wraps a message with metadata, so that the metadata doesn't need to
+// be part of the message type. On the wire, it's a JSON object:
+//
+//	{ "meta": { "version": 1, ... }, "data": <T> }
+type Envelope[T any] struct {
+	Meta EnvelopeMeta `json:"meta"`
+	Data T            `json:"data"`
+}
+
+type EnvelopeMeta struct {
+	// Version of the envelope format.
+	Version int `json:"version"`
+	// ProducerID identifies the publisher of the message.
+	ProducerID string `json:"producerId,omitempty"`
+	// SchemaVersion is the version of the data's schema.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// Timestamp is the time that the message was published.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var (
+	ErrNotEnveloped               = errors.New("message is not enveloped")
+	ErrUnsupportedEnvelopeVersion = errors.New("unsupported envelope version")
+)
+
+// NonEnvelopedPolicy determines how messages without an envelope are decoded.
+type NonEnvelopedPolicy int
+
+const (
+	// RejectNonEnveloped treats messages without an envelope as decode errors.
+	RejectNonEnveloped NonEnvelopedPolicy = iota
+	// WrapNonEnveloped decodes messages without an envelope as the envelope's
+	// data, with empty metadata.
+	WrapNonEnveloped
+)
+
+// WithPublisherEnvelope wraps each message in an Envelope with the given
+// producer ID and schema version.
+func WithPublisherEnvelope[T any](producerID string, schemaVersion int) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.envelope = &EnvelopeMeta{
+			Version:       EnvelopeVersion,
+			ProducerID:    producerID,
+			SchemaVersion: schemaVersion,
+		}
+	}
+}
+
+// WithEnvelope unwraps enveloped messages, passing both the metadata and the
+// data to the processor. Messages without an envelope are handled according to
+// the policy.
+//
+// If WithSchema is also set, the schema is applied to the envelope's data, not
+// the envelope, in the same way as WithPublisherSchema. Likewise,
+// WithDisallowUnknownFields and WithUseNumber apply to the data.
+func WithEnvelope[T any](policy NonEnvelopedPolicy) BatchProcessorOpt[Envelope[T]] {
+	return func(bp *BatchProcessor[Envelope[T]]) {
+		bp.decode = func(data []byte, v *Envelope[T]) (payload []byte, err error) {
+			return unmarshalEnvelope(data, policy, v, bp.json)
+		}
+	}
+}
+
+// MarshalEnvelope encodes the value in an envelope with the metadata, in the
+// same wire format that's published by WithPublisherEnvelope, e.g. to write
+// contract tests for consumers in other languages. If the metadata's Version
+// is 0, it's set to EnvelopeVersion.
+func MarshalEnvelope[T any](meta EnvelopeMeta, v T) (envelope []byte, err error) {
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	if meta.Version == 0 {
+		meta.Version = EnvelopeVersion
+	}
+	return marshalEnvelope(meta, data)
+}
+
+// UnmarshalEnvelope decodes an enveloped message, in the same way as
+// WithEnvelope. Messages without an envelope are handled according to the
+// policy.
+func UnmarshalEnvelope[T any](data []byte, policy NonEnvelopedPolicy) (e Envelope[T], err error) {
+	_, err = unmarshalEnvelope(data, policy, &e, jsonDecodeOpts{})
+	return e, err
+}
+
+func marshalEnvelope(meta EnvelopeMeta, data []byte) (envelope []byte, err error) {
+	return Marshal(Envelope[json.RawMessage]{
+		Meta: meta,
+		Data: data,
+	})
+}
+
+// unmarshalEnvelope decodes the envelope into v, and returns the envelope's
+// data, or the whole message if it's not enveloped. The decode options apply to
+// the data, but not the envelope, so that fields can be added to the envelope's
+// metadata without breaking consumers that disallow unknown fields.
+func unmarshalEnvelope[T any](data []byte, policy NonEnvelopedPolicy, v *Envelope[T], opts jsonDecodeOpts) (payload []byte, err error) {
+	var envelope struct {
+		Meta *EnvelopeMeta   `json:"meta"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err = Unmarshal(data, &envelope); err != nil || envelope.Meta == nil {
+		if policy == RejectNonEnveloped {
+			return data, ErrNotEnveloped
+		}
+		v.Meta = EnvelopeMeta{}
+		return data, unmarshalJSON(data, &v.Data, opts)
+	}
+	if envelope.Meta.Version > EnvelopeVersion {
+		return data, fmt.Errorf("%w: %d", ErrUnsupportedEnvelopeVersion, envelope.Meta.Version)
+	}
+	v.Meta = *envelope.Meta
+	return envelope.Data, unmarshalJSON(envelope.Data, &v.Data, opts)
+}
@@ -0,0 +1,217 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestEnvelope(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "envelope",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	enveloped := NewPublisher[BatchMessage](conn, WithPublisherEnvelope[BatchMessage]("producer-1", 2))
+	if err = enveloped.Publish("envelope.messages", BatchMessage{Index: 0}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+	plain := NewPublisher[BatchMessage](conn)
+	if err = plain.Publish("envelope.messages", BatchMessage{Index: 1}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+
+	process := func(t *testing.T, durable string, policy NonEnvelopedPolicy) (actual []Envelope[BatchMessage]) {
+		consumer, err := EnsureConsumer(ctx, js, "envelope", jetstream.ConsumerConfig{
+			Durable:       durable,
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		p := func(ctx context.Context, msgs []Envelope[BatchMessage]) []error {
+			actual = append(actual, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[Envelope[BatchMessage]](consumer, 10, p,
+			WithFetchOpts[Envelope[BatchMessage]](jetstream.FetchMaxWait(time.Millisecond)),
+			WithEnvelope[BatchMessage](policy))
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		return actual
+	}
+
+	t.Run("enveloped messages are unwrapped, and non-enveloped messages can be rejected", func(t *testing.T) {
+		actual := process(t, "rejectNonEnveloped", RejectNonEnveloped)
+		if len(actual) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(actual))
+		}
+		if diff := cmp.Diff(BatchMessage{Index: 0}, actual[0].Data); diff != "" {
+			t.Error(diff)
+		}
+		meta := actual[0].Meta
+		if meta.Version != EnvelopeVersion || meta.ProducerID != "producer-1" || meta.SchemaVersion != 2 {
+			t.Errorf("unexpected metadata: %+v", meta)
+		}
+		if meta.Timestamp.IsZero() {
+			t.Error("expected the timestamp to be set")
+		}
+	})
+	t.Run("non-enveloped messages can be wrapped", func(t *testing.T) {
+		actual := process(t, "wrapNonEnveloped", WrapNonEnveloped)
+		if len(actual) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(actual))
+		}
+		expected := Envelope[BatchMessage]{Data: BatchMessage{Index: 1}}
+		if diff := cmp.Diff(expected, actual[1]); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("unsupported envelope versions are rejected", func(t *testing.T) {
+		var v Envelope[BatchMessage]
+		_, err := unmarshalEnvelope([]byte(`{"meta":{"version":2},"data":{"Index":1}}`), WrapNonEnveloped, &v, jsonDecodeOpts{})
+		if !errors.Is(err, ErrUnsupportedEnvelopeVersion) {
+			t.Errorf("expected ErrUnsupportedEnvelopeVersion, got %v", err)
+		}
+	})
+	t.Run("with WithSchema, the envelope's data is validated", func(t *testing.T) {
+		// Arrange.
+		consumer, err := EnsureConsumer(ctx, js, "envelope", jetstream.ConsumerConfig{
+			Durable:       "envelopeSchema",
+			FilterSubject: "envelope.schema",
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		if err = enveloped.Publish("envelope.schema", BatchMessage{Index: 1}, BatchMessage{Index: -1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		var actual []Envelope[BatchMessage]
+		p := func(ctx context.Context, msgs []Envelope[BatchMessage]) []error {
+			actual = append(actual, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[Envelope[BatchMessage]](consumer, 10, p,
+			WithFetchOpts[Envelope[BatchMessage]](jetstream.FetchMaxWait(100*time.Millisecond)),
+			WithEnvelope[BatchMessage](RejectNonEnveloped),
+			WithSchema[Envelope[BatchMessage]](nonNegativeIndexSchema{}, AckSchemaViolations))
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if len(actual) != 1 || actual[0].Data.Index != 1 {
+			t.Errorf("expected only the valid message to be processed, got %+v", actual)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("decode options apply to the envelope's data", func(t *testing.T) {
+		// Arrange.
+		consumer, err := EnsureConsumer(ctx, js, "envelope", jetstream.ConsumerConfig{
+			Durable:       "envelopeStrict",
+			FilterSubject: "envelope.strict",
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		for _, data := range []string{
+			`{"meta":{"version":1,"newField":true},"data":{"Index":1}}`,
+			`{"meta":{"version":1},"data":{"Index":2,"unknown":true}}`,
+		} {
+			if err = conn.Publish("envelope.strict", []byte(data)); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+		var actual []Envelope[BatchMessage]
+		p := func(ctx context.Context, msgs []Envelope[BatchMessage]) []error {
+			actual = append(actual, msgs...)
+			return nil
+		}
+		bp := NewBatchProcessor[Envelope[BatchMessage]](consumer, 10, p,
+			WithFetchOpts[Envelope[BatchMessage]](jetstream.FetchMaxWait(100*time.Millisecond)),
+			WithEnvelope[BatchMessage](RejectNonEnveloped),
+			WithDisallowUnknownFields[Envelope[BatchMessage]]())
+
+		// Act.
+		result, err := bp.ProcessWithResult(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if len(actual) != 1 || actual[0].Data.Index != 1 {
+			t.Errorf("expected only the message without unknown data fields to be processed, got %+v", actual)
+		}
+		if diff := cmp.Diff(ProcessResult{Fetched: 2, Acked: 1, Skipped: 1}, result); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestMarshalEnvelope(t *testing.T) {
+	meta := EnvelopeMeta{
+		ProducerID:    "producer-1",
+		SchemaVersion: 2,
+		Timestamp:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	t.Run("the wire format is stable", func(t *testing.T) {
+		actual, err := MarshalEnvelope(meta, BatchMessage{Index: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := `{"meta":{"version":1,"producerId":"producer-1","schemaVersion":2,"timestamp":"2024-01-02T03:04:05Z"},"data":{"Index":1}}`
+		if string(actual) != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	})
+	t.Run("envelopes can be round tripped", func(t *testing.T) {
+		data, err := MarshalEnvelope(meta, BatchMessage{Index: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		actual, err := UnmarshalEnvelope[BatchMessage](data, RejectNonEnveloped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectedMeta := meta
+		expectedMeta.Version = EnvelopeVersion
+		if diff := cmp.Diff(Envelope[BatchMessage]{Meta: expectedMeta, Data: BatchMessage{Index: 1}}, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("non-enveloped data is handled according to the policy", func(t *testing.T) {
+		if _, err := UnmarshalEnvelope[BatchMessage]([]byte(`{"index":1}`), RejectNonEnveloped); !errors.Is(err, ErrNotEnveloped) {
+			t.Errorf("expected ErrNotEnveloped, got %v", err)
+		}
+		actual, err := UnmarshalEnvelope[BatchMessage]([]byte(`{"index":1}`), WrapNonEnveloped)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if actual.Data.Index != 1 {
+			t.Errorf("expected the data to be wrapped, got %+v", actual)
+		}
+	})
+}
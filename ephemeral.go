@@ -0,0 +1,101 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NewBatchProcessorFromStream creates a BatchProcessor that reads the stream
+// using an ordered, ephemeral consumer, e.g. for tools that read a stream once.
+// The filter limits the messages to a subject, and may be empty to read all
+// subjects. Call Close to delete the consumer once finished. If Close isn't
+// called, the server deletes the consumer once it has been inactive for 5
+// minutes.
+//
+// Ordered consumers don't redeliver messages, so messages that the processor
+// returns an error for are not retried.
+func NewBatchProcessorFromStream[T any](ctx context.Context, stream jetstream.Stream, filter string, batchSize int, processor func(ctx context.Context, messages []T) []error, opts ...BatchProcessorOpt[T]) (bp *BatchProcessor[T], err error) {
+	bp = newBatchProcessor(batchSize, processor, opts...)
+	if bp.optErr != nil {
+		return nil, bp.optErr
+	}
+	var cfg jetstream.OrderedConsumerConfig
+	if filter != "" {
+		cfg.FilterSubjects = []string{filter}
+	}
+	if policy, ok := bp.deliverPolicy(); ok {
+		cfg.DeliverPolicy = policy
+		cfg.OptStartSeq = bp.startSequence
+		cfg.OptStartTime = bp.optStartTime()
+	}
+	consumer, err := stream.OrderedConsumer(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ordered consumer: %w", err)
+	}
+	bp.setConsumer(consumer)
+	bp.stream = stream
+	return bp, nil
+}
+
+// WithStartTime starts reading the stream from the first message stored at or
+// after the time. It only applies to processors created with
+// NewBatchProcessorFromStream or NewBatchProcessorFromConfig, since other
+// processors use an existing consumer.
+func WithStartTime[T any](t time.Time) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.startTime = t
+	}
+}
+
+// WithStartSequence starts reading the stream from the message with the
+// sequence number. It only applies to processors created with
+// NewBatchProcessorFromStream or NewBatchProcessorFromConfig, since other
+// processors use an existing consumer. If WithStartTime is also set, the
+// sequence takes precedence.
+func WithStartSequence[T any](seq uint64) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.startSequence = seq
+	}
+}
+
+// deliverPolicy returns the deliver policy for the start position set by
+// WithStartSequence or WithStartTime, or false if neither is set.
+func (b *BatchProcessor[T]) deliverPolicy() (policy jetstream.DeliverPolicy, ok bool) {
+	switch {
+	case b.startSequence > 0:
+		return jetstream.DeliverByStartSequencePolicy, true
+	case !b.startTime.IsZero():
+		return jetstream.DeliverByStartTimePolicy, true
+	}
+	return jetstream.DeliverAllPolicy, false
+}
+
+// optStartTime returns the start time for the consumer configuration, which is
+// only set if the start sequence isn't.
+func (b *BatchProcessor[T]) optStartTime() *time.Time {
+	if b.startSequence > 0 || b.startTime.IsZero() {
+		return nil
+	}
+	return &b.startTime
+}
+
+// Close deletes the consumer if it was created by NewBatchProcessorFromStream.
+// Otherwise, it does nothing, since the consumer is owned by the caller.
+func (b *BatchProcessor[T]) Close(ctx context.Context) error {
+	if b.stream == nil {
+		return nil
+	}
+	info := b.consumer.CachedInfo()
+	if info == nil {
+		return nil
+	}
+	err := b.stream.DeleteConsumer(ctx, info.Name)
+	if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return fmt.Errorf("failed to delete consumer: %w", err)
+	}
+	return nil
+}
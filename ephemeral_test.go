@@ -0,0 +1,124 @@
+package natsjson
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestNewBatchProcessorFromStream(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	stream, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "ephemeral",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](conn)
+	if err := pub.Publish("ephemeral.a", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+		t.Fatalf("unexpected failure sending test messages: %v", err)
+	}
+	if err := pub.Publish("ephemeral.b", BatchMessage{Index: 2}); err != nil {
+		t.Fatalf("unexpected failure sending test messages: %v", err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	t.Run("messages matching the filter are read from the start of the stream", func(t *testing.T) {
+		// Arrange.
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp, err := NewBatchProcessorFromStream[BatchMessage](ctx, stream, "ephemeral.a", 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Act.
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if err := bp.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 0}, {Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+		names := stream.ConsumerNames(ctx)
+		var remaining []string
+		for name := range names.Name() {
+			remaining = append(remaining, name)
+		}
+		if names.Err() != nil {
+			t.Fatalf("failed to list consumers: %v", names.Err())
+		}
+		if len(remaining) != 0 {
+			t.Errorf("expected the consumer to be deleted, got %v", remaining)
+		}
+	})
+	t.Run("WithStartSequence starts reading from the sequence", func(t *testing.T) {
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp, err := NewBatchProcessorFromStream[BatchMessage](ctx, stream, "", 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithStartSequence[BatchMessage](2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer bp.Close(ctx)
+
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}, {Index: 2}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("WithStartTime starts reading from the time", func(t *testing.T) {
+		// Arrange.
+		start := time.Now()
+		jsPub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js))
+		if _, err := jsPub.PublishJetStream(ctx, "ephemeral.c", BatchMessage{Index: 3}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp, err := NewBatchProcessorFromStream[BatchMessage](ctx, stream, "", 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithStartTime[BatchMessage](start))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer bp.Close(ctx)
+
+		// Act.
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 3}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package natsjson
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// UnmatchedSubjectPolicy is what to do with messages that don't match the
+// subjects set by WithFilterSubjects.
+type UnmatchedSubjectPolicy int
+
+const (
+	// AckUnmatched acks messages that don't match the filter, so that they're
+	// skipped.
+	AckUnmatched UnmatchedSubjectPolicy = iota
+	// IgnoreUnmatched doesn't ack or nack messages that don't match the filter,
+	// so that they're redelivered once the consumer's AckWait has elapsed, e.g.
+	// to a different worker.
+	IgnoreUnmatched
+)
+
+// WithFilterSubjects only passes messages with a subject that matches one of
+// the subjects to the processor. Subjects may contain the "*" and ">"
+// wildcards. Other messages are handled according to the policy set by
+// WithUnmatchedSubjectPolicy, and are acked by default.
+//
+// The filtering is carried out by the client, so where possible, set the
+// FilterSubjects of the consumer's configuration instead, so that unmatched
+// messages aren't delivered at all.
+func WithFilterSubjects[T any](subjects ...string) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.filterSubjects = append(bp.filterSubjects, subjects...)
+	}
+}
+
+// WithUnmatchedSubjectPolicy sets what to do with messages that don't match the
+// subjects set by WithFilterSubjects.
+func WithUnmatchedSubjectPolicy[T any](policy UnmatchedSubjectPolicy) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.unmatchedSubjectPolicy = policy
+	}
+}
+
+// matchesAnySubject returns true if there are no filters, or the subject matches
+// one of the filters.
+func matchesAnySubject(filters []string, subject string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		if subjectMatches(filter, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func subjectMatches(filter, subject string) bool {
+	ft := strings.Split(filter, ".")
+	st := strings.Split(subject, ".")
+	for i, f := range ft {
+		if f == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		if f != "*" && f != st[i] {
+			return false
+		}
+	}
+	return len(ft) == len(st)
+}
+
+// WithHeaderFilter only passes messages with a header that has the given value
+// to the processor, e.g. to process a subset of messages by a "type" header.
+// Header keys are case-sensitive. Other messages are acked and skipped before
+// they're decoded, and counted by the Filtered field of ProcessResult. If the
+// option is set more than once for the same header, the header may have any of
+// the values. If it's set for more than one header, every header must match.
+func WithHeaderFilter[T any](key, value string) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		if bp.headerFilters == nil {
+			bp.headerFilters = map[string][]string{}
+		}
+		bp.headerFilters[key] = append(bp.headerFilters[key], value)
+	}
+}
+
+// matchesHeaders returns true if each of the filtered headers has one of the
+// filter's values.
+func matchesHeaders(filters map[string][]string, headers nats.Header) bool {
+	for key, values := range filters {
+		if !slices.ContainsFunc(headers.Values(key), func(v string) bool {
+			return slices.Contains(values, v)
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package natsjson
+
+import "testing"
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		filter   string
+		subject  string
+		expected bool
+	}{
+		{filter: "orders.created", subject: "orders.created", expected: true},
+		{filter: "orders.created", subject: "orders.cancelled", expected: false},
+		{filter: "orders.*", subject: "orders.created", expected: true},
+		{filter: "orders.*", subject: "orders.created.eu", expected: false},
+		{filter: "orders.>", subject: "orders.created.eu", expected: true},
+		{filter: "orders.>", subject: "orders", expected: false},
+		{filter: "*.created", subject: "orders.created", expected: true},
+		{filter: "orders.created.eu", subject: "orders.created", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.subject, func(t *testing.T) {
+			if actual := subjectMatches(tt.filter, tt.subject); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	// ErrNotConnected is returned by health checks if the connection isn't
+	// connected to a server, or the server doesn't respond to a ping.
+	ErrNotConnected = errors.New("not connected")
+	// ErrJetStreamUnavailable is returned by health checks if the connection
+	// is connected, but JetStream doesn't respond.
+	ErrJetStreamUnavailable = errors.New("jetstream unavailable")
+)
+
+// connHealthy returns ErrNotConnected if the connection isn't connected, or the
+// server doesn't respond to a ping. If the context doesn't have a deadline, the
+// connection's default flush timeout is used.
+func connHealthy(ctx context.Context, nc *nats.Conn) (err error) {
+	if !nc.IsConnected() {
+		return fmt.Errorf("%w: connection status is %v", ErrNotConnected, nc.Status())
+	}
+	if _, ok := ctx.Deadline(); ok {
+		err = nc.FlushWithContext(ctx)
+	} else {
+		err = nc.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	return nil
+}
+
+// jetStreamHealthy returns ErrJetStreamUnavailable if the account information
+// can't be retrieved.
+func jetStreamHealthy(ctx context.Context, js jetstream.JetStream) error {
+	if _, err := js.AccountInfo(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrJetStreamUnavailable, err)
+	}
+	return nil
+}
+
+// Healthy checks that the connection is connected, and that the server
+// responds to a ping. If the publisher was created with WithPublisherJetStream,
+// it also checks that JetStream is available. The error wraps ErrNotConnected
+// or ErrJetStreamUnavailable, so that readiness probes can report the cause.
+func (p *Publisher[T]) Healthy(ctx context.Context) error {
+	if err := connHealthy(ctx, p.NC); err != nil {
+		return err
+	}
+	if p.js == nil {
+		return nil
+	}
+	return jetStreamHealthy(ctx, p.js)
+}
+
+// Healthy checks that the connection is connected, and that the server
+// responds to a ping. The error wraps ErrNotConnected.
+func (r *Responder[Req, Resp]) Healthy(ctx context.Context) error {
+	return connHealthy(ctx, r.NC)
+}
+
+// Healthy checks that the consumer's information can be retrieved from
+// JetStream. If the processor was created with WithReconnectLogging, it first
+// checks that the connection is connected. The error wraps ErrNotConnected or
+// ErrJetStreamUnavailable.
+func (b *BatchProcessor[T]) Healthy(ctx context.Context) error {
+	if b.nc != nil {
+		if err := connHealthy(ctx, b.nc); err != nil {
+			return err
+		}
+	}
+	if _, err := b.consumer.Info(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrJetStreamUnavailable, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestHealthy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("a connected publisher is healthy", func(t *testing.T) {
+		conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		pub := NewPublisher[int](conn, WithPublisherJetStream[int](js))
+		if err := pub.Healthy(ctx); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("a closed connection is not connected", func(t *testing.T) {
+		conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		conn.Close()
+		if err := NewPublisher[int](conn).Healthy(ctx); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("expected ErrNotConnected, got %v", err)
+		}
+	})
+	t.Run("JetStream is unavailable if it's not enabled", func(t *testing.T) {
+		conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer(natsjsontest.WithJetStream(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		js, err := jetstream.New(conn)
+		if err != nil {
+			t.Fatalf("failed to create jetstream: %v", err)
+		}
+		pub := NewPublisher[int](conn, WithPublisherJetStream[int](js))
+		if err := pub.Healthy(ctx); !errors.Is(err, ErrJetStreamUnavailable) {
+			t.Errorf("expected ErrJetStreamUnavailable, got %v", err)
+		}
+	})
+	t.Run("a processor is healthy if its consumer exists", func(t *testing.T) {
+		conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		if _, err = EnsureStream(ctx, js, jetstream.StreamConfig{Name: "health", Storage: jetstream.MemoryStorage}); err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+		consumer, err := EnsureConsumer(ctx, js, "health", jetstream.ConsumerConfig{Durable: "health"})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		p := func(ctx context.Context, msgs []int) []error { return nil }
+		bp := NewBatchProcessor(consumer, 10, p, WithReconnectLogging[int](conn))
+		if err := bp.Healthy(ctx); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := js.DeleteConsumer(ctx, "health", "health"); err != nil {
+			t.Fatalf("failed to delete consumer: %v", err)
+		}
+		if err := bp.Healthy(ctx); !errors.Is(err, ErrJetStreamUnavailable) {
+			t.Errorf("expected ErrJetStreamUnavailable, got %v", err)
+		}
+	})
+}
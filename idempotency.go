@@ -0,0 +1,125 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// idempotencySubject is the subject prefix of the keys used to record processed
+// messages.
+const idempotencySubject = "processed"
+
+// WithIdempotency skips messages that have already been processed, so that
+// redelivered and duplicate messages aren't processed again. The ID of each
+// message returned by id is recorded in the bucket after the message is
+// processed successfully, and messages with an ID that's already in the bucket,
+// or earlier in the same batch, are acked without being passed to the
+// processor.
+//
+// Delivery is at-least-once: if processors that share the bucket receive the
+// same message at the same time, they may both process it. Use
+// WithIdempotencyClaimFirst to prevent that. Use a bucket with a TTL to limit
+// how long IDs are retained.
+func WithIdempotency[T any](kv jetstream.KeyValue, id func(T) string) BatchProcessorOpt[T] {
+	return WithIdempotencyFromMsg(kv, func(msg jetstream.Msg, value T) string {
+		return id(value)
+	})
+}
+
+// WithIdempotencyFromMsg is the same as WithIdempotency, but the ID function
+// receives the message as well as its value, so that the ID can be read from
+// the message's headers, e.g. a correlation ID set by an upstream system.
+func WithIdempotencyFromMsg[T any](kv jetstream.KeyValue, id func(msg jetstream.Msg, value T) string) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.idempotency = &idempotency[T]{
+			processed: NewKV[time.Time](kv, idempotencySubject),
+			id:        id,
+		}
+	}
+}
+
+// WithIdempotencyClaimFirst changes WithIdempotency and WithIdempotencyFromMsg
+// to claim the ID of each message in the bucket before the message is passed
+// to the processor, so that processors that share the bucket never process the
+// same message concurrently. If the message isn't acked, e.g. because
+// processing failed, the claim is removed so that the message is processed
+// when it's redelivered.
+//
+// Delivery is at-most-once: if the process stops after a message is claimed,
+// but before it's acked, the message is skipped when it's redelivered, and is
+// never processed.
+func WithIdempotencyClaimFirst[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.idempotencyClaimFirst = true
+	}
+}
+
+type idempotency[T any] struct {
+	processed  *KV[time.Time]
+	id         func(msg jetstream.Msg, value T) string
+	claimFirst bool
+}
+
+// begin is called before the message is passed to the processor. If the
+// message has already been processed, or claimed, duplicate is true. With
+// WithIdempotencyClaimFirst, the message is claimed, and the revision of the
+// claim is returned.
+func (i *idempotency[T]) begin(ctx context.Context, msg jetstream.Msg, value T) (rev uint64, duplicate bool, err error) {
+	if !i.claimFirst {
+		_, _, duplicate, err = i.processed.Get(ctx, i.id(msg, value))
+		return 0, duplicate, err
+	}
+	rev, err = i.processed.create(ctx, i.id(msg, value), time.Now())
+	if errors.Is(err, ErrOptimisticConcurrencyCheckFailed) {
+		return 0, true, nil
+	}
+	return rev, false, err
+}
+
+// end is called once it's known whether the message will be acked. Acked
+// messages are recorded as processed. With WithIdempotencyClaimFirst, the claim
+// on messages that aren't acked is removed instead, so that they're processed
+// when they're redelivered.
+func (i *idempotency[T]) end(ctx context.Context, log *slog.Logger, msg jetstream.Msg, value T, rev uint64, acked bool) {
+	if i.claimFirst {
+		if !acked {
+			i.release(ctx, log, msg, value, rev)
+		}
+		return
+	}
+	if acked {
+		i.record(ctx, log, msg, value)
+	}
+}
+
+// record marks the message as processed. Create is used, so that if another
+// processor processed the same message concurrently, only one record is
+// written.
+func (i *idempotency[T]) record(ctx context.Context, log *slog.Logger, msg jetstream.Msg, value T) {
+	id := i.id(msg, value)
+	_, err := i.processed.create(ctx, id, time.Now())
+	if errors.Is(err, ErrOptimisticConcurrencyCheckFailed) {
+		log.Warn("Message was processed concurrently by another processor", slog.String("id", id))
+		return
+	}
+	if err != nil {
+		log.Warn("Failed to record processed message", slog.String("id", id), slog.Any("error", err))
+	}
+}
+
+// release removes the claim. The claim is only removed if it hasn't changed
+// since it was made.
+func (i *idempotency[T]) release(ctx context.Context, log *slog.Logger, msg jetstream.Msg, value T, rev uint64) {
+	id := i.id(msg, value)
+	subject, err := i.processed.keyToSubject(id)
+	if err == nil {
+		err = i.processed.kv.Delete(ctx, subject, jetstream.LastRevision(rev))
+	}
+	if err != nil {
+		log.Warn("Failed to release claim on message", slog.String("id", id), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,161 @@
+package natsjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Marshal is used to encode values as JSON. It can be replaced with a faster
+// implementation that's compatible with encoding/json, e.g. sonic or jsoniter,
+// before any publishers, processors or KVs are used.
+var Marshal func(v any) ([]byte, error) = json.Marshal
+
+// Unmarshal is used to decode JSON values. It can be replaced with a faster
+// implementation that's compatible with encoding/json. Decoding with unknown
+// fields disallowed, or with UseNumber, always uses encoding/json.
+var Unmarshal func(data []byte, v any) error = json.Unmarshal
+
+// jsonEncodeOpts configures how JSON is encoded.
+type jsonEncodeOpts struct {
+	indent       bool
+	indentPrefix string
+	indentString string
+}
+
+// format indents the data if indentation is enabled, otherwise it returns the
+// data unchanged.
+func (o jsonEncodeOpts) format(data []byte) ([]byte, error) {
+	if !o.indent {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, o.indentPrefix, o.indentString); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonDecodeOpts configures how JSON is decoded.
+type jsonDecodeOpts struct {
+	disallowUnknownFields bool
+	useNumber             bool
+}
+
+// unmarshalJSON decodes the data into v. By default, it behaves the same as
+// Unmarshal.
+func unmarshalJSON(data []byte, v any, opts jsonDecodeOpts) error {
+	if !opts.disallowUnknownFields && !opts.useNumber {
+		return Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if opts.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if opts.useNumber {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// Match json.Unmarshal, which rejects trailing data.
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// WithDisallowUnknownFields treats messages that contain fields that aren't
+// in T as invalid, instead of ignoring the fields. Invalid messages are
+// handled in the same way as other messages that can't be decoded.
+func WithDisallowUnknownFields[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.json.disallowUnknownFields = true
+	}
+}
+
+// WithStreamProcessorDisallowUnknownFields treats messages that contain fields
+// that aren't in T as invalid, instead of ignoring the fields.
+func WithStreamProcessorDisallowUnknownFields[T any]() StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.json.disallowUnknownFields = true
+	}
+}
+
+// WithKVDisallowUnknownFields returns an error when reading values that contain
+// fields that aren't in T, instead of ignoring the fields.
+func WithKVDisallowUnknownFields[T any]() KVOpt[T] {
+	return func(db *KV[T]) {
+		db.json.disallowUnknownFields = true
+	}
+}
+
+// WithUseNumber decodes numbers in any or interface{} fields of T as
+// json.Number instead of float64, so that large integers, e.g. IDs, don't
+// lose precision.
+func WithUseNumber[T any]() BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.json.useNumber = true
+	}
+}
+
+// WithStreamProcessorUseNumber decodes numbers in any or interface{} fields of
+// T as json.Number instead of float64.
+func WithStreamProcessorUseNumber[T any]() StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.json.useNumber = true
+	}
+}
+
+// WithKVUseNumber decodes numbers in any or interface{} fields of T as
+// json.Number instead of float64.
+func WithKVUseNumber[T any]() KVOpt[T] {
+	return func(db *KV[T]) {
+		db.json.useNumber = true
+	}
+}
+
+// WithKVIndent stores values as indented JSON, in the same way as
+// json.MarshalIndent, so that they're readable when inspected with tools such
+// as the nats CLI. Indented values take more storage, so it's best used for
+// buckets that are read by people, e.g. configuration. Reading is unaffected.
+func WithKVIndent[T any](prefix, indent string) KVOpt[T] {
+	return func(db *KV[T]) {
+		db.encode = jsonEncodeOpts{indent: true, indentPrefix: prefix, indentString: indent}
+	}
+}
+
+// WithPublisherIndent publishes values as indented JSON, in the same way as
+// json.MarshalIndent. If WithPublisherEnvelope is set, the envelope is
+// indented too.
+func WithPublisherIndent[T any](prefix, indent string) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.encode = jsonEncodeOpts{indent: true, indentPrefix: prefix, indentString: indent}
+	}
+}
+
+// WithPublisherMarshal sets the function used to encode values as JSON,
+// instead of Marshal, e.g. to format time.Time fields as Unix timestamps for
+// consumers written in other languages.
+func WithPublisherMarshal[T any](marshal func(v T) ([]byte, error)) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.marshal = marshal
+	}
+}
+
+// WithKVCodec sets the functions used to encode and decode values, instead of
+// Marshal and Unmarshal, e.g. to store time.Time fields as Unix timestamps.
+// The unmarshal function replaces WithKVDisallowUnknownFields and
+// WithKVUseNumber.
+func WithKVCodec[T any](marshal func(v T) ([]byte, error), unmarshal func(data []byte, v *T) error) KVOpt[T] {
+	return func(db *KV[T]) {
+		db.codec = &codec[T]{marshal: marshal, unmarshal: unmarshal}
+	}
+}
+
+// codec encodes and decodes values of type T.
+type codec[T any] struct {
+	marshal   func(v T) ([]byte, error)
+	unmarshal func(data []byte, v *T) error
+}
@@ -0,0 +1,274 @@
+package natsjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		opts        jsonDecodeOpts
+		expectError bool
+	}{
+		{name: "unknown fields are ignored by default", data: `{"name":"alice","nmae":"typo"}`},
+		{name: "unknown fields are rejected when disallowed", data: `{"name":"alice","nmae":"typo"}`, opts: jsonDecodeOpts{disallowUnknownFields: true}, expectError: true},
+		{name: "known fields are accepted when unknown fields are disallowed", data: `{"name":"alice","age":30}`, opts: jsonDecodeOpts{disallowUnknownFields: true}},
+		{name: "trailing data is rejected by default", data: `{"name":"alice"} {}`, expectError: true},
+		{name: "trailing data is rejected when unknown fields are disallowed", data: `{"name":"alice"} {}`, opts: jsonDecodeOpts{disallowUnknownFields: true}, expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var u User
+			err := unmarshalJSON([]byte(tt.data), &u, tt.opts)
+			if tt.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONUseNumber(t *testing.T) {
+	type Event struct {
+		ID any `json:"id"`
+	}
+	data := []byte(`{"id":9007199254740993}`)
+	t.Run("numbers are decoded as float64 by default", func(t *testing.T) {
+		var e Event
+		if err := unmarshalJSON(data, &e, jsonDecodeOpts{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := e.ID.(float64); !ok {
+			t.Errorf("expected float64, got %T", e.ID)
+		}
+	})
+	t.Run("numbers are decoded as json.Number with UseNumber", func(t *testing.T) {
+		var e Event
+		if err := unmarshalJSON(data, &e, jsonDecodeOpts{useNumber: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.ID != json.Number("9007199254740993") {
+			t.Errorf("expected json.Number 9007199254740993, got %T %v", e.ID, e.ID)
+		}
+	})
+	t.Run("UseNumber can be combined with DisallowUnknownFields", func(t *testing.T) {
+		var e Event
+		err := unmarshalJSON([]byte(`{"id":1,"unknown":true}`), &e, jsonDecodeOpts{useNumber: true, disallowUnknownFields: true})
+		if err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+}
+
+func TestPluggableJSON(t *testing.T) {
+	originalMarshal, originalUnmarshal := Marshal, Unmarshal
+	t.Cleanup(func() {
+		Marshal, Unmarshal = originalMarshal, originalUnmarshal
+	})
+	var marshalled, unmarshalled int
+	Marshal = func(v any) ([]byte, error) {
+		marshalled++
+		return json.Marshal(v)
+	}
+	Unmarshal = func(data []byte, v any) error {
+		unmarshalled++
+		return json.Unmarshal(data, v)
+	}
+
+	msg, err := NewPublisher[User](nil).newMsg("users", User{Name: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var u User
+	if err = unmarshalJSON(msg.Data, &u, jsonDecodeOpts{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if marshalled != 1 {
+		t.Errorf("expected the replacement Marshal to be called once, got %d", marshalled)
+	}
+	if unmarshalled != 1 {
+		t.Errorf("expected the replacement Unmarshal to be called once, got %d", unmarshalled)
+	}
+
+	// Envelopes are encoded with the replacement too.
+	marshalled = 0
+	if _, err = NewPublisher(nil, WithPublisherEnvelope[User]("producer", 1)).newMsg("users", User{Name: "alice"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if marshalled != 2 {
+		t.Errorf("expected the replacement Marshal to encode the value and the envelope, got %d calls", marshalled)
+	}
+
+	// Schema validation decodes with the replacement too.
+	unmarshalled = 0
+	if err = validateSchema(nonNegativeIndexSchema{}, []byte(`{"Index":1}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unmarshalled != 1 {
+		t.Errorf("expected the replacement Unmarshal to be used for schema validation, got %d calls", unmarshalled)
+	}
+}
+
+func TestIndent(t *testing.T) {
+	expected := "{\n  \"name\": \"alice\",\n  \"age\": 30\n}"
+	t.Run("publishers can indent messages", func(t *testing.T) {
+		msg, err := NewPublisher(nil, WithPublisherIndent[User]("", "  ")).newMsg("users", User{Name: "alice", Age: 30})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("expected %q, got %q", expected, msg.Data)
+		}
+	})
+	t.Run("KVs can indent values", func(t *testing.T) {
+		db := NewKV(nil, "users", WithKVIndent[User]("", "  "))
+		data, err := db.marshal("users.alice", User{Name: "alice", Age: 30})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %q, got %q", expected, data)
+		}
+		var u User
+		if err = db.unmarshal("users.alice", data, &u); err != nil {
+			t.Fatalf("unexpected error reading indented value: %v", err)
+		}
+	})
+	t.Run("values aren't indented by default", func(t *testing.T) {
+		data, err := NewKV[User](nil, "users").marshal("users.alice", User{Name: "alice", Age: 30})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != `{"name":"alice","age":30}` {
+			t.Errorf("unexpected data %q", data)
+		}
+	})
+}
+
+type TimedEvent struct {
+	At time.Time
+}
+
+func TestCodec(t *testing.T) {
+	// Encode times as Unix timestamps in milliseconds.
+	marshal := func(v TimedEvent) ([]byte, error) {
+		return json.Marshal(struct {
+			At int64 `json:"at"`
+		}{At: v.At.UnixMilli()})
+	}
+	unmarshal := func(data []byte, v *TimedEvent) error {
+		var e struct {
+			At int64 `json:"at"`
+		}
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		v.At = time.UnixMilli(e.At).UTC()
+		return nil
+	}
+	event := TimedEvent{At: time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)}
+	expected := `{"at":1704164645006}`
+
+	t.Run("publishers can use a custom marshal function", func(t *testing.T) {
+		msg, err := NewPublisher(nil, WithPublisherMarshal(marshal)).newMsg("events", event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Data) != expected {
+			t.Errorf("expected %s, got %s", expected, msg.Data)
+		}
+	})
+	t.Run("KVs can use a custom codec", func(t *testing.T) {
+		db := NewKV(nil, "events", WithKVCodec(marshal, unmarshal))
+		data, err := db.marshal("events.1", event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %s, got %s", expected, data)
+		}
+		var actual TimedEvent
+		if err = db.unmarshal("events.1", data, &actual); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !actual.At.Equal(event.At) {
+			t.Errorf("expected %v, got %v", event.At, actual.At)
+		}
+	})
+	t.Run("the default format is RFC 3339 with nanoseconds", func(t *testing.T) {
+		msg, err := NewPublisher[TimedEvent](nil).newMsg("events", event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := `{"At":"2024-01-02T03:04:05.006Z"}`; string(msg.Data) != expected {
+			t.Errorf("expected %s, got %s", expected, msg.Data)
+		}
+	})
+}
+
+// BenchmarkJSON compares the default Marshal and Unmarshal hooks with an
+// alternative implementation, by publishing and decoding a batch of messages.
+// To compare with a faster library, e.g. sonic, add it to the implementations.
+func BenchmarkJSON(b *testing.B) {
+	values := make([]User, 100)
+	msgs := make([]benchmarkMsg, len(values))
+	for i := range values {
+		values[i] = User{Name: fmt.Sprintf("user %d", i), Age: i}
+		msgs[i] = benchmarkMsg{data: []byte(fmt.Sprintf(`{"name":"user %d","age":%d}`, i, i))}
+	}
+	implementations := []struct {
+		name      string
+		marshal   func(v any) ([]byte, error)
+		unmarshal func(data []byte, v any) error
+	}{
+		{name: "encoding/json", marshal: json.Marshal, unmarshal: json.Unmarshal},
+		{
+			name: "json.Encoder and json.Decoder",
+			marshal: func(v any) ([]byte, error) {
+				var buf bytes.Buffer
+				if err := json.NewEncoder(&buf).Encode(v); err != nil {
+					return nil, err
+				}
+				return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+			},
+			unmarshal: func(data []byte, v any) error {
+				return json.NewDecoder(bytes.NewReader(data)).Decode(v)
+			},
+		},
+	}
+	for _, impl := range implementations {
+		originalMarshal, originalUnmarshal := Marshal, Unmarshal
+		Marshal, Unmarshal = impl.marshal, impl.unmarshal
+		b.Run(impl.name+"/publish", func(b *testing.B) {
+			p := NewPublisher[User](nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, v := range values {
+					if _, err := p.newMsg("users", v); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(impl.name+"/decode", func(b *testing.B) {
+			var d decoder[User]
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, msg := range msgs {
+					if _, _, _, err := d.decodeMsg(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		Marshal, Unmarshal = originalMarshal, originalUnmarshal
+	}
+}
@@ -4,56 +4,269 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-func NewKV[T any](kv jetstream.KeyValue, subject string) (db *KV[T]) {
-	return &KV[T]{
+type KVOpt[T any] func(*KV[T])
+
+// WithHierarchicalKeys treats keys as dot separated paths, e.g. "tenantA.user1".
+// Only the final segment of the key is hashed, so that the leading segments can
+// be used to list values by prefix with ListPrefix. Keys without a dot are
+// stored at the same subject as the default scheme, but keys containing a dot
+// are not, so existing buckets that use dotted keys need to be migrated before
+// enabling this option.
+func WithHierarchicalKeys[T any]() KVOpt[T] {
+	return func(db *KV[T]) {
+		db.hierarchicalKeys = true
+	}
+}
+
+// WithRawKeys stores keys verbatim instead of hashing them, which keeps the
+// bucket human readable and allows listing by prefix with ListPrefix. Keys must
+// be valid NATS subject tokens separated by dots, and may only contain the
+// characters a-z, A-Z, 0-9, "-", "/", "_" and "=".
+func WithRawKeys[T any]() KVOpt[T] {
+	return func(db *KV[T]) {
+		db.rawKeys = true
+	}
+}
+
+// WithReadOnly rejects writes with ErrReadOnly, e.g. for a KV that reads from
+// a mirror of a bucket. Writes to a mirror must be made to the origin bucket
+// instead, so create a second KV for the origin to write values:
+//
+//	replica := natsjson.NewKV[User](mirror, "users", natsjson.WithReadOnly[User]())
+//	origin := natsjson.NewKV[User](bucket, "users")
+func WithReadOnly[T any]() KVOpt[T] {
+	return func(db *KV[T]) {
+		db.readOnly = true
+	}
+}
+
+// ErrReadOnly is returned when writing to a KV created with WithReadOnly.
+var ErrReadOnly = errors.New("kv is read only")
+
+// WithNamespace prefixes the subject of each key with the namespace, e.g.
+// "tenantA.<subject>.<hash>", so that multiple datasets can share a bucket.
+// Reads, writes, lists and watches only see the keys in the namespace. The
+// namespace must be one or more valid subject tokens separated by dots.
+func WithNamespace[T any](namespace string) KVOpt[T] {
+	return func(db *KV[T]) {
+		db.namespace = namespace
+	}
+}
+
+// WithKVLogger sets the logger used by the KV.
+func WithKVLogger[T any](log *slog.Logger) KVOpt[T] {
+	return func(db *KV[T]) {
+		db.Log = log
+	}
+}
+
+func NewKV[T any](kv jetstream.KeyValue, subject string, opts ...KVOpt[T]) (db *KV[T]) {
+	db = &KV[T]{
 		kv:      kv,
 		subject: subject,
 	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	if db.namespace != "" {
+		db.subject = db.namespace + "." + db.subject
+	}
+	if db.Log == nil {
+		db.Log = discardLogger()
+	}
+	return db
 }
 
 type KV[T any] struct {
-	kv      jetstream.KeyValue
-	subject string
+	Log              *slog.Logger
+	kv               jetstream.KeyValue
+	subject          string
+	hierarchicalKeys bool
+	rawKeys          bool
+	json             jsonDecodeOpts
+	encode           jsonEncodeOpts
+	codec            *codec[T]
+	readOnly         bool
+	namespace        string
+	template         *subjectTemplate
+}
+
+// KeyValue returns the underlying bucket, e.g. to carry out operations that
+// aren't supported by KV.
+func (db *KV[T]) KeyValue() jetstream.KeyValue {
+	return db.kv
+}
+
+// Status returns the status of the underlying bucket, including its size,
+// value count, TTL and history depth.
+func (db *KV[T]) Status(ctx context.Context) (status jetstream.KeyValueStatus, err error) {
+	return db.kv.Status(ctx)
 }
 
-func (db *KV[T]) keyToSubject(key string) (hash string) {
+func (db *KV[T]) keyToSubject(key string) (subject string, err error) {
+	if db.template != nil {
+		if subject, err = db.template.fill(key); err != nil {
+			return "", err
+		}
+		return db.subject + "." + subject, nil
+	}
+	if db.rawKeys {
+		if !validRawKey.MatchString(key) {
+			return "", fmt.Errorf("%w: %q", jetstream.ErrInvalidKey, key)
+		}
+		return db.subject + "." + key, nil
+	}
+	if db.hierarchicalKeys {
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			if !validRawKey.MatchString(key[:i]) {
+				return "", fmt.Errorf("%w: %q", jetstream.ErrInvalidKey, key)
+			}
+			return db.subject + "." + key[:i] + "." + hashKey(key[i+1:]), nil
+		}
+	}
+	return db.subject + "." + hashKey(key), nil
+}
+
+var validRawKey = regexp.MustCompile(`^[-/_=a-zA-Z0-9]+(\.[-/_=a-zA-Z0-9]+)*$`)
+
+func hashKey(key string) string {
 	h := sha256.New()
 	_, _ = h.Write([]byte(key))
-	return db.subject + "." + hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (db *KV[T]) subjectToKey(subject string) (key string) {
+	return strings.TrimPrefix(subject, db.subject+".")
+}
+
+func (db *KV[T]) marshal(subject string, value T) (data []byte, err error) {
+	if db.codec != nil {
+		data, err = db.codec.marshal(value)
+	} else {
+		data, err = Marshal(value)
+	}
+	if err == nil {
+		data, err = db.encode.format(data)
+	}
+	if err != nil {
+		db.Log.Warn("Failed to marshal value", slog.String("subject", subject), slog.Any("error", err))
+	}
+	return data, err
+}
+
+func (db *KV[T]) unmarshal(subject string, data []byte, value *T) (err error) {
+	if db.codec != nil {
+		err = db.codec.unmarshal(data, value)
+	} else {
+		err = unmarshalJSON(data, value, db.json)
+	}
+	if err != nil {
+		db.Log.Warn("Failed to unmarshal value", slog.String("subject", subject), slog.Any("error", err))
+	}
+	return err
 }
 
 func (db *KV[T]) Get(ctx context.Context, key string) (value T, rev uint64, ok bool, err error) {
-	entry, err := db.kv.Get(ctx, db.keyToSubject(key))
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return value, 0, false, err
+	}
+	db.Log.Debug("Getting value", slog.String("subject", subject))
+	entry, err := db.kv.Get(ctx, subject)
 	if err != nil {
 		if err == jetstream.ErrKeyNotFound {
 			return value, 0, false, nil
 		}
 		return value, 0, false, err
 	}
-	err = json.Unmarshal(entry.Value(), &value)
+	err = db.unmarshal(subject, entry.Value(), &value)
 	return value, entry.Revision(), err == nil, err
 }
 
+// GetRev is the same as Get, but returns the value and its revision together.
+func (db *KV[T]) GetRev(ctx context.Context, key string) (r Revision[T], ok bool, err error) {
+	r.Value, r.Rev, ok, err = db.Get(ctx, key)
+	return r, ok, err
+}
+
+// Exists returns true if the key has a value, without decoding the value.
+func (db *KV[T]) Exists(ctx context.Context, key string) (ok bool, err error) {
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return false, err
+	}
+	db.Log.Debug("Checking value exists", slog.String("subject", subject))
+	_, err = db.kv.Get(ctx, subject)
+	if err != nil {
+		if err == jetstream.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (db *KV[T]) GetRevision(ctx context.Context, key string, revision uint64) (value T, ok bool, err error) {
-	entry, err := db.kv.GetRevision(ctx, db.keyToSubject(key), revision)
+	value, _, ok, err = db.GetRevisionEntry(ctx, key, revision)
+	return value, ok, err
+}
+
+// EntryMeta is the metadata of a revision of a key.
+type EntryMeta struct {
+	Revision uint64
+	// Created is the time that the revision was written.
+	Created   time.Time
+	Operation jetstream.KeyValueOp
+}
+
+func newEntryMeta(entry jetstream.KeyValueEntry) EntryMeta {
+	return EntryMeta{
+		Revision:  entry.Revision(),
+		Created:   entry.Created(),
+		Operation: entry.Operation(),
+	}
+}
+
+// GetRevisionEntry is the same as GetRevision, but also returns the metadata
+// of the revision, e.g. the time that it was written. If the revision is a
+// delete, ok is false.
+func (db *KV[T]) GetRevisionEntry(ctx context.Context, key string, revision uint64) (value T, meta EntryMeta, ok bool, err error) {
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return value, meta, false, err
+	}
+	db.Log.Debug("Getting value revision", slog.String("subject", subject), slog.Uint64("revision", revision))
+	entry, err := db.kv.GetRevision(ctx, subject, revision)
 	if err != nil {
 		if err == jetstream.ErrKeyNotFound {
-			return value, false, nil
+			return value, meta, false, nil
 		}
-		return value, false, err
+		return value, meta, false, err
 	}
-	err = json.Unmarshal(entry.Value(), &value)
-	return value, err == nil, err
+	err = db.unmarshal(subject, entry.Value(), &value)
+	return value, newEntryMeta(entry), err == nil, err
 }
 
 func (db *KV[T]) History(ctx context.Context, key string) (values []T, ok bool, err error) {
-	entries, err := db.kv.History(ctx, db.keyToSubject(key))
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return values, false, err
+	}
+	db.Log.Debug("Getting history", slog.String("subject", subject))
+	entries, err := db.kv.History(ctx, subject)
 	if err != nil {
 		if err == jetstream.ErrKeyNotFound {
 			return values, false, nil
@@ -63,7 +276,7 @@ func (db *KV[T]) History(ctx context.Context, key string) (values []T, ok bool,
 	values = make([]T, len(entries))
 	for i := 0; i < len(entries); i++ {
 		entry := entries[i]
-		err = json.Unmarshal(entry.Value(), &values[i])
+		err = db.unmarshal(subject, entry.Value(), &values[i])
 		if err != nil {
 			return values, false, err
 		}
@@ -71,41 +284,216 @@ func (db *KV[T]) History(ctx context.Context, key string) (values []T, ok bool,
 	return values, true, nil
 }
 
+// HistoryEntry is a revision of a key. Deletes and purges don't have a value,
+// so Value is the zero value of T, and Op is jetstream.KeyValueDelete or
+// jetstream.KeyValuePurge.
+type HistoryEntry[T any] struct {
+	Value    T
+	Revision uint64
+	Created  time.Time
+	Op       jetstream.KeyValueOp
+}
+
+// HistoryEntries returns each revision of the key that's stored in the
+// bucket, oldest first, including deletes.
+func (db *KV[T]) HistoryEntries(ctx context.Context, key string) (entries []HistoryEntry[T], ok bool, err error) {
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return nil, false, err
+	}
+	db.Log.Debug("Getting history", slog.String("subject", subject))
+	history, err := db.kv.History(ctx, subject)
+	if err != nil {
+		if err == jetstream.ErrKeyNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	entries = make([]HistoryEntry[T], len(history))
+	for i, entry := range history {
+		entries[i] = HistoryEntry[T]{
+			Revision: entry.Revision(),
+			Created:  entry.Created(),
+			Op:       entry.Operation(),
+		}
+		if entry.Operation() != jetstream.KeyValuePut {
+			continue
+		}
+		if err = db.unmarshal(subject, entry.Value(), &entries[i].Value); err != nil {
+			return entries, false, err
+		}
+	}
+	return entries, true, nil
+}
+
 func (db *KV[T]) Put(ctx context.Context, key string, value T) (rev uint64, err error) {
-	entry, err := json.Marshal(value)
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
 	if err != nil {
 		return rev, err
 	}
-	rev, err = db.kv.Put(ctx, db.keyToSubject(key), entry)
-	return
+	entry, err := db.marshal(subject, value)
+	if err != nil {
+		return rev, err
+	}
+	db.Log.Debug("Putting value", slog.String("subject", subject))
+	rev, err = db.kv.Put(ctx, subject, entry)
+	if err != nil {
+		return rev, db.valueTooLarge(ctx, key, len(entry), err)
+	}
+	return rev, nil
 }
 
 func (db *KV[T]) Delete(ctx context.Context, key string) (err error) {
-	return db.kv.Delete(ctx, db.keyToSubject(key))
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return err
+	}
+	db.Log.Debug("Deleting value", slog.String("subject", subject))
+	return db.kv.Delete(ctx, subject)
+}
+
+// DeleteExisting deletes the key, and returns true if it had a value that was
+// deleted, or false if it didn't exist. The delete is conditional on the
+// revision that was read, so if the key is deleted concurrently, only one of
+// the callers returns true.
+func (db *KV[T]) DeleteExisting(ctx context.Context, key string) (existed bool, err error) {
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return false, err
+	}
+	for {
+		entry, err := db.kv.Get(ctx, subject)
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		db.Log.Debug("Deleting existing value", slog.String("subject", subject), slog.Uint64("last", entry.Revision()))
+		err = db.kv.Delete(ctx, subject, jetstream.LastRevision(entry.Revision()))
+		var apiErr jetstream.JetStreamError
+		if errors.As(err, &apiErr) && apiErr.APIError() != nil {
+			if apiErr.APIError().ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
+				// The key changed since it was read, so check it again.
+				continue
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// GetOrCreate gets the value, or if it doesn't exist, creates it with the value
+// returned by makeDefault. If another writer creates the value concurrently,
+// their value is returned. created is true only if this call wrote the value.
+func (db *KV[T]) GetOrCreate(ctx context.Context, key string, makeDefault func() T) (value T, rev uint64, created bool, err error) {
+	value, rev, ok, err := db.Get(ctx, key)
+	if err != nil || ok {
+		return value, rev, false, err
+	}
+	if db.readOnly {
+		return value, 0, false, ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return value, 0, false, err
+	}
+	value = makeDefault()
+	data, err := db.marshal(subject, value)
+	if err != nil {
+		return value, 0, false, err
+	}
+	db.Log.Debug("Creating value", slog.String("subject", subject))
+	rev, err = db.kv.Create(ctx, subject, data)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		// Another writer created the value first.
+		value, rev, _, err = db.Get(ctx, key)
+		return value, rev, false, err
+	}
+	if err != nil {
+		return value, 0, false, db.valueTooLarge(ctx, key, len(data), err)
+	}
+	return value, rev, true, nil
+}
+
+// Upsert writes the value, whether or not the key exists. created is true if
+// the key didn't have a value, and false if an existing value was overwritten.
+func (db *KV[T]) Upsert(ctx context.Context, key string, value T) (rev uint64, created bool, err error) {
+	if db.readOnly {
+		return 0, false, ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return 0, false, err
+	}
+	data, err := db.marshal(subject, value)
+	if err != nil {
+		return 0, false, err
+	}
+	db.Log.Debug("Upserting value", slog.String("subject", subject))
+	rev, err = db.kv.Create(ctx, subject, data)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		// The last write wins.
+		rev, err = db.kv.Put(ctx, subject, data)
+		if err != nil {
+			return 0, false, db.valueTooLarge(ctx, key, len(data), err)
+		}
+		return rev, false, nil
+	}
+	if err != nil {
+		return 0, false, db.valueTooLarge(ctx, key, len(data), err)
+	}
+	return rev, true, nil
 }
 
 var ErrOptimisticConcurrencyCheckFailed = errors.New("optimistic concurrency check failed")
 
 func (db *KV[T]) Update(ctx context.Context, key string, value T, last uint64) (rev uint64, err error) {
-	entry, err := json.Marshal(value)
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	subject, err := db.keyToSubject(key)
 	if err != nil {
 		return rev, err
 	}
-	rev, err = db.kv.Update(ctx, db.keyToSubject(key), entry, last)
+	entry, err := db.marshal(subject, value)
+	if err != nil {
+		return rev, err
+	}
+	db.Log.Debug("Updating value", slog.String("subject", subject), slog.Uint64("last", last))
+	rev, err = db.kv.Update(ctx, subject, entry, last)
 	var apiErr jetstream.JetStreamError
 	if errors.As(err, &apiErr) && apiErr.APIError() != nil {
 		if apiErr.APIError().ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
 			return 0, ErrOptimisticConcurrencyCheckFailed
 		}
 	}
-	return
+	if err != nil {
+		return rev, db.valueTooLarge(ctx, key, len(entry), err)
+	}
+	return rev, nil
 }
 
+// Revision is a value, and the revision of the key that it was read from.
 type Revision[T any] struct {
 	Value T
 	Rev   uint64
 }
 
+// Iterator reads values one at a time. Iterators that watch the bucket are
+// stopped when the context passed to the method that created them is
+// cancelled, and Next returns false, with the context's error.
 type Iterator[T any] struct {
 	next  func() (value T, ok bool, err error)
 	Value T
@@ -129,31 +517,339 @@ func NewIterator[T any](next func() (value T, ok bool, err error), stop func() e
 	}
 }
 
+// CollectSlice reads all values from the iterator, stops it, and returns the values.
+func CollectSlice[T any](it *Iterator[T]) (values []T, err error) {
+	for it.Next() {
+		values = append(values, it.Value)
+	}
+	return values, errors.Join(it.Error, it.Stop())
+}
+
+// CollectMap reads all values from the iterator, stops it, and returns the values
+// keyed by the result of the key function.
+func CollectMap[K comparable, T any](it *Iterator[T], key func(T) K) (values map[K]T, err error) {
+	values = make(map[K]T)
+	for it.Next() {
+		values[key(it.Value)] = it.Value
+	}
+	return values, errors.Join(it.Error, it.Stop())
+}
+
 func (db *KV[T]) List(ctx context.Context) (it *Iterator[T]) {
-	w, err := db.kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	w, err := db.kv.Watch(ctx, db.subject+".>", jetstream.IgnoreDeletes())
 	if err != nil {
-		next := func() (T, bool, error) {
-			var t T
-			return t, false, err
-		}
-		stop := func() error {
-			return nil
-		}
-		return NewIterator[T](next, stop)
+		return newErrorIterator[T](err)
 	}
-	updates := w.Updates()
+	kw := newKVWatcher(w)
 
 	next := func() (v T, ok bool, err error) {
-		update := <-updates
-		if update == nil {
+		update, closed, err := kw.next(ctx)
+		if update == nil || closed || err != nil {
 			// We're finished.
-			return
+			return v, false, err
 		}
-		err = json.Unmarshal(update.Value(), &v)
+		err = db.unmarshal(update.Key(), update.Value(), &v)
 		if err != nil {
 			return
 		}
 		return v, true, nil
 	}
-	return NewIterator[T](next, w.Stop)
+	return NewIterator[T](next, kw.stop)
+}
+
+// Entry is a value read from the bucket, along with its key and revision.
+type Entry[T any] struct {
+	// Key within the bucket. By default, keys are hashed before storage, so
+	// this is the hash of the key that was passed to Put. With hierarchical
+	// keys, only the final segment is hashed, e.g. "tenantA.<hash>", and with
+	// raw keys, it's the original key.
+	Key   string
+	Value T
+	Rev   uint64
+}
+
+// All returns a sequence of the current values in the bucket. Iteration stops
+// at the first error, including cancellation of the context.
+func (db *KV[T]) All(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for entry, err := range db.AllEntries(ctx) {
+			if !yield(entry.Value, err) {
+				return
+			}
+		}
+	}
+}
+
+// AllEntries returns a sequence of the current entries in the bucket. Iteration
+// stops at the first error, including cancellation of the context.
+func (db *KV[T]) AllEntries(ctx context.Context) iter.Seq2[Entry[T], error] {
+	return func(yield func(Entry[T], error) bool) {
+		w, err := db.kv.Watch(ctx, db.subject+".>", jetstream.IgnoreDeletes())
+		if err != nil {
+			yield(Entry[T]{}, err)
+			return
+		}
+		kw := newKVWatcher(w)
+		defer kw.stop()
+		for {
+			update, closed, err := kw.next(ctx)
+			if err != nil {
+				yield(Entry[T]{}, err)
+				return
+			}
+			if update == nil || closed {
+				// We're finished.
+				return
+			}
+			entry := Entry[T]{
+				Key: db.subjectToKey(update.Key()),
+				Rev: update.Revision(),
+			}
+			if err = db.unmarshal(update.Key(), update.Value(), &entry.Value); err != nil {
+				yield(entry, err)
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Change is a create, update or delete of a key in the bucket. For deletes
+// and purges, the Value is the zero value.
+type Change[T any] struct {
+	// Key within the bucket, in the same format as Entry.Key.
+	Key      string
+	Value    T
+	Op       jetstream.KeyValueOp
+	Revision uint64
+	// Previous is the value of the key before the change, if the watcher was
+	// started with WithPreviousValues, and HadPrevious is true.
+	Previous T
+	// HadPrevious is true if the key had a value before the change that was
+	// observed by the watcher.
+	HadPrevious bool
+}
+
+type KVWatchOpt func(*kvWatchOptions)
+
+type kvWatchOptions struct {
+	previousValues bool
+	// includeHistory returns every change in the bucket's history, instead of
+	// the current value of each key.
+	includeHistory bool
+	// sinceRevision skips changes up to and including the revision.
+	sinceRevision uint64
+}
+
+// WithPreviousValues sets the Previous value of each change to the value of the
+// key before the change, so that changes can be compared without reading the
+// previous revision. The watcher keeps the latest value of each key in memory.
+func WithPreviousValues() KVWatchOpt {
+	return func(o *kvWatchOptions) {
+		o.previousValues = true
+	}
+}
+
+// WatchAll returns an iterator of the changes to the values in the bucket. The
+// current value of each key is returned first, followed by changes as they
+// happen, until the context is cancelled, or the iterator is stopped.
+func (db *KV[T]) WatchAll(ctx context.Context, opts ...KVWatchOpt) (it *Iterator[Change[T]]) {
+	return db.Watch(ctx, ">", opts...)
+}
+
+// Watch is the same as WatchAll, but only returns the changes to keys that
+// match the filter. The filter is a subject relative to the KV's subject, and
+// may contain wildcards, e.g. "tenantA.>" for keys stored with hierarchical
+// keys, raw keys, or a subject template.
+func (db *KV[T]) Watch(ctx context.Context, filter string, opts ...KVWatchOpt) (it *Iterator[Change[T]]) {
+	var o kvWatchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var wopts []jetstream.WatchOpt
+	if o.includeHistory {
+		wopts = append(wopts, jetstream.IncludeHistory())
+	}
+	w, err := db.kv.Watch(ctx, db.subject+"."+filter, wopts...)
+	if err != nil {
+		return newErrorIterator[Change[T]](err)
+	}
+	kw := newKVWatcher(w)
+	var previous map[string]T
+	if o.previousValues {
+		previous = make(map[string]T)
+	}
+
+	next := func() (c Change[T], ok bool, err error) {
+		for {
+			update, closed, err := kw.next(ctx)
+			if err != nil || closed {
+				// The watcher was stopped.
+				return c, false, err
+			}
+			if update == nil {
+				// All of the current values have been received, keep waiting for changes.
+				continue
+			}
+			if update.Revision() <= o.sinceRevision {
+				continue
+			}
+			c.Key = db.subjectToKey(update.Key())
+			c.Op = update.Operation()
+			c.Revision = update.Revision()
+			if previous != nil {
+				c.Previous, c.HadPrevious = previous[c.Key]
+			}
+			if c.Op != jetstream.KeyValuePut {
+				if previous != nil {
+					delete(previous, c.Key)
+				}
+				return c, true, nil
+			}
+			if err = db.unmarshal(update.Key(), update.Value(), &c.Value); err != nil {
+				return c, false, err
+			}
+			if previous != nil {
+				previous[c.Key] = c.Value
+			}
+			return c, true, nil
+		}
+	}
+	return NewIterator[Change[T]](next, kw.stop)
+}
+
+// WatchAllFrom is the same as WatchAll, but instead of returning the current
+// value of each key, it returns every change after the revision, e.g. to resume
+// updating a cache from the last revision that was processed. Each change's
+// Revision can be stored as the checkpoint to resume from. A sinceRevision of 0
+// returns every change in the bucket's history.
+//
+// Changes are filtered by the client, so the history of the bucket is read
+// from the start. Changes that have been removed from the history, because the
+// key has been updated more times than the bucket's history setting, aren't
+// returned, but the latest change to each key is.
+func (db *KV[T]) WatchAllFrom(ctx context.Context, sinceRevision uint64, opts ...KVWatchOpt) (it *Iterator[Change[T]]) {
+	return db.WatchFrom(ctx, ">", sinceRevision, opts...)
+}
+
+// WatchFrom is the same as WatchAllFrom, but only returns the changes to keys
+// that match the filter. See Watch.
+func (db *KV[T]) WatchFrom(ctx context.Context, filter string, sinceRevision uint64, opts ...KVWatchOpt) (it *Iterator[Change[T]]) {
+	opts = append(opts, func(o *kvWatchOptions) {
+		o.includeHistory = true
+		o.sinceRevision = sinceRevision
+	})
+	return db.Watch(ctx, filter, opts...)
+}
+
+var ErrPrefixListingNotSupported = errors.New("listing by prefix requires hierarchical keys, raw keys or a subject template")
+
+// ListPrefix lists the current entries whose keys start with the given dot
+// separated prefix, e.g. "tenantA" matches "tenantA.user1" and "tenantA.admins.user2".
+// The filtering is carried out by the NATS server using a subject wildcard. The
+// prefix can't be empty, or contain wildcards, otherwise the iterator returns
+// jetstream.ErrInvalidKey.
+func (db *KV[T]) ListPrefix(ctx context.Context, prefix string) (it *Iterator[Entry[T]]) {
+	if !db.hierarchicalKeys && !db.rawKeys && db.template == nil {
+		return newErrorIterator[Entry[T]](ErrPrefixListingNotSupported)
+	}
+	if !validRawKey.MatchString(prefix) {
+		return newErrorIterator[Entry[T]](fmt.Errorf("%w: %q", jetstream.ErrInvalidKey, prefix))
+	}
+	return db.watchEntries(ctx, db.subject+"."+prefix+".>", jetstream.IgnoreDeletes())
+}
+
+func (db *KV[T]) watchEntries(ctx context.Context, keys string, opts ...jetstream.WatchOpt) (it *Iterator[Entry[T]]) {
+	w, err := db.kv.Watch(ctx, keys, opts...)
+	if err != nil {
+		return newErrorIterator[Entry[T]](err)
+	}
+	kw := newKVWatcher(w)
+
+	next := func() (e Entry[T], ok bool, err error) {
+		update, closed, err := kw.next(ctx)
+		if update == nil || closed || err != nil {
+			// We're finished.
+			return e, false, err
+		}
+		e.Key = db.subjectToKey(update.Key())
+		e.Rev = update.Revision()
+		err = db.unmarshal(update.Key(), update.Value(), &e.Value)
+		if err != nil {
+			return
+		}
+		return e, true, nil
+	}
+	return NewIterator[Entry[T]](next, kw.stop)
+}
+
+// ErrWatcherClosed is returned by an iterator if the watcher was closed
+// before the iterator was stopped, e.g. because the connection was closed, so
+// that a partial list isn't mistaken for a complete one.
+var ErrWatcherClosed = errors.New("watcher closed unexpectedly")
+
+// kvWatcher wraps a watcher to distinguish the watcher being closed from the
+// end of the current values.
+type kvWatcher struct {
+	w       jetstream.KeyWatcher
+	updates <-chan jetstream.KeyValueEntry
+	stopped atomic.Bool
+}
+
+func newKVWatcher(w jetstream.KeyWatcher) *kvWatcher {
+	return &kvWatcher{
+		w:       w,
+		updates: w.Updates(),
+	}
+}
+
+// next waits for the next update from the watcher. A nil update means that all
+// of the current values have been received. If the watcher has been closed,
+// closed is true, and the error is set unless the watcher was stopped by stop.
+// If the context is cancelled, the context's error is returned, even if
+// updates are buffered.
+func (kw *kvWatcher) next(ctx context.Context) (update jetstream.KeyValueEntry, closed bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, true, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	case update, open := <-kw.updates:
+		if open {
+			return update, false, nil
+		}
+		// The watcher is closed when it's stopped, including by the context
+		// being cancelled, and when the connection is closed.
+		if err = ctx.Err(); err != nil {
+			return nil, true, err
+		}
+		if kw.stopped.Load() {
+			return nil, true, nil
+		}
+		return nil, true, ErrWatcherClosed
+	}
+}
+
+// stop stops the watcher. The watcher is stopped when the context it was
+// created with is cancelled, so stopping it again isn't an error.
+func (kw *kvWatcher) stop() error {
+	kw.stopped.Store(true)
+	if err := kw.w.Stop(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+		return err
+	}
+	return nil
+}
+
+func newErrorIterator[T any](err error) *Iterator[T] {
+	next := func() (T, bool, error) {
+		var t T
+		return t, false, err
+	}
+	stop := func() error {
+		return nil
+	}
+	return NewIterator[T](next, stop)
 }
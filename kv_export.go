@@ -0,0 +1,76 @@
+package natsjson
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// exportRecord is a line of an export.
+type exportRecord[T any] struct {
+	Key      string `json:"key"`
+	Value    T      `json:"value"`
+	Revision uint64 `json:"revision"`
+}
+
+// Export writes the current entries to w as JSON lines, one entry per line.
+// Keys are written as they're stored, in the same format as Entry.Key, so that
+// they can be restored with Import regardless of the key scheme.
+func (db *KV[T]) Export(ctx context.Context, w io.Writer) (err error) {
+	db.Log.Debug("Exporting values", slog.String("subject", db.subject))
+	it := db.watchEntries(ctx, db.subject+".>", jetstream.IgnoreDeletes())
+	defer it.Stop()
+	for it.Next() {
+		r := exportRecord[T]{
+			Key:      it.Value.Key,
+			Value:    it.Value.Value,
+			Revision: it.Value.Rev,
+		}
+		line, err := Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to marshal key %q: %w", r.Key, err)
+		}
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write key %q: %w", r.Key, err)
+		}
+	}
+	return it.Error
+}
+
+// Import reads entries written by Export from r, and puts them. Revisions are
+// assigned by the bucket, so the revisions in the export are ignored.
+func (db *KV[T]) Import(ctx context.Context, r io.Reader) (err error) {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.Log.Debug("Importing values", slog.String("subject", db.subject))
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var line int
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record exportRecord[T]
+		if err = Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("failed to read line %d: %w", line, err)
+		}
+		if !validRawKey.MatchString(record.Key) {
+			return fmt.Errorf("line %d: %w: %q", line, jetstream.ErrInvalidKey, record.Key)
+		}
+		subject := db.subject + "." + record.Key
+		data, err := db.marshal(subject, record.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, err = db.kv.Put(ctx, subject, data); err != nil {
+			return fmt.Errorf("failed to put key %q: %w", record.Key, db.valueTooLarge(ctx, record.Key, len(data), err))
+		}
+	}
+	return scanner.Err()
+}
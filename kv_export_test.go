@@ -0,0 +1,97 @@
+package natsjson
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestKVExport(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	source, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "export_source"})
+	if err != nil {
+		t.Fatalf("unexpected failure creating bucket: %v", err)
+	}
+	target, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "export_target"})
+	if err != nil {
+		t.Fatalf("unexpected failure creating bucket: %v", err)
+	}
+
+	t.Run("exported values can be imported into a new bucket", func(t *testing.T) {
+		// Arrange.
+		for _, opts := range [][]KVOpt[User]{nil, {WithHierarchicalKeys[User]()}, {WithRawKeys[User]()}} {
+			from := NewKV[User](source, "users", opts...)
+			to := NewKV[User](target, "users", opts...)
+			users := map[string]User{
+				"tenantA.user1": {Name: "alice", Age: 30},
+				"tenantA.user2": {Name: "bob", Age: 40},
+			}
+			if _, err := from.PutMany(ctx, users); err != nil {
+				t.Fatalf("unexpected error putting values: %v", err)
+			}
+			if err := from.Delete(ctx, "tenantA.user2"); err != nil {
+				t.Fatalf("unexpected error deleting value: %v", err)
+			}
+
+			// Act.
+			var buf bytes.Buffer
+			if err := from.Export(ctx, &buf); err != nil {
+				t.Fatalf("unexpected error exporting: %v", err)
+			}
+			if err := to.Import(ctx, &buf); err != nil {
+				t.Fatalf("unexpected error importing: %v", err)
+			}
+
+			// Assert.
+			actual, _, ok, err := to.Get(ctx, "tenantA.user1")
+			if err != nil || !ok {
+				t.Fatalf("expected value, got ok=%v, err=%v", ok, err)
+			}
+			if diff := cmp.Diff(users["tenantA.user1"], actual); diff != "" {
+				t.Error(diff)
+			}
+			if _, _, ok, _ := to.Get(ctx, "tenantA.user2"); ok {
+				t.Error("expected deleted values not to be exported")
+			}
+			if err := to.Delete(ctx, "tenantA.user1"); err != nil {
+				t.Fatalf("unexpected error deleting value: %v", err)
+			}
+			if err := from.Delete(ctx, "tenantA.user1"); err != nil {
+				t.Fatalf("unexpected error deleting value: %v", err)
+			}
+		}
+	})
+	t.Run("export writes one record per line", func(t *testing.T) {
+		db := NewKV[User](source, "lines", WithRawKeys[User]())
+		if _, err := db.Put(ctx, "user1", User{Name: "alice", Age: 30}); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := db.Export(ctx, &buf); err != nil {
+			t.Fatalf("unexpected error exporting: %v", err)
+		}
+		expected := `{"key":"user1","value":{"name":"alice","age":30},"revision":`
+		if !strings.HasPrefix(buf.String(), expected) {
+			t.Errorf("expected export to start with %q, got %q", expected, buf.String())
+		}
+	})
+	t.Run("import returns an error for invalid records", func(t *testing.T) {
+		db := NewKV[User](target, "invalid")
+		err := db.Import(ctx, strings.NewReader(`{"key":"user1","value":{"name":"alice"}}`+"\n"+`not json`))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("expected an error for line 2, got %v", err)
+		}
+	})
+}
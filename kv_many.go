@@ -0,0 +1,219 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// defaultConcurrency is the maximum number of concurrent requests made by bulk
+// operations.
+const defaultConcurrency = 16
+
+// KeyErrors contains the error for each key that failed in a bulk operation.
+type KeyErrors map[string]error
+
+func (e KeyErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	msgs := make([]string, len(keys))
+	for i, k := range keys {
+		msgs[i] = fmt.Sprintf("key %q: %v", k, e[k])
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e KeyErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// PutMany puts the items concurrently, and returns the revision of each key
+// that was written. If any items couldn't be marshalled or written, the other
+// items are still written, and the returned error is a KeyErrors containing the
+// error for each failed key.
+func (db *KV[T]) PutMany(ctx context.Context, items map[string]T) (revs map[string]uint64, err error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	db.Log.Debug("Putting values", slog.Int("count", len(items)))
+	revs = make(map[string]uint64, len(items))
+	errs := KeyErrors{}
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, defaultConcurrency)
+	for key, value := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			rev, err := db.Put(ctx, key, value)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			revs[key] = rev
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return revs, errs
+	}
+	return revs, nil
+}
+
+// HistoryMany reads the history of each key concurrently, and returns the
+// values and revisions of each key, oldest first. Keys that don't exist are
+// omitted, and deletes and purges aren't included. If any keys couldn't be read
+// or decoded, the other histories are still returned, and the returned error is
+// a KeyErrors containing the error for each failed key.
+func (db *KV[T]) HistoryMany(ctx context.Context, keys []string) (histories map[string][]Revision[T], err error) {
+	db.Log.Debug("Getting histories", slog.Int("count", len(keys)))
+	histories = make(map[string][]Revision[T], len(keys))
+	errs := KeyErrors{}
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, defaultConcurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			entries, ok, err := db.HistoryEntries(ctx, key)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			if !ok {
+				return
+			}
+			revisions := make([]Revision[T], 0, len(entries))
+			for _, entry := range entries {
+				if entry.Op != jetstream.KeyValuePut {
+					continue
+				}
+				revisions = append(revisions, Revision[T]{Value: entry.Value, Rev: entry.Revision})
+			}
+			histories[key] = revisions
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return histories, errs
+	}
+	return histories, nil
+}
+
+// ModifyManyError is returned by ModifyMany if a key couldn't be written.
+type ModifyManyError struct {
+	// Key is the key that couldn't be written.
+	Key string
+	// Written contains the keys that were written before the failure, in the
+	// order they were written, so that the changes can be compensated for.
+	Written []string
+	Err     error
+}
+
+func (e ModifyManyError) Error() string {
+	return fmt.Sprintf("failed to write key %q after writing %d keys: %v", e.Key, len(e.Written), e.Err)
+}
+
+func (e ModifyManyError) Unwrap() error {
+	return e.Err
+}
+
+// ModifyMany reads the keys, passes the current value and revision of each key
+// that exists to fn, and writes the values that fn returns, in key order. Each
+// write is checked against the revision that was read, so if a key was
+// modified by another writer in the meantime, or created if it didn't exist,
+// the write fails with ErrOptimisticConcurrencyCheckFailed.
+//
+// ModifyMany is best-effort, not atomic. Writing stops at the first failure,
+// but keys that were written before it aren't rolled back. The returned error
+// is a ModifyManyError that lists them. The revision of each written key is
+// returned.
+func (db *KV[T]) ModifyMany(ctx context.Context, keys []string, fn func(current map[string]Revision[T]) (updates map[string]T, err error)) (revs map[string]uint64, err error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	current := make(map[string]Revision[T], len(keys))
+	for _, key := range keys {
+		r, ok, err := db.GetRev(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key %q: %w", key, err)
+		}
+		if ok {
+			current[key] = r
+		}
+	}
+	updates, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	updateKeys := make([]string, 0, len(updates))
+	for key := range updates {
+		updateKeys = append(updateKeys, key)
+	}
+	slices.Sort(updateKeys)
+	db.Log.Debug("Modifying values", slog.Int("count", len(updateKeys)))
+	revs = make(map[string]uint64, len(updateKeys))
+	var written []string
+	for _, key := range updateKeys {
+		var rev uint64
+		if r, ok := current[key]; ok {
+			rev, err = db.Update(ctx, key, updates[key], r.Rev)
+		} else {
+			rev, err = db.create(ctx, key, updates[key])
+		}
+		if err != nil {
+			return revs, ModifyManyError{Key: key, Written: written, Err: err}
+		}
+		revs[key] = rev
+		written = append(written, key)
+	}
+	return revs, nil
+}
+
+// create writes the value if the key doesn't exist, or has been deleted. If the
+// key exists, ErrOptimisticConcurrencyCheckFailed is returned.
+func (db *KV[T]) create(ctx context.Context, key string, value T) (rev uint64, err error) {
+	subject, err := db.keyToSubject(key)
+	if err != nil {
+		return 0, err
+	}
+	data, err := db.marshal(subject, value)
+	if err != nil {
+		return 0, err
+	}
+	db.Log.Debug("Creating value", slog.String("subject", subject))
+	rev, err = db.kv.Create(ctx, subject, data)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return 0, ErrOptimisticConcurrencyCheckFailed
+	}
+	if err != nil {
+		return 0, db.valueTooLarge(ctx, key, len(data), err)
+	}
+	return rev, nil
+}
@@ -0,0 +1,226 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var errUnmarshallable = errors.New("unmarshallable")
+
+type MaybeMarshallable struct {
+	Name string
+}
+
+func (m MaybeMarshallable) MarshalJSON() ([]byte, error) {
+	if m.Name == "bad" {
+		return nil, errUnmarshallable
+	}
+	return []byte(fmt.Sprintf(`{"Name":%q}`, m.Name)), nil
+}
+
+func TestKVMany(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  "test_kv_many",
+		History: 5,
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure creating bucket: %v", err)
+	}
+
+	t.Run("PutMany puts all of the items", func(t *testing.T) {
+		db := NewKV[User](kv, "users")
+		items := map[string]User{}
+		for i := 0; i < 100; i++ {
+			items[fmt.Sprintf("user%d", i)] = User{Name: fmt.Sprintf("user %d", i), Age: i}
+		}
+
+		revs, err := db.PutMany(ctx, items)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(revs) != len(items) {
+			t.Errorf("expected %d revisions, got %d", len(items), len(revs))
+		}
+		for key, expected := range items {
+			actual, rev, ok, err := db.Get(ctx, key)
+			if err != nil || !ok {
+				t.Fatalf("key %q: expected value, got ok=%v, err=%v", key, ok, err)
+			}
+			if actual != expected {
+				t.Errorf("key %q: expected %v, got %v", key, expected, actual)
+			}
+			if rev != revs[key] {
+				t.Errorf("key %q: expected revision %d, got %d", key, revs[key], rev)
+			}
+		}
+	})
+	t.Run("PutMany returns errors for each failed key, and writes the others", func(t *testing.T) {
+		db := NewKV[MaybeMarshallable](kv, "maybe")
+		items := map[string]MaybeMarshallable{
+			"a": {Name: "good"},
+			"b": {Name: "bad"},
+		}
+
+		revs, err := db.PutMany(ctx, items)
+
+		var keyErrs KeyErrors
+		if !errors.As(err, &keyErrs) {
+			t.Fatalf("expected KeyErrors, got %v", err)
+		}
+		if len(keyErrs) != 1 || !errors.Is(keyErrs["b"], errUnmarshallable) {
+			t.Errorf("expected a single error for key %q, got %v", "b", keyErrs)
+		}
+		if !errors.Is(err, errUnmarshallable) {
+			t.Error("expected the error to wrap the marshal error")
+		}
+		if _, ok := revs["a"]; !ok {
+			t.Errorf("expected a revision for key %q", "a")
+		}
+		if _, _, ok, _ := db.Get(ctx, "a"); !ok {
+			t.Errorf("expected key %q to be written", "a")
+		}
+	})
+	t.Run("ModifyMany writes the updates based on the current values", func(t *testing.T) {
+		db := NewKV[User](kv, "modify")
+		if _, err := db.Put(ctx, "from", User{Name: "from", Age: 10}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		revs, err := db.ModifyMany(ctx, []string{"from", "to"}, func(current map[string]Revision[User]) (map[string]User, error) {
+			if _, ok := current["to"]; ok {
+				t.Error("expected the missing key not to be included")
+			}
+			from := current["from"].Value
+			return map[string]User{
+				"from": {Name: "from", Age: from.Age - 5},
+				"to":   {Name: "to", Age: 5},
+			}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(revs) != 2 {
+			t.Errorf("expected 2 revisions, got %v", revs)
+		}
+		for key, age := range map[string]int{"from": 5, "to": 5} {
+			u, _, ok, err := db.Get(ctx, key)
+			if err != nil || !ok {
+				t.Fatalf("key %q: expected value, got ok=%v, err=%v", key, ok, err)
+			}
+			if u.Age != age {
+				t.Errorf("key %q: expected age %d, got %d", key, age, u.Age)
+			}
+		}
+	})
+	t.Run("ModifyMany stops at the first conflict, and returns the keys that were written", func(t *testing.T) {
+		db := NewKV[User](kv, "modify_conflict")
+		for _, key := range []string{"a", "b", "c"} {
+			if _, err := db.Put(ctx, key, User{Name: key}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		_, err := db.ModifyMany(ctx, []string{"a", "b", "c"}, func(current map[string]Revision[User]) (map[string]User, error) {
+			// Simulate a concurrent writer.
+			if _, err := db.Put(ctx, "b", User{Name: "concurrent"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return map[string]User{
+				"a": {Name: "a", Age: 1},
+				"b": {Name: "b", Age: 1},
+				"c": {Name: "c", Age: 1},
+			}, nil
+		})
+
+		var mme ModifyManyError
+		if !errors.As(err, &mme) {
+			t.Fatalf("expected ModifyManyError, got %v", err)
+		}
+		if mme.Key != "b" || len(mme.Written) != 1 || mme.Written[0] != "a" {
+			t.Errorf("expected key %q to fail after writing [a], got %q after writing %v", "b", mme.Key, mme.Written)
+		}
+		if !errors.Is(err, ErrOptimisticConcurrencyCheckFailed) {
+			t.Errorf("expected ErrOptimisticConcurrencyCheckFailed, got %v", err)
+		}
+		if c, _, _, _ := db.Get(ctx, "c"); c.Age != 0 {
+			t.Errorf("expected key %q not to be written", "c")
+		}
+	})
+	t.Run("HistoryMany returns the history of each key, and omits missing keys", func(t *testing.T) {
+		db := NewKV[User](kv, "history_many")
+		for _, key := range []string{"a", "b"} {
+			for age := range 2 {
+				if _, err := db.Put(ctx, key, User{Name: key, Age: age}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+		}
+
+		histories, err := db.HistoryMany(ctx, []string{"a", "b", "missing"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(histories) != 2 {
+			t.Fatalf("expected 2 histories, got %d", len(histories))
+		}
+		if _, ok := histories["missing"]; ok {
+			t.Error("expected the missing key to be omitted")
+		}
+		for _, key := range []string{"a", "b"} {
+			history := histories[key]
+			if len(history) != 2 {
+				t.Fatalf("key %q: expected 2 revisions, got %d", key, len(history))
+			}
+			for age, rev := range history {
+				if rev.Value.Name != key || rev.Value.Age != age {
+					t.Errorf("key %q: unexpected value at %d: %+v", key, age, rev.Value)
+				}
+			}
+			if history[0].Rev >= history[1].Rev {
+				t.Errorf("key %q: expected increasing revisions, got %d, %d", key, history[0].Rev, history[1].Rev)
+			}
+		}
+	})
+	t.Run("HistoryMany returns errors for each key that can't be decoded", func(t *testing.T) {
+		db := NewKV[User](kv, "history_many_invalid")
+		if _, err := db.Put(ctx, "valid", User{Name: "valid"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		subject, err := db.keyToSubject("invalid")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := kv.Put(ctx, subject, []byte("{")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		histories, err := db.HistoryMany(ctx, []string{"valid", "invalid"})
+
+		var keyErrs KeyErrors
+		if !errors.As(err, &keyErrs) {
+			t.Fatalf("expected KeyErrors, got %v", err)
+		}
+		if _, ok := keyErrs["invalid"]; !ok || len(keyErrs) != 1 {
+			t.Errorf("expected an error for the invalid key only, got %v", keyErrs)
+		}
+		if len(histories["valid"]) != 1 {
+			t.Errorf("expected the valid key's history to be returned, got %v", histories)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrValueTooLarge is returned when writing a value that's larger than the
+// bucket's maximum value size, or the server's maximum payload. The error is a
+// ValueTooLargeError.
+var ErrValueTooLarge = errors.New("value too large")
+
+// ValueTooLargeError is returned when writing a value that's larger than the
+// bucket allows. Values that grow without limit, e.g. documents, should be
+// stored in an ObjectStore instead.
+type ValueTooLargeError struct {
+	Key string
+	// Size is the size of the JSON encoded value in bytes.
+	Size int
+	// Max is the bucket's maximum value size in bytes, or 0 if the limit is
+	// the server's maximum payload.
+	Max int
+}
+
+func (e ValueTooLargeError) Error() string {
+	if e.Max > 0 {
+		return fmt.Sprintf("value of key %q is %d bytes, which exceeds the bucket's maximum value size of %d bytes, consider using an ObjectStore", e.Key, e.Size, e.Max)
+	}
+	return fmt.Sprintf("value of key %q is %d bytes, which exceeds the server's maximum payload, consider using an ObjectStore", e.Key, e.Size)
+}
+
+func (e ValueTooLargeError) Is(target error) bool {
+	return target == ErrValueTooLarge
+}
+
+// jsErrCodeMessageTooLarge is returned by the server when a message is larger
+// than the stream's maximum message size.
+const jsErrCodeMessageTooLarge jetstream.ErrorCode = 10054
+
+// valueTooLarge returns a ValueTooLargeError if err was caused by the value
+// being too large, and err otherwise.
+func (db *KV[T]) valueTooLarge(ctx context.Context, key string, size int, err error) error {
+	var apiErr jetstream.JetStreamError
+	isTooLarge := errors.Is(err, nats.ErrMaxPayload) ||
+		(errors.As(err, &apiErr) && apiErr.APIError() != nil && apiErr.APIError().ErrorCode == jsErrCodeMessageTooLarge)
+	if !isTooLarge {
+		return err
+	}
+	tooLarge := ValueTooLargeError{Key: key, Size: size}
+	if status, statusErr := db.kv.Status(ctx); statusErr == nil {
+		if bs, ok := status.(*jetstream.KeyValueBucketStatus); ok && bs.StreamInfo().Config.MaxMsgSize > 0 {
+			tooLarge.Max = int(bs.StreamInfo().Config.MaxMsgSize)
+		}
+	}
+	return tooLarge
+}
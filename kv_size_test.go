@@ -0,0 +1,62 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestValueTooLarge(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:       "small",
+		MaxValueSize: 64,
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure creating bucket: %v", err)
+	}
+	db := NewKV[User](kv, "users")
+	large := User{Name: strings.Repeat("a", 100)}
+
+	assertTooLarge := func(t *testing.T, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("expected ErrValueTooLarge, got %v", err)
+		}
+		var tooLarge ValueTooLargeError
+		if !errors.As(err, &tooLarge) {
+			t.Fatalf("expected a ValueTooLargeError, got %T", err)
+		}
+		if tooLarge.Key != "user1" || tooLarge.Size != 119 || tooLarge.Max != 64 {
+			t.Errorf("expected key user1, size 119 and max 64, got %+v", tooLarge)
+		}
+	}
+
+	t.Run("Put returns ErrValueTooLarge", func(t *testing.T) {
+		_, err := db.Put(ctx, "user1", large)
+		assertTooLarge(t, err)
+	})
+	t.Run("GetOrCreate returns ErrValueTooLarge", func(t *testing.T) {
+		_, _, _, err := db.GetOrCreate(ctx, "user1", func() User { return large })
+		assertTooLarge(t, err)
+	})
+	t.Run("Update returns ErrValueTooLarge", func(t *testing.T) {
+		rev, err := db.Put(ctx, "user1", User{Name: "a"})
+		if err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		_, err = db.Update(ctx, "user1", large, rev)
+		assertTooLarge(t, err)
+	})
+}
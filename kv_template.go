@@ -0,0 +1,63 @@
+package natsjson
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithSubjectTemplate stores each key at a structured subject built from the
+// template, so that subsets of the bucket can be listed or watched using
+// subject wildcards, e.g. with ListPrefix or Watch. The template contains dot
+// separated tokens, where tokens in braces are placeholders, e.g.
+// "{tenant}.users.{id}". parse returns the value of each placeholder for a
+// key. The values are stored verbatim, so they must be valid subject tokens,
+// without dots, and may only contain the characters a-z, A-Z, 0-9, "-", "/",
+// "_" and "=".
+//
+// For example, with the subject "users", the template "{tenant}.{id}", and a
+// parse function that splits "tenantA/123" into tenant and ID, the key is
+// stored at "users.tenantA.123", and can be watched with Watch(ctx, "tenantA.>").
+//
+// Keys are returned by List, Watch and Export in their stored form, e.g.
+// "tenantA.123". WithSubjectTemplate takes precedence over WithHierarchicalKeys
+// and WithRawKeys.
+func WithSubjectTemplate[T any](template string, parse func(key string) (fields map[string]string, err error)) KVOpt[T] {
+	return func(db *KV[T]) {
+		db.template = &subjectTemplate{
+			tokens: strings.Split(template, "."),
+			parse:  parse,
+		}
+	}
+}
+
+type subjectTemplate struct {
+	tokens []string
+	parse  func(key string) (fields map[string]string, err error)
+}
+
+var validToken = regexp.MustCompile(`^[-/_=a-zA-Z0-9]+$`)
+
+// fill returns the subject of the key, relative to the KV's subject.
+func (t *subjectTemplate) fill(key string) (subject string, err error) {
+	fields, err := t.parse(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %w", jetstream.ErrInvalidKey, key, err)
+	}
+	tokens := make([]string, len(t.tokens))
+	for i, token := range t.tokens {
+		if name, ok := strings.CutPrefix(token, "{"); ok && strings.HasSuffix(name, "}") {
+			name = strings.TrimSuffix(name, "}")
+			if token, ok = fields[name]; !ok {
+				return "", fmt.Errorf("%w: %q: missing field %q", jetstream.ErrInvalidKey, key, name)
+			}
+		}
+		if !validToken.MatchString(token) {
+			return "", fmt.Errorf("%w: %q: invalid subject token %q", jetstream.ErrInvalidKey, key, token)
+		}
+		tokens[i] = token
+	}
+	return strings.Join(tokens, "."), nil
+}
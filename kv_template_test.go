@@ -0,0 +1,97 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestKVSubjectTemplate(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: "test_kv_template",
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure creating bucket: %v", err)
+	}
+	parse := func(key string) (map[string]string, error) {
+		tenant, id, ok := strings.Cut(key, "/")
+		if !ok {
+			return nil, fmt.Errorf("expected <tenant>/<id>")
+		}
+		return map[string]string{"tenant": tenant, "id": id}, nil
+	}
+	db := NewKV[User](kv, "users", WithSubjectTemplate[User]("{tenant}.{id}", parse))
+	for _, key := range []string{"tenantA/1", "tenantA/2", "tenantB/1"} {
+		if _, err := db.Put(ctx, key, User{Name: key}); err != nil {
+			t.Fatalf("unexpected error putting %q: %v", key, err)
+		}
+	}
+
+	t.Run("values are stored at the templated subject", func(t *testing.T) {
+		entry, err := kv.Get(ctx, "users.tenantA.1")
+		if err != nil {
+			t.Fatalf("expected the key to be stored at the templated subject: %v", err)
+		}
+		if !strings.Contains(string(entry.Value()), "tenantA/1") {
+			t.Errorf("unexpected value %q", entry.Value())
+		}
+		u, _, ok, err := db.Get(ctx, "tenantA/1")
+		if err != nil || !ok {
+			t.Fatalf("expected value, got ok=%v, err=%v", ok, err)
+		}
+		if u.Name != "tenantA/1" {
+			t.Errorf("expected %q, got %q", "tenantA/1", u.Name)
+		}
+	})
+	t.Run("keys that can't fill the template are invalid", func(t *testing.T) {
+		for _, key := range []string{"no-tenant", "tenantA/has.dot", "tenantA/"} {
+			if _, err := db.Put(ctx, key, User{}); !errors.Is(err, jetstream.ErrInvalidKey) {
+				t.Errorf("%q: expected ErrInvalidKey, got %v", key, err)
+			}
+		}
+	})
+	t.Run("ListPrefix lists the keys matching the template's leading tokens", func(t *testing.T) {
+		entries, err := CollectSlice(db.ListPrefix(ctx, "tenantA"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		if diff := cmp.Diff([]string{"tenantA.1", "tenantA.2"}, keys, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Watch only returns changes to keys matching the filter", func(t *testing.T) {
+		it := db.Watch(ctx, "*.1")
+		defer it.Stop()
+		var keys []string
+		for len(keys) < 2 && it.Next() {
+			keys = append(keys, it.Value.Key)
+		}
+		if it.Error != nil {
+			t.Fatalf("unexpected error: %v", it.Error)
+		}
+		if diff := cmp.Diff([]string{"tenantA.1", "tenantB.1"}, keys, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
@@ -1,10 +1,17 @@
 package natsjson
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/a-h/natsjson/natsjsontest"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
@@ -15,7 +22,7 @@ type User struct {
 
 func TestKV(t *testing.T) {
 	// Arrange.
-	_, js, shutdown, err := NewInProcessNATSServer()
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,6 +42,11 @@ func TestKV(t *testing.T) {
 
 	db := NewKV[User](kv, "users")
 
+	t.Run("KeyValue returns the underlying bucket", func(t *testing.T) {
+		if db.KeyValue().Bucket() != bucketName {
+			t.Errorf("expected bucket %q, got %q", bucketName, db.KeyValue().Bucket())
+		}
+	})
 	t.Run("getting a non-existent value returns ok=false", func(t *testing.T) {
 		_, _, ok, err := db.Get(ctx, "non-existent-key")
 		if err != nil {
@@ -122,6 +134,27 @@ func TestKV(t *testing.T) {
 			t.Errorf("expected rev 1, got %d", actualRev)
 		}
 	})
+	t.Run("GetRev returns the latest value and its revision", func(t *testing.T) {
+		actual, ok, err := db.GetRev(ctx, "user1")
+		if err != nil {
+			t.Errorf("unexpected error getting value: %v", err)
+		}
+		if !ok {
+			t.Error("expected ok=true, got ok=false")
+		}
+		if diff := cmp.Diff(Revision[User]{Value: user1Rev2, Rev: 2}, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("GetRev returns ok=false for non-existent keys", func(t *testing.T) {
+		_, ok, err := db.GetRev(ctx, "non-existent-key")
+		if err != nil {
+			t.Errorf("unexpected error getting value: %v", err)
+		}
+		if ok {
+			t.Error("expected ok=false, got ok=true")
+		}
+	})
 	t.Run("GetRevision", func(t *testing.T) {
 		actual, ok, err := db.GetRevision(ctx, "user1", 1)
 		if err != nil {
@@ -143,6 +176,24 @@ func TestKV(t *testing.T) {
 			t.Error("expected ok=false, got ok=true")
 		}
 	})
+	t.Run("GetRevisionEntry includes the revision's metadata", func(t *testing.T) {
+		actual, meta, ok, err := db.GetRevisionEntry(ctx, "user1", 1)
+		if err != nil {
+			t.Errorf("unexpected error getting value: %v", err)
+		}
+		if !ok {
+			t.Error("expected ok=true, got ok=false")
+		}
+		if diff := cmp.Diff(user1Rev1, actual); diff != "" {
+			t.Error(diff)
+		}
+		if meta.Revision != 1 || meta.Operation != jetstream.KeyValuePut {
+			t.Errorf("expected revision 1 to be a put, got %+v", meta)
+		}
+		if time.Since(meta.Created) > time.Minute {
+			t.Errorf("expected a recent created time, got %v", meta.Created)
+		}
+	})
 	t.Run("History", func(t *testing.T) {
 		actual, ok, err := db.History(ctx, "user1")
 		if err != nil {
@@ -199,6 +250,63 @@ func TestKV(t *testing.T) {
 			t.Error("expected ok=false, got ok=true")
 		}
 	})
+	t.Run("Exists returns true for keys that have a value", func(t *testing.T) {
+		if ok, err := db.Exists(ctx, "user1"); err != nil || !ok {
+			t.Errorf("expected user1 to exist, got ok=%v, err=%v", ok, err)
+		}
+		if ok, err := db.Exists(ctx, "user5"); err != nil || ok {
+			t.Errorf("expected deleted user5 not to exist, got ok=%v, err=%v", ok, err)
+		}
+		if ok, err := db.Exists(ctx, "non-existent-key"); err != nil || ok {
+			t.Errorf("expected non-existent-key not to exist, got ok=%v, err=%v", ok, err)
+		}
+	})
+	t.Run("Exists doesn't decode the value", func(t *testing.T) {
+		if _, err := kv.Put(ctx, "invalid.user1", []byte(`{ _this_is_not_json_ }`)); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		defer kv.Purge(ctx, "invalid.user1")
+		invalid := NewKV[User](kv, "invalid", WithRawKeys[User]())
+		if ok, err := invalid.Exists(ctx, "user1"); err != nil || !ok {
+			t.Errorf("expected user1 to exist, got ok=%v, err=%v", ok, err)
+		}
+	})
+	t.Run("with WithReadOnly, values can be read, but not written", func(t *testing.T) {
+		ro := NewKV[User](kv, "users", WithReadOnly[User]())
+		if _, _, ok, err := ro.Get(ctx, "user1"); err != nil || !ok {
+			t.Errorf("expected to read user1, got ok=%v, err=%v", ok, err)
+		}
+		if _, err := ro.Put(ctx, "user1", user1Rev1); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Put: expected ErrReadOnly, got %v", err)
+		}
+		if _, err := ro.Update(ctx, "user1", user1Rev1, 3); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Update: expected ErrReadOnly, got %v", err)
+		}
+		if err := ro.Delete(ctx, "user1"); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Delete: expected ErrReadOnly, got %v", err)
+		}
+		if _, _, _, err := ro.GetOrCreate(ctx, "read-only-user", func() User { return User{} }); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("GetOrCreate: expected ErrReadOnly, got %v", err)
+		}
+		if _, _, err := ro.Upsert(ctx, "user1", user1Rev1); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Upsert: expected ErrReadOnly, got %v", err)
+		}
+		if _, err := ro.PutMany(ctx, map[string]User{"user1": user1Rev1}); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("PutMany: expected ErrReadOnly, got %v", err)
+		}
+	})
+	t.Run("Status returns bucket information", func(t *testing.T) {
+		status, err := db.Status(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error getting status: %v", err)
+		}
+		if status.Bucket() != bucketName {
+			t.Errorf("expected bucket %q, got %q", bucketName, status.Bucket())
+		}
+		if status.History() != 10 {
+			t.Errorf("expected history of 10, got %d", status.History())
+		}
+	})
 	t.Run("List can iterate the bucket", func(t *testing.T) {
 		if _, err = db.Put(ctx, "user2", user2Rev1); err != nil {
 			t.Fatalf("unexpected error putting user 2: %v", err)
@@ -226,4 +334,594 @@ func TestKV(t *testing.T) {
 			t.Error(diff)
 		}
 	})
+	t.Run("CollectSlice drains the iterator", func(t *testing.T) {
+		expected := []User{user1Rev3, user2Rev1, user3Rev1, user4Rev1}
+		actual, err := CollectSlice(db.List(ctx))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("CollectMap drains the iterator into a map", func(t *testing.T) {
+		expected := map[string]User{
+			user1Rev3.Name: user1Rev3,
+			user2Rev1.Name: user2Rev1,
+			user3Rev1.Name: user3Rev1,
+			user4Rev1.Name: user4Rev1,
+		}
+		actual, err := CollectMap(db.List(ctx), func(u User) string { return u.Name })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("All can iterate the bucket", func(t *testing.T) {
+		expected := []User{user1Rev3, user2Rev1, user3Rev1, user4Rev1}
+		var actual []User
+		for v, err := range db.All(ctx) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual = append(actual, v)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("AllEntries includes revisions", func(t *testing.T) {
+		var actual []uint64
+		for entry, err := range db.AllEntries(ctx) {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual = append(actual, entry.Rev)
+		}
+		if len(actual) != 4 {
+			t.Fatalf("expected 4 entries, got %d", len(actual))
+		}
+		if actual[0] != 3 {
+			t.Errorf("expected the first entry to be at rev 3, got %d", actual[0])
+		}
+	})
+	t.Run("All stops when the context is cancelled", func(t *testing.T) {
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		var err error
+		for _, err = range db.All(cancelledCtx) {
+		}
+		if err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+	t.Run("ListPrefix requires hierarchical keys", func(t *testing.T) {
+		it := db.ListPrefix(ctx, "tenantA")
+		if it.Next() {
+			t.Error("expected no values")
+		}
+		if it.Error != ErrPrefixListingNotSupported {
+			t.Errorf("expected ErrPrefixListingNotSupported, got %v", it.Error)
+		}
+	})
+	t.Run("ListPrefix lists entries under the prefix", func(t *testing.T) {
+		tenants := NewKV[User](kv, "tenants", WithHierarchicalKeys[User]())
+		if _, err := tenants.Put(ctx, "tenantA.user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if _, err := tenants.Put(ctx, "tenantA.user2", user2Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if _, err := tenants.Put(ctx, "tenantB.user3", user3Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		value, _, ok, err := tenants.Get(ctx, "tenantB.user3")
+		if err != nil || !ok {
+			t.Fatalf("expected to get value, got ok=%v, err=%v", ok, err)
+		}
+		if diff := cmp.Diff(user3Rev1, value); diff != "" {
+			t.Error(diff)
+		}
+
+		entries, err := CollectSlice(tenants.ListPrefix(ctx, "tenantA"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var actual []User
+		for _, e := range entries {
+			actual = append(actual, e.Value)
+			if !strings.HasPrefix(e.Key, "tenantA.") {
+				t.Errorf("expected key to have prefix %q, got %q", "tenantA.", e.Key)
+			}
+		}
+		expected := []User{user1Rev1, user2Rev1}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Hierarchical key prefixes must be valid subject tokens", func(t *testing.T) {
+		tenants := NewKV[User](kv, "tenants", WithHierarchicalKeys[User]())
+		for _, key := range []string{".user1", "a..b.user1", "*.user1", ">.user1", "has space.user1"} {
+			if _, err := tenants.Put(ctx, key, user1Rev1); !errors.Is(err, jetstream.ErrInvalidKey) {
+				t.Errorf("key %q: expected ErrInvalidKey, got %v", key, err)
+			}
+		}
+		for _, prefix := range []string{"", "a..b", "*", "tenantA.>"} {
+			if _, err := CollectSlice(tenants.ListPrefix(ctx, prefix)); !errors.Is(err, jetstream.ErrInvalidKey) {
+				t.Errorf("prefix %q: expected ErrInvalidKey, got %v", prefix, err)
+			}
+		}
+	})
+	t.Run("Raw keys are stored verbatim", func(t *testing.T) {
+		raw := NewKV[User](kv, "raw", WithRawKeys[User]())
+		if _, err := raw.Put(ctx, "tenantA.user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		entry, err := kv.Get(ctx, "raw.tenantA.user1")
+		if err != nil {
+			t.Fatalf("unexpected error getting underlying value: %v", err)
+		}
+		if entry.Revision() == 0 {
+			t.Error("expected a non-zero revision")
+		}
+		entries, err := CollectSlice(raw.ListPrefix(ctx, "tenantA"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Key != "tenantA.user1" {
+			t.Errorf("expected a single entry with key %q, got %v", "tenantA.user1", entries)
+		}
+	})
+	t.Run("Raw keys must be valid subject tokens", func(t *testing.T) {
+		raw := NewKV[User](kv, "raw", WithRawKeys[User]())
+		for _, key := range []string{"", "has space", "wild.*", "wild.>", ".leading", "trailing.", "double..dot"} {
+			if _, err := raw.Put(ctx, key, user1Rev1); !errors.Is(err, jetstream.ErrInvalidKey) {
+				t.Errorf("key %q: expected ErrInvalidKey, got %v", key, err)
+			}
+		}
+	})
+	t.Run("Operations are logged with the subject", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		logged := NewKV[User](kv, "logged", WithRawKeys[User](), WithKVLogger[User](log))
+		if _, err := logged.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if !strings.Contains(buf.String(), `"subject":"logged.user1"`) {
+			t.Errorf("expected the subject to be logged, got %q", buf.String())
+		}
+	})
+	t.Run("WatchAll returns the current values, then changes", func(t *testing.T) {
+		// Arrange.
+		watched := NewKV[User](kv, "watched", WithRawKeys[User]())
+		if _, err := watched.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// Act.
+		it := watched.WatchAll(ctx)
+		defer it.Stop()
+		if _, err := watched.Put(ctx, "user1", user1Rev2); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if err := watched.Delete(ctx, "user1"); err != nil {
+			t.Fatalf("unexpected error deleting value: %v", err)
+		}
+		var changes []Change[User]
+		for len(changes) < 3 && it.Next() {
+			changes = append(changes, it.Value)
+		}
+		if it.Error != nil {
+			t.Fatalf("unexpected error watching: %v", it.Error)
+		}
+
+		// Assert.
+		expected := []Change[User]{
+			{Key: "user1", Value: user1Rev1, Op: jetstream.KeyValuePut},
+			{Key: "user1", Value: user1Rev2, Op: jetstream.KeyValuePut},
+			{Key: "user1", Op: jetstream.KeyValueDelete},
+		}
+		if diff := cmp.Diff(expected, changes, cmpopts.IgnoreFields(Change[User]{}, "Revision")); diff != "" {
+			t.Error(diff)
+		}
+		for i := 1; i < len(changes); i++ {
+			if changes[i].Revision <= changes[i-1].Revision {
+				t.Errorf("expected revisions to increase, got %d then %d", changes[i-1].Revision, changes[i].Revision)
+			}
+		}
+	})
+	t.Run("WatchAll stops when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		it := NewKV[User](kv, "watched_cancel").WatchAll(ctx)
+		defer it.Stop()
+		cancel()
+		if it.Next() {
+			t.Fatalf("expected no changes, got %v", it.Value)
+		}
+		if !errors.Is(it.Error, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", it.Error)
+		}
+	})
+	t.Run("GetOrCreate creates the default value if the key doesn't exist", func(t *testing.T) {
+		db := NewKV[User](kv, "get_or_create")
+		value, rev, created, err := db.GetOrCreate(ctx, "user1", func() User { return user1Rev1 })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !created {
+			t.Error("expected created=true")
+		}
+		if diff := cmp.Diff(user1Rev1, value); diff != "" {
+			t.Error(diff)
+		}
+
+		value, rev2, created, err := db.GetOrCreate(ctx, "user1", func() User { return user1Rev2 })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if created {
+			t.Error("expected created=false for an existing key")
+		}
+		if diff := cmp.Diff(user1Rev1, value); diff != "" {
+			t.Error(diff)
+		}
+		if rev != rev2 {
+			t.Errorf("expected revision %d, got %d", rev, rev2)
+		}
+	})
+	t.Run("GetOrCreate returns the existing value if another writer creates it first", func(t *testing.T) {
+		db := NewKV[User](kv, "get_or_create_race")
+		value, _, created, err := db.GetOrCreate(ctx, "user1", func() User {
+			// Simulate a concurrent writer.
+			if _, err := db.Put(ctx, "user1", user1Rev2); err != nil {
+				t.Fatalf("unexpected error putting value: %v", err)
+			}
+			return user1Rev1
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if created {
+			t.Error("expected created=false")
+		}
+		if diff := cmp.Diff(user1Rev2, value); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Upsert creates the value if the key doesn't exist, and overwrites it otherwise", func(t *testing.T) {
+		db := NewKV[User](kv, "upsert")
+		rev1, created, err := db.Upsert(ctx, "user1", user1Rev1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !created {
+			t.Error("expected created=true for a new key")
+		}
+
+		rev2, created, err := db.Upsert(ctx, "user1", user1Rev2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if created {
+			t.Error("expected created=false for an existing key")
+		}
+		if rev2 <= rev1 {
+			t.Errorf("expected revision %d to be greater than %d", rev2, rev1)
+		}
+		value, _, _, err := db.Get(ctx, "user1")
+		if err != nil {
+			t.Fatalf("unexpected error getting value: %v", err)
+		}
+		if diff := cmp.Diff(user1Rev2, value); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithKVDisallowUnknownFields, values with unknown fields can't be read", func(t *testing.T) {
+		if _, err := kv.Put(ctx, "strict.user1", []byte(`{"name":"alice","nmae":"typo"}`)); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		lenient := NewKV[User](kv, "strict", WithRawKeys[User]())
+		if _, _, _, err := lenient.Get(ctx, "user1"); err != nil {
+			t.Errorf("unexpected error reading value: %v", err)
+		}
+		strict := NewKV[User](kv, "strict", WithRawKeys[User](), WithKVDisallowUnknownFields[User]())
+		if _, _, _, err := strict.Get(ctx, "user1"); err == nil {
+			t.Error("expected an error reading a value with unknown fields")
+		}
+	})
+	t.Run("WatchAll with WithPreviousValues includes the previous value of each key", func(t *testing.T) {
+		// Arrange.
+		watched := NewKV[User](kv, "watched_previous", WithRawKeys[User]())
+		if _, err := watched.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// Act.
+		it := watched.WatchAll(ctx, WithPreviousValues())
+		defer it.Stop()
+		if _, err := watched.Put(ctx, "user1", user1Rev2); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if err := watched.Delete(ctx, "user1"); err != nil {
+			t.Fatalf("unexpected error deleting value: %v", err)
+		}
+		if _, err := watched.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		var changes []Change[User]
+		for len(changes) < 4 && it.Next() {
+			changes = append(changes, it.Value)
+		}
+		if it.Error != nil {
+			t.Fatalf("unexpected error watching: %v", it.Error)
+		}
+
+		// Assert.
+		expected := []Change[User]{
+			{Key: "user1", Value: user1Rev1, Op: jetstream.KeyValuePut},
+			{Key: "user1", Value: user1Rev2, Op: jetstream.KeyValuePut, Previous: user1Rev1, HadPrevious: true},
+			{Key: "user1", Op: jetstream.KeyValueDelete, Previous: user1Rev2, HadPrevious: true},
+			{Key: "user1", Value: user1Rev1, Op: jetstream.KeyValuePut},
+		}
+		if diff := cmp.Diff(expected, changes, cmpopts.IgnoreFields(Change[User]{}, "Revision")); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("with WithNamespace, namespaces in the same bucket are isolated", func(t *testing.T) {
+		// Arrange.
+		tenantA := NewKV[User](kv, "users", WithNamespace[User]("tenantA"))
+		tenantB := NewKV[User](kv, "users", WithNamespace[User]("tenantB"))
+
+		// Act.
+		if _, err := tenantA.Put(ctx, "user1", User{Name: "alice"}); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if _, err := tenantB.Put(ctx, "user1", User{Name: "bob"}); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+
+		// Assert.
+		for _, test := range []struct {
+			db       *KV[User]
+			expected User
+		}{
+			{db: tenantA, expected: User{Name: "alice"}},
+			{db: tenantB, expected: User{Name: "bob"}},
+		} {
+			actual, _, ok, err := test.db.Get(ctx, "user1")
+			if err != nil || !ok {
+				t.Fatalf("expected to get user1, got ok=%v, err=%v", ok, err)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error(diff)
+			}
+			values, err := CollectSlice(test.db.List(ctx))
+			if err != nil {
+				t.Fatalf("unexpected error listing values: %v", err)
+			}
+			if diff := cmp.Diff([]User{test.expected}, values); diff != "" {
+				t.Error(diff)
+			}
+		}
+		if _, err := kv.Get(ctx, "tenantA.users."+hashKey("user1")); err != nil {
+			t.Errorf("expected the namespace to prefix the subject: %v", err)
+		}
+	})
+	t.Run("HistoryEntries includes revisions, timestamps and deletes", func(t *testing.T) {
+		db := NewKV[User](kv, "history_entries")
+		if _, err := db.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if err := db.Delete(ctx, "user1"); err != nil {
+			t.Fatalf("unexpected error deleting value: %v", err)
+		}
+		if _, err := db.Put(ctx, "user1", user1Rev2); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+
+		actual, ok, err := db.HistoryEntries(ctx, "user1")
+		if err != nil {
+			t.Fatalf("unexpected error getting history: %v", err)
+		}
+		if !ok {
+			t.Error("expected ok=true, got ok=false")
+		}
+
+		expected := []HistoryEntry[User]{
+			{Value: user1Rev1, Op: jetstream.KeyValuePut},
+			{Op: jetstream.KeyValueDelete},
+			{Value: user1Rev2, Op: jetstream.KeyValuePut},
+		}
+		if diff := cmp.Diff(expected, actual, cmpopts.IgnoreFields(HistoryEntry[User]{}, "Revision", "Created")); diff != "" {
+			t.Error(diff)
+		}
+		for i := 1; i < len(actual); i++ {
+			if actual[i].Revision <= actual[i-1].Revision {
+				t.Errorf("expected increasing revisions, got %d then %d", actual[i-1].Revision, actual[i].Revision)
+			}
+		}
+		if len(actual) > 0 && actual[0].Created.IsZero() {
+			t.Error("expected a created time")
+		}
+	})
+	t.Run("WatchAllFrom returns the changes after the revision", func(t *testing.T) {
+		// Arrange.
+		watched := NewKV[User](kv, "watched_from", WithRawKeys[User]())
+		checkpoint, err := watched.Put(ctx, "user1", user1Rev1)
+		if err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if _, err := watched.Put(ctx, "user1", user1Rev2); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if err := watched.Delete(ctx, "user1"); err != nil {
+			t.Fatalf("unexpected error deleting value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// Act.
+		it := watched.WatchAllFrom(ctx, checkpoint)
+		defer it.Stop()
+		if _, err := watched.Put(ctx, "user2", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		var changes []Change[User]
+		for len(changes) < 3 && it.Next() {
+			changes = append(changes, it.Value)
+		}
+		if it.Error != nil {
+			t.Fatalf("unexpected error watching: %v", it.Error)
+		}
+
+		// Assert.
+		expected := []Change[User]{
+			{Key: "user1", Value: user1Rev2, Op: jetstream.KeyValuePut},
+			{Key: "user1", Op: jetstream.KeyValueDelete},
+			{Key: "user2", Value: user1Rev1, Op: jetstream.KeyValuePut},
+		}
+		if diff := cmp.Diff(expected, changes, cmpopts.IgnoreFields(Change[User]{}, "Revision")); diff != "" {
+			t.Error(diff)
+		}
+		for _, c := range changes {
+			if c.Revision <= checkpoint {
+				t.Errorf("expected revisions after %d, got %d", checkpoint, c.Revision)
+			}
+		}
+	})
+	t.Run("WatchAllFrom with a revision of 0 returns every change in the history", func(t *testing.T) {
+		// Arrange.
+		watched := NewKV[User](kv, "watched_from_start", WithRawKeys[User]())
+		if _, err := watched.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		if _, err := watched.Put(ctx, "user1", user1Rev2); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		// Act.
+		it := watched.WatchAllFrom(ctx, 0)
+		defer it.Stop()
+		var changes []Change[User]
+		for len(changes) < 2 && it.Next() {
+			changes = append(changes, it.Value)
+		}
+		if it.Error != nil {
+			t.Fatalf("unexpected error watching: %v", it.Error)
+		}
+
+		// Assert.
+		expected := []Change[User]{
+			{Key: "user1", Value: user1Rev1, Op: jetstream.KeyValuePut},
+			{Key: "user1", Value: user1Rev2, Op: jetstream.KeyValuePut},
+		}
+		if diff := cmp.Diff(expected, changes, cmpopts.IgnoreFields(Change[User]{}, "Revision")); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("DeleteExisting reports whether the key had a value", func(t *testing.T) {
+		db := NewKV[User](kv, "delete_existing")
+		if _, err := db.Put(ctx, "user1", user1Rev1); err != nil {
+			t.Fatalf("unexpected error putting value: %v", err)
+		}
+		for i, expected := range []bool{true, false} {
+			existed, err := db.DeleteExisting(ctx, "user1")
+			if err != nil {
+				t.Fatalf("unexpected error deleting value: %v", err)
+			}
+			if existed != expected {
+				t.Errorf("delete %d: expected existed=%v, got %v", i, expected, existed)
+			}
+		}
+		existed, err := db.DeleteExisting(ctx, "never-existed")
+		if err != nil {
+			t.Fatalf("unexpected error deleting value: %v", err)
+		}
+		if existed {
+			t.Error("expected existed=false for a key that never existed")
+		}
+		if _, err := NewKV[User](kv, "delete_existing", WithReadOnly[User]()).DeleteExisting(ctx, "user1"); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected ErrReadOnly, got %v", err)
+		}
+	})
+	t.Run("List stops when the context is cancelled, and can still be stopped", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		it := db.List(ctx)
+		cancel()
+		if it.Next() {
+			t.Errorf("expected no values once the context is cancelled, got %v", it.Value)
+		}
+		if !errors.Is(it.Error, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", it.Error)
+		}
+		// Wait for the watcher to be stopped by the context.
+		time.Sleep(50 * time.Millisecond)
+		if err := it.Stop(); err != nil {
+			t.Errorf("unexpected error stopping: %v", err)
+		}
+	})
+}
+
+type closedWatcher struct {
+	updates chan jetstream.KeyValueEntry
+}
+
+func (w closedWatcher) Updates() <-chan jetstream.KeyValueEntry { return w.updates }
+func (w closedWatcher) Stop() error                             { return nil }
+
+func TestKVWatcherClosed(t *testing.T) {
+	t.Run("a closed watcher is an error, not the end of the values", func(t *testing.T) {
+		w := closedWatcher{updates: make(chan jetstream.KeyValueEntry)}
+		close(w.updates)
+		_, closed, err := newKVWatcher(w).next(context.Background())
+		if !closed {
+			t.Error("expected closed=true")
+		}
+		if !errors.Is(err, ErrWatcherClosed) {
+			t.Errorf("expected ErrWatcherClosed, got %v", err)
+		}
+	})
+	t.Run("a stopped watcher isn't an error", func(t *testing.T) {
+		w := closedWatcher{updates: make(chan jetstream.KeyValueEntry)}
+		close(w.updates)
+		kw := newKVWatcher(w)
+		if err := kw.stop(); err != nil {
+			t.Fatalf("unexpected error stopping: %v", err)
+		}
+		if _, closed, err := kw.next(context.Background()); !closed || err != nil {
+			t.Errorf("expected closed=true and no error, got closed=%v, err=%v", closed, err)
+		}
+	})
+	t.Run("Watch returns ErrWatcherClosed when the connection is closed", func(t *testing.T) {
+		conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "watcher_closed"})
+		if err != nil {
+			t.Fatalf("unexpected failure creating bucket: %v", err)
+		}
+		it := NewKV[User](kv, "users").WatchAll(ctx)
+		defer it.Stop()
+
+		conn.Close()
+
+		if it.Next() {
+			t.Fatalf("expected no changes, got %v", it.Value)
+		}
+		if !errors.Is(it.Error, ErrWatcherClosed) {
+			t.Errorf("expected ErrWatcherClosed, got %v", it.Error)
+		}
+	})
 }
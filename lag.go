@@ -0,0 +1,73 @@
+package natsjson
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ConsumerLag is the number of messages that a consumer hasn't finished with.
+type ConsumerLag struct {
+	// Pending is the number of messages in the stream that haven't been
+	// delivered to the consumer yet.
+	Pending uint64
+	// AckPending is the number of messages that have been delivered, but not
+	// acked yet.
+	AckPending int
+	// Redelivered is the number of messages that have been delivered more
+	// than once, and not acked yet.
+	Redelivered int
+}
+
+// GetConsumerLag reads the consumer's info from the server, and returns its lag.
+func GetConsumerLag(ctx context.Context, consumer jetstream.Consumer) (lag ConsumerLag, err error) {
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return lag, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	return ConsumerLag{
+		Pending:     info.NumPending,
+		AckPending:  info.NumAckPending,
+		Redelivered: info.NumRedelivered,
+	}, nil
+}
+
+// Lag returns the lag of the processor's consumer.
+func (b *BatchProcessor[T]) Lag(ctx context.Context) (lag ConsumerLag, err error) {
+	return GetConsumerLag(ctx, b.consumer)
+}
+
+// Pending returns the number of messages in the stream that haven't been
+// delivered to the processor's consumer yet.
+func (b *BatchProcessor[T]) Pending(ctx context.Context) (pending uint64, err error) {
+	lag, err := b.Lag(ctx)
+	return lag.Pending, err
+}
+
+// drainPollInterval is how often WaitForDrain checks the consumer's lag.
+const drainPollInterval = 10 * time.Millisecond
+
+// WaitForDrain waits until every message in the stream has been delivered to
+// the consumer and acked, e.g. to wait for a processor to handle the messages
+// that were just published. If the context is cancelled first, the error
+// includes the consumer's lag.
+func WaitForDrain(ctx context.Context, consumer jetstream.Consumer) (err error) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		lag, err := GetConsumerLag(ctx, consumer)
+		if err != nil {
+			return err
+		}
+		if lag.Pending == 0 && lag.AckPending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("consumer not drained, %d pending, %d awaiting ack: %w", lag.Pending, lag.AckPending, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
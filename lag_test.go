@@ -0,0 +1,108 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestLag(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "lag",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "lag", jetstream.ConsumerConfig{
+		Durable: "lagProcessor",
+		// Redeliver the message that isn't acked by the Lag test.
+		AckWait:       time.Second,
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](nil, WithPublisherJetStream[BatchMessage](js))
+	for i := 0; i < 5; i++ {
+		if _, err := pub.PublishJetStream(ctx, "lag.message", BatchMessage{Index: i}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+	}
+
+	t.Run("Pending returns the number of undelivered messages", func(t *testing.T) {
+		bp := NewBatchProcessor[BatchMessage](consumer, 2, nil)
+		pending, err := bp.Pending(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pending != 5 {
+			t.Errorf("expected 5 pending messages, got %d", pending)
+		}
+	})
+	t.Run("Lag includes messages that haven't been acked", func(t *testing.T) {
+		// Process the first batch, then fetch a message without acking it.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 2, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*100)), WithAckSync[BatchMessage]())
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		mb, err := consumer.Fetch(1, jetstream.FetchMaxWait(time.Millisecond*100))
+		if err != nil {
+			t.Fatalf("unexpected error fetching: %v", err)
+		}
+		for range mb.Messages() {
+			// Don't ack the message.
+		}
+
+		lag, err := bp.Lag(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(ConsumerLag{Pending: 2, AckPending: 1}, lag); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("WaitForDrain returns an error if the consumer isn't drained before the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+		defer cancel()
+		if err := WaitForDrain(ctx, consumer); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+	t.Run("WaitForDrain waits for the processor to ack every message", func(t *testing.T) {
+		// Arrange.
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return nil
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithBatchMaxWait[BatchMessage](time.Millisecond*100))
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go bp.Run(ctx)
+
+		// Act.
+		waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer waitCancel()
+		err := WaitForDrain(waitCtx, consumer)
+
+		// Assert.
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
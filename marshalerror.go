@@ -0,0 +1,41 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMarshalFailed is matched by errors.Is for a MarshalError.
+var ErrMarshalFailed = errors.New("failed to marshal message")
+
+// MarshalError is returned when publishing if a value can't be marshalled to
+// JSON, e.g. because it contains a channel, or a NaN float. None of the values
+// from Index onwards are published.
+type MarshalError struct {
+	// Index is the index of the value that couldn't be marshalled, in the
+	// values passed to the publish method.
+	Index int
+	// Err is the error returned by the marshaller.
+	Err error
+}
+
+func (e MarshalError) Error() string {
+	return fmt.Sprintf("failed to marshal message %d: %v", e.Index, e.Err)
+}
+
+func (e MarshalError) Unwrap() error {
+	return e.Err
+}
+
+func (e MarshalError) Is(target error) bool {
+	return target == ErrMarshalFailed
+}
+
+// withIndex sets the index of a MarshalError, and returns other errors as-is.
+func withIndex(err error, i int) error {
+	if me, ok := err.(MarshalError); ok {
+		me.Index = i
+		return me
+	}
+	return err
+}
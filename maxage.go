@@ -0,0 +1,38 @@
+package natsjson
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrMessageTooOld is passed to OnStale, and recorded by the quarantine, for
+// messages that are older than the maximum age set by WithMaxAge.
+var ErrMessageTooOld = errors.New("message is older than the maximum age")
+
+// WithMaxAge skips messages that were stored in the stream more than d before
+// they're received, e.g. a backlog that built up during an outage, and is no
+// longer actionable. Stale messages aren't passed to the processor, and are
+// acked, even if WithQuarantine is set, unless OnStale returns a different
+// action, e.g. DecodeQuarantine. Stale messages are counted in the Stale and
+// Skipped fields of the ProcessResult.
+func WithMaxAge[T any](d time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.maxAge = d
+	}
+}
+
+// isStale returns how long ago the message was stored in the stream, and whether
+// it's older than the maximum age.
+func (b *BatchProcessor[T]) isStale(msg jetstream.Msg, now time.Time) (age time.Duration, stale bool) {
+	if b.maxAge <= 0 {
+		return 0, false
+	}
+	md, err := msg.Metadata()
+	if err != nil {
+		return 0, false
+	}
+	age = now.Sub(md.Timestamp)
+	return age, age > b.maxAge
+}
@@ -0,0 +1,121 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// MessageIterator receives messages continuously using the consumer's Messages
+// method, which buffers messages and pulls more as they're consumed, so that
+// the caller doesn't wait between batches.
+type MessageIterator[T any] struct {
+	Log *slog.Logger
+	// OnDecodeError is called with the raw data of each message that couldn't
+	// be decoded, and returns what to do with the message. If not set, the
+	// message is acked and skipped.
+	OnDecodeError func(raw []byte, subject string, err error) DecodeAction
+	decoder[T]
+
+	ctx  context.Context
+	mc   jetstream.MessagesContext
+	stop func() bool
+	err  error
+}
+
+// NewMessageIterator creates an iterator over the messages received by the
+// consumer. The StreamProcessor options control buffering and decoding. The
+// iterator stops when the context is cancelled, or Stop is called.
+func NewMessageIterator[T any](ctx context.Context, consumer jetstream.Consumer, opts ...StreamProcessorOpt[T]) (it *MessageIterator[T], err error) {
+	var cfg StreamProcessor[T]
+	cfg.configure(opts)
+	var mopts []jetstream.PullMessagesOpt
+	if cfg.pullMaxMessages > 0 {
+		mopts = append(mopts, jetstream.PullMaxMessages(cfg.pullMaxMessages))
+	}
+	mc, err := consumer.Messages(mopts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	it = &MessageIterator[T]{
+		Log:     cfg.Log,
+		decoder: cfg.decoder,
+		ctx:     ctx,
+		mc:      mc,
+	}
+	it.stop = context.AfterFunc(ctx, mc.Stop)
+	return it, nil
+}
+
+// Next waits for the next message that can be decoded, and returns its value,
+// and a function that must be called with the result of processing the value.
+// If the result is nil, the message is acked, otherwise it's nacked so that
+// it's redelivered. Messages that can't be decoded are skipped.
+//
+// Once the iterator has been stopped, or the context has been cancelled,
+// jetstream.ErrMsgIteratorClosed is returned.
+func (it *MessageIterator[T]) Next() (value T, done func(err error) error, err error) {
+	for {
+		msg, err := it.mc.Next()
+		if err != nil {
+			return value, nil, err
+		}
+		value, _, _, err = it.decodeMsg(msg)
+		if err != nil {
+			it.Log.Warn("Failed to unmarshal, skipping invalid message", slog.String("subject", msg.Subject()), slog.Any("error", err))
+			if ackErr := it.skip(it.ctx, it.Log, msg, it.OnDecodeError, err); ackErr != nil {
+				it.Log.Warn("Failed to acknowledge invalid message", slog.Any("error", ackErr))
+			}
+			continue
+		}
+		done = func(err error) error {
+			if err != nil {
+				return msg.Nak()
+			}
+			return msg.Ack()
+		}
+		return value, done, nil
+	}
+}
+
+// All returns an iterator over the values, and the functions that must be
+// called with the result of processing each value. Iteration ends when the
+// iterator is stopped, the context is cancelled, or an error occurs. Use Err
+// to find out why iteration ended.
+func (it *MessageIterator[T]) All() iter.Seq2[T, func(err error) error] {
+	return func(yield func(T, func(err error) error) bool) {
+		for {
+			value, done, err := it.Next()
+			if err != nil {
+				if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+					// The context's error, if any, distinguishes cancellation
+					// from calling Stop.
+					err = it.ctx.Err()
+				}
+				it.err = err
+				return
+			}
+			if !yield(value, done) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended iteration by All. If iteration was ended by
+// cancelling the context, it's the context's error. If it was ended by calling
+// Stop, it's nil.
+func (it *MessageIterator[T]) Err() error {
+	return it.err
+}
+
+// Stop stops receiving messages. Messages that have been buffered, but not
+// returned, are redelivered once the consumer's AckWait elapses.
+func (it *MessageIterator[T]) Stop() {
+	it.stop()
+	it.mc.Stop()
+}
@@ -0,0 +1,151 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestMessageIterator(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "messages",
+		Subjects: []string{"messages"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "messages", jetstream.ConsumerConfig{
+		Durable:       "messageIterator",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Second,
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	publish := func(t *testing.T, data ...string) {
+		t.Helper()
+		for _, d := range data {
+			if err := conn.Publish("messages", []byte(d)); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+	}
+
+	t.Run("values are returned, and acked or nacked by the caller", func(t *testing.T) {
+		// Arrange.
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		it, err := NewMessageIterator[StreamMessage](ctx, consumer, WithPullMaxMessages[StreamMessage](2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer it.Stop()
+		publish(t, `{"index":0}`, `{ _this_is_not_json_ }`, `{"index":1}`)
+
+		// Act.
+		var received []int
+		var failed bool
+		for msg, done := range it.All() {
+			received = append(received, msg.Index)
+			var err error
+			if msg.Index == 1 && !failed {
+				failed = true
+				err = errors.New("failed")
+			}
+			if ackErr := done(err); ackErr != nil {
+				t.Errorf("unexpected error acking: %v", ackErr)
+			}
+			if len(received) == 3 {
+				break
+			}
+		}
+
+		// Assert.
+		expected := []int{0, 1, 1}
+		if len(received) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, received)
+		}
+		for i := range expected {
+			if received[i] != expected[i] {
+				t.Errorf("expected %v, got %v", expected, received)
+				break
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := WaitForDrain(ctx, consumer); err != nil {
+			t.Errorf("expected no pending acks: %v", err)
+		}
+	})
+	t.Run("iteration stops when the context is cancelled", func(t *testing.T) {
+		// Arrange.
+		ctx, cancel := context.WithCancel(ctx)
+		it, err := NewMessageIterator[StreamMessage](ctx, consumer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer it.Stop()
+
+		// Act.
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, _, err = it.Next()
+
+		// Assert.
+		if !errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			t.Errorf("expected ErrMsgIteratorClosed, got %v", err)
+		}
+	})
+	t.Run("when All is ended by cancelling the context, Err returns the context's error", func(t *testing.T) {
+		// Arrange.
+		ctx, cancel := context.WithCancel(ctx)
+		it, err := NewMessageIterator[StreamMessage](ctx, consumer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer it.Stop()
+
+		// Act.
+		time.AfterFunc(50*time.Millisecond, cancel)
+		for range it.All() {
+			t.Error("unexpected message")
+		}
+
+		// Assert.
+		if err := it.Err(); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+	t.Run("when All is ended by calling Stop, Err returns nil", func(t *testing.T) {
+		// Arrange.
+		it, err := NewMessageIterator[StreamMessage](ctx, consumer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Act.
+		time.AfterFunc(50*time.Millisecond, it.Stop)
+		for range it.All() {
+			t.Error("unexpected message")
+		}
+
+		// Assert.
+		if err := it.Err(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package natsjson
+
+import "time"
+
+// MetricLabels identify the consumer that a measurement was taken from.
+type MetricLabels struct {
+	Stream   string
+	Consumer string
+}
+
+// BatchMetrics records measurements taken by the batch processor.
+//
+// The natsjsonprom package implements it with Prometheus. Other
+// implementations adapt the calls to OpenTelemetry etc.
+type BatchMetrics interface {
+	// ObserveFetch records the time taken to fetch and decode a batch.
+	ObserveFetch(labels MetricLabels, d time.Duration)
+	// ObserveBatchSize records the number of messages passed to the processor.
+	ObserveBatchSize(labels MetricLabels, size int)
+	// ObserveProcess records the time taken by the processor function.
+	ObserveProcess(labels MetricLabels, d time.Duration)
+	// AddProcessed records the number of messages that were processed successfully.
+	AddProcessed(labels MetricLabels, n int)
+	// AddFailed records the number of messages that the processor returned an error for.
+	AddFailed(labels MetricLabels, n int)
+}
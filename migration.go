@@ -0,0 +1,69 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SchemaVersionHeader is the message header that contains the version of the
+// message's schema.
+const SchemaVersionHeader = "Natsjson-Schema-Version"
+
+var ErrUnknownSchemaVersion = errors.New("unknown schema version")
+
+// Migration upgrades a message encoded with a previous schema version to the
+// current type.
+type Migration[T any] func(raw []byte) (value T, err error)
+
+// WithPublisherSchemaVersion sets the schema version header on each message.
+func WithPublisherSchemaVersion[T any](version int) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.schemaVersion = strconv.Itoa(version)
+	}
+}
+
+// WithMigrations upgrades messages with a schema version header that doesn't
+// match the current version using the migration registered for the message's
+// version. Messages without the header are decoded as the current version.
+//
+// Messages with a version that doesn't have a migration are passed to the
+// ErrorHandler with ErrUnknownSchemaVersion, and acked, in the same way as
+// other messages that can't be decoded.
+func WithMigrations[T any](current int, migrations map[int]Migration[T]) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.currentSchemaVersion = current
+		bp.migrations = migrations
+	}
+}
+
+// migrate decodes the data using a migration if the message was encoded with a
+// previous schema version. If the message is at the current version, migrated
+// is false, and the data should be decoded as usual.
+func (d *decoder[T]) migrate(header nats.Header, data []byte) (value T, migrated bool, err error) {
+	if d.migrations == nil || header == nil {
+		return value, false, nil
+	}
+	v := header.Get(SchemaVersionHeader)
+	if v == "" {
+		return value, false, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return value, true, fmt.Errorf("%w: %q", ErrUnknownSchemaVersion, v)
+	}
+	if version == d.currentSchemaVersion {
+		return value, false, nil
+	}
+	migration, ok := d.migrations[version]
+	if !ok {
+		return value, true, fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, version)
+	}
+	value, err = migration(data)
+	if err != nil {
+		return value, true, fmt.Errorf("failed to migrate from schema version %d: %w", version, err)
+	}
+	return value, true, nil
+}
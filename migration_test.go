@@ -0,0 +1,97 @@
+package natsjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type batchMessageV1 struct {
+	Number int
+}
+
+func TestMigrations(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "migrations",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "migrations", jetstream.ConsumerConfig{
+		Durable:       "migrationsProcessor",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	v1 := NewPublisher[batchMessageV1](conn, WithPublisherSchemaVersion[batchMessageV1](1))
+	if err = v1.Publish("migrations.messages", batchMessageV1{Number: 1}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+	v2 := NewPublisher[BatchMessage](conn, WithPublisherSchemaVersion[BatchMessage](2))
+	if err = v2.Publish("migrations.messages", BatchMessage{Index: 2}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+	v3 := NewPublisher[BatchMessage](conn, WithPublisherSchemaVersion[BatchMessage](3))
+	if err = v3.Publish("migrations.messages", BatchMessage{Index: 3}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+	unversioned := NewPublisher[BatchMessage](conn)
+	if err = unversioned.Publish("migrations.messages", BatchMessage{Index: 4}); err != nil {
+		t.Fatalf("unexpected failure sending test message: %v", err)
+	}
+
+	// Act.
+	var actual []BatchMessage
+	p := func(ctx context.Context, msgs []BatchMessage) []error {
+		actual = append(actual, msgs...)
+		return make([]error, len(msgs))
+	}
+	migrations := map[int]Migration[BatchMessage]{
+		1: func(raw []byte) (m BatchMessage, err error) {
+			var old batchMessageV1
+			if err = json.Unmarshal(raw, &old); err != nil {
+				return m, err
+			}
+			return BatchMessage{Index: old.Number}, nil
+		},
+	}
+	bp := NewBatchProcessor[BatchMessage](consumer, 10, p,
+		WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)),
+		WithMigrations[BatchMessage](2, migrations))
+	var errorHandlerCalls int
+	bp.ErrorHandler = func(msg BatchMessage, err error) {
+		if !errors.Is(err, ErrUnknownSchemaVersion) {
+			t.Errorf("expected ErrUnknownSchemaVersion, got %v", err)
+		}
+		errorHandlerCalls++
+	}
+	if err := bp.Process(ctx); err != nil {
+		t.Fatalf("unexpected error processing batch: %v", err)
+	}
+
+	// Assert.
+	expected := []BatchMessage{{Index: 1}, {Index: 2}, {Index: 4}}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error(diff)
+	}
+	if errorHandlerCalls != 1 {
+		t.Errorf("expected 1 error handler call, got %d", errorHandlerCalls)
+	}
+}
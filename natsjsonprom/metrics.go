@@ -0,0 +1,144 @@
+// Package natsjsonprom records the metrics of a natsjson.BatchProcessor with
+// Prometheus.
+//
+// It's a separate package, so that programs that don't use Prometheus don't
+// import it.
+package natsjsonprom
+
+import (
+	"errors"
+	"time"
+
+	"github.com/a-h/natsjson"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "natsjson"
+
+var labelNames = []string{"stream", "consumer"}
+
+// BatchMetrics implements natsjson.BatchMetrics with Prometheus counters and
+// histograms, labelled with the stream and consumer that each measurement was
+// taken from.
+type BatchMetrics struct {
+	processed     *prometheus.CounterVec
+	acked         *prometheus.CounterVec
+	failed        *prometheus.CounterVec
+	batchDuration *prometheus.HistogramVec
+	fetchDuration *prometheus.HistogramVec
+	batchSize     *prometheus.HistogramVec
+}
+
+var _ natsjson.BatchMetrics = (*BatchMetrics)(nil)
+
+// NewBatchMetrics creates the metrics, and registers them with reg. If the
+// metrics are already registered, e.g. by another batch processor, the
+// registered metrics are used, so that processors can share a registry.
+func NewBatchMetrics(reg prometheus.Registerer) (m *BatchMetrics, err error) {
+	m = &BatchMetrics{
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_processed_total",
+			Help:      "The number of messages passed to the processor.",
+		}, labelNames),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_acked_total",
+			Help:      "The number of messages that were processed successfully, and acked.",
+		}, labelNames),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_failed_total",
+			Help:      "The number of messages that the processor returned an error for.",
+		}, labelNames),
+		batchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_duration_seconds",
+			Help:      "The time taken by the processor to process a batch.",
+			Buckets:   prometheus.DefBuckets,
+		}, labelNames),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_fetch_duration_seconds",
+			Help:      "The time taken to fetch and decode a batch.",
+			Buckets:   prometheus.DefBuckets,
+		}, labelNames),
+		batchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_size",
+			Help:      "The number of messages passed to the processor in a batch.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		}, labelNames),
+	}
+	if m.processed, err = register(reg, m.processed); err != nil {
+		return nil, err
+	}
+	if m.acked, err = register(reg, m.acked); err != nil {
+		return nil, err
+	}
+	if m.failed, err = register(reg, m.failed); err != nil {
+		return nil, err
+	}
+	if m.batchDuration, err = register(reg, m.batchDuration); err != nil {
+		return nil, err
+	}
+	if m.fetchDuration, err = register(reg, m.fetchDuration); err != nil {
+		return nil, err
+	}
+	if m.batchSize, err = register(reg, m.batchSize); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers the collector, or returns the collector that's already
+// registered with the same description.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	if err == nil {
+		return c, nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// WithMetrics records the batch processor's metrics with Prometheus, using the
+// metrics created by NewBatchMetrics. Like prometheus.MustRegister, it panics
+// if the metrics can't be registered, e.g. because a different collector with
+// the same name is already registered.
+func WithMetrics[T any](reg prometheus.Registerer) natsjson.BatchProcessorOpt[T] {
+	m, err := NewBatchMetrics(reg)
+	if err != nil {
+		panic(err)
+	}
+	return natsjson.WithMetrics[T](m)
+}
+
+func (m *BatchMetrics) ObserveFetch(labels natsjson.MetricLabels, d time.Duration) {
+	m.fetchDuration.WithLabelValues(labels.Stream, labels.Consumer).Observe(d.Seconds())
+}
+
+func (m *BatchMetrics) ObserveBatchSize(labels natsjson.MetricLabels, size int) {
+	m.batchSize.WithLabelValues(labels.Stream, labels.Consumer).Observe(float64(size))
+}
+
+func (m *BatchMetrics) ObserveProcess(labels natsjson.MetricLabels, d time.Duration) {
+	m.batchDuration.WithLabelValues(labels.Stream, labels.Consumer).Observe(d.Seconds())
+}
+
+// AddProcessed counts the messages as processed and acked.
+func (m *BatchMetrics) AddProcessed(labels natsjson.MetricLabels, n int) {
+	m.processed.WithLabelValues(labels.Stream, labels.Consumer).Add(float64(n))
+	m.acked.WithLabelValues(labels.Stream, labels.Consumer).Add(float64(n))
+}
+
+// AddFailed counts the messages as processed and failed.
+func (m *BatchMetrics) AddFailed(labels natsjson.MetricLabels, n int) {
+	m.processed.WithLabelValues(labels.Stream, labels.Consumer).Add(float64(n))
+	m.failed.WithLabelValues(labels.Stream, labels.Consumer).Add(float64(n))
+}
@@ -0,0 +1,149 @@
+package natsjsonprom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson"
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type message struct {
+	Index int `json:"index"`
+}
+
+func TestWithMetrics(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	if _, err := natsjson.EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "metrics",
+		Subjects: []string{"metrics"},
+		Storage:  jetstream.MemoryStorage,
+	}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := js.CreateOrUpdateConsumer(ctx, "metrics", jetstream.ConsumerConfig{
+		Durable:       "metrics",
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	if err := natsjson.NewPublisher[message](conn).Publish("metrics", message{Index: 0}, message{Index: 1}, message{Index: 2}); err != nil {
+		t.Fatalf("failed to publish messages: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	p := func(ctx context.Context, msgs []message) []error {
+		errs := make([]error, len(msgs))
+		errs[1] = errors.New("failed")
+		return errs
+	}
+
+	// Act.
+	bp := natsjson.NewBatchProcessor[message](consumer, 10, p, natsjson.WithFetchOpts[message](jetstream.FetchMaxWait(100*time.Millisecond)), WithMetrics[message](reg))
+	if err := bp.Process(ctx); err != nil {
+		t.Fatalf("unexpected error processing batch: %v", err)
+	}
+
+	// Assert.
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	metrics := map[string]*dto.Metric{}
+	for _, mf := range families {
+		if len(mf.GetMetric()) != 1 {
+			t.Fatalf("expected 1 metric for %s, got %d", mf.GetName(), len(mf.GetMetric()))
+		}
+		metrics[mf.GetName()] = mf.GetMetric()[0]
+	}
+	for name, expected := range map[string]float64{
+		"natsjson_messages_processed_total": 3,
+		"natsjson_messages_acked_total":     2,
+		"natsjson_messages_failed_total":    1,
+	} {
+		m, ok := metrics[name]
+		if !ok {
+			t.Errorf("expected metric %s to be registered", name)
+			continue
+		}
+		if actual := m.GetCounter().GetValue(); actual != expected {
+			t.Errorf("expected %s to be %v, got %v", name, expected, actual)
+		}
+		labels := map[string]string{}
+		for _, lp := range m.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["stream"] != "metrics" || labels["consumer"] != "metrics" {
+			t.Errorf("expected %s to be labelled with the stream and consumer, got %v", name, labels)
+		}
+	}
+	for name, expected := range map[string]uint64{
+		"natsjson_batch_duration_seconds":       1,
+		"natsjson_batch_fetch_duration_seconds": 1,
+		"natsjson_batch_size":                   1,
+	} {
+		m, ok := metrics[name]
+		if !ok {
+			t.Errorf("expected metric %s to be registered", name)
+			continue
+		}
+		if actual := m.GetHistogram().GetSampleCount(); actual != expected {
+			t.Errorf("expected %s to have %d samples, got %d", name, expected, actual)
+		}
+	}
+	if sum := metrics["natsjson_batch_size"].GetHistogram().GetSampleSum(); sum != 3 {
+		t.Errorf("expected a batch size of 3, got %v", sum)
+	}
+}
+
+func TestNewBatchMetrics(t *testing.T) {
+	t.Run("processors can share a registry", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		a, err := NewBatchMetrics(reg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := NewBatchMetrics(reg)
+		if err != nil {
+			t.Fatalf("unexpected error registering the metrics again: %v", err)
+		}
+		labels := natsjson.MetricLabels{Stream: "stream", Consumer: "consumer"}
+		a.AddProcessed(labels, 1)
+		b.AddProcessed(labels, 2)
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+		for _, mf := range families {
+			if mf.GetName() != "natsjson_messages_acked_total" {
+				continue
+			}
+			if actual := mf.GetMetric()[0].GetCounter().GetValue(); actual != 3 {
+				t.Errorf("expected 3, got %v", actual)
+			}
+			return
+		}
+		t.Error("expected natsjson_messages_acked_total to be registered")
+	})
+	t.Run("conflicting metrics return an error", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "natsjson_messages_processed_total",
+			Help: "A different metric with the same name.",
+		}))
+		if _, err := NewBatchMetrics(reg); err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package natsjsontest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	natsclient "github.com/nats-io/nats.go"
+)
+
+// Cluster is a cluster of NATS servers started by NewInProcessNATSCluster.
+type Cluster struct {
+	// Conns contains a connection to each server in the cluster. Each
+	// connection fails over to the other servers if its server is shut down.
+	Conns     []*natsclient.Conn
+	servers   []*natsserver.Server
+	storeDirs []string
+}
+
+// NewInProcessNATSCluster starts a cluster of NATS servers that listen on the
+// loopback interface, and returns a connection to each server. If JetStream is
+// enabled, NewInProcessNATSCluster waits for the cluster to elect a JetStream
+// leader, so that replicated streams and buckets can be created straight away.
+// If an error is returned, the cluster has already been shut down.
+//
+// The WithJetStream and WithLogger options are supported. Each server uses a
+// temporary store directory, so WithStoreDir is ignored.
+func NewInProcessNATSCluster(size int, opts ...ServerOpt) (c *Cluster, err error) {
+	if size < 2 {
+		return nil, errors.New("a cluster requires at least 2 servers")
+	}
+	o := serverOptions{
+		jetStream: true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c = &Cluster{}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	// Allocate the route ports up front, since each server must be configured
+	// with the routes to the others.
+	routes := make([]*url.URL, size)
+	ports := make([]int, size)
+	for i := range ports {
+		if ports[i], err = freePort(); err != nil {
+			return nil, fmt.Errorf("failed to allocate route port: %w", err)
+		}
+		routes[i] = &url.URL{Scheme: "nats", Host: fmt.Sprintf("127.0.0.1:%d", ports[i])}
+	}
+
+	for i := 0; i < size; i++ {
+		var storeDir string
+		if o.jetStream {
+			if storeDir, err = os.MkdirTemp("", "nats_test"); err != nil {
+				return nil, fmt.Errorf("failed to create temp directory for NATS storage: %w", err)
+			}
+			c.storeDirs = append(c.storeDirs, storeDir)
+		}
+		server, err := natsserver.NewServer(&natsserver.Options{
+			ServerName: fmt.Sprintf("natsjsontest-%d", i),
+			// Routing doesn't start until the client listener is ready, so
+			// listen on a random loopback port, rather than using DontListen.
+			Host:      "127.0.0.1",
+			Port:      natsserver.RANDOM_PORT,
+			JetStream: o.jetStream,
+			StoreDir:  storeDir,
+			Cluster: natsserver.ClusterOpts{
+				Name: "natsjsontest",
+				Host: "127.0.0.1",
+				Port: ports[i],
+			},
+			Routes: routes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create NATS server %d: %w", i, err)
+		}
+		if o.log != nil {
+			server.SetLoggerV2(o.log, false, false, false)
+		}
+		server.Start()
+		c.servers = append(c.servers, server)
+	}
+
+	for i, server := range c.servers {
+		if !server.ReadyForConnections(time.Second * 5) {
+			return nil, fmt.Errorf("failed to start server %d after 5 seconds", i)
+		}
+	}
+	if err = waitForCluster(c.servers, o.jetStream, time.Second*10); err != nil {
+		return nil, err
+	}
+
+	for i := range c.servers {
+		// List the server first, followed by the others to fail over to.
+		urls := []string{c.servers[i].ClientURL()}
+		for j, server := range c.servers {
+			if j != i {
+				urls = append(urls, server.ClientURL())
+			}
+		}
+		conn, err := natsclient.Connect(strings.Join(urls, ","), natsclient.DontRandomize(), natsclient.MaxReconnects(-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to server %d: %w", i, err)
+		}
+		c.Conns = append(c.Conns, conn)
+	}
+	return c, nil
+}
+
+// ShutdownServer shuts down the server at index i, so that failover can be
+// tested. The connection to the server reconnects to another server.
+func (c *Cluster) ShutdownServer(i int) {
+	c.servers[i].Shutdown()
+}
+
+// Close closes the connections, shuts down the servers and removes temporary
+// storage. Close doesn't wait more than 10 seconds for the servers to shut down.
+func (c *Cluster) Close() {
+	for _, conn := range c.Conns {
+		conn.Close()
+	}
+	var wg sync.WaitGroup
+	for _, server := range c.servers {
+		wg.Add(1)
+		go func(server *natsserver.Server) {
+			defer wg.Done()
+			server.Shutdown()
+		}(server)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 10):
+	}
+	for _, dir := range c.storeDirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// waitForCluster waits for each server to be routed to the others, and if
+// JetStream is enabled, for a JetStream leader to be elected that can see every
+// server.
+func waitForCluster(servers []*natsserver.Server, jetStream bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if clusterReady(servers, jetStream) {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	return fmt.Errorf("cluster not ready after %v", timeout)
+}
+
+func clusterReady(servers []*natsserver.Server, jetStream bool) bool {
+	for _, server := range servers {
+		if server.NumRoutes() < len(servers)-1 {
+			return false
+		}
+	}
+	if !jetStream {
+		return true
+	}
+	// Replicated streams can only be placed once the leader has received
+	// stats from every server.
+	for _, server := range servers {
+		if server.JetStreamIsLeader() {
+			return len(server.JetStreamClusterPeers()) == len(servers)
+		}
+	}
+	return false
+}
+
+func freePort() (port int, err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,79 @@
+package natsjsontest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestNewInProcessNATSCluster(t *testing.T) {
+	t.Run("a cluster requires at least 2 servers", func(t *testing.T) {
+		if _, err := NewInProcessNATSCluster(1); err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+	t.Run("replicated buckets can be read after a server is shut down", func(t *testing.T) {
+		// Arrange.
+		c, err := NewInProcessNATSCluster(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if len(c.Conns) != 3 {
+			t.Fatalf("expected 3 connections, got %d", len(c.Conns))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+		defer cancel()
+		js0, err := jetstream.New(c.Conns[0])
+		if err != nil {
+			t.Fatalf("failed to create jetstream: %v", err)
+		}
+		kv, err := js0.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:   "replicated",
+			Replicas: 3,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+		if _, err := kv.Put(ctx, "key", []byte("value")); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+
+		// Act.
+		c.ShutdownServer(0)
+
+		// Assert.
+		js2, err := jetstream.New(c.Conns[2])
+		if err != nil {
+			t.Fatalf("failed to create jetstream: %v", err)
+		}
+		var entry jetstream.KeyValueEntry
+		for entry == nil && ctx.Err() == nil {
+			// Requests aren't answered while the cluster elects new leaders,
+			// so retry each attempt with a short timeout.
+			entry = tryGet(ctx, js2, "replicated", "key")
+		}
+		if entry == nil {
+			t.Fatal("timed out getting the value after failover")
+		}
+		if string(entry.Value()) != "value" {
+			t.Errorf("expected %q, got %q", "value", entry.Value())
+		}
+	})
+}
+
+func tryGet(ctx context.Context, js jetstream.JetStream, bucket, key string) jetstream.KeyValueEntry {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return nil
+	}
+	entry, err := kv.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
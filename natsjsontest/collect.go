@@ -0,0 +1,37 @@
+package natsjsontest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	natsclient "github.com/nats-io/nats.go"
+)
+
+// Collect subscribes to the subject, and decodes the next n messages into a
+// slice. If the context is done before n messages are received, the messages
+// received so far are returned along with the context's error.
+//
+// Messages published before Collect subscribes aren't received, so call it in
+// a goroutine before publishing, or publish from the same connection once the
+// subscription has been made, e.g. when conn.NumSubscriptions increases.
+func Collect[T any](ctx context.Context, conn *natsclient.Conn, subject string, n int) (values []T, err error) {
+	sub, err := conn.SubscribeSync(subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+	defer sub.Unsubscribe()
+	values = make([]T, 0, n)
+	for len(values) < n {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return values, err
+		}
+		var v T
+		if err = json.Unmarshal(msg.Data, &v); err != nil {
+			return values, fmt.Errorf("failed to unmarshal message %d: %w", len(values)+1, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,59 @@
+package natsjsontest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	conn, _, cleanup, err := NewInProcessNATSServer(WithJetStream(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	collect := func(ctx context.Context, n int) <-chan []int {
+		result := make(chan []int, 1)
+		subs := conn.NumSubscriptions()
+		go func() {
+			values, err := Collect[int](ctx, conn, "numbers", n)
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			result <- values
+		}()
+		for conn.NumSubscriptions() == subs {
+			time.Sleep(time.Millisecond)
+		}
+		return result
+	}
+
+	t.Run("the next n messages are returned", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result := collect(ctx, 2)
+		for _, data := range []string{"1", "2", "3"} {
+			if err := conn.Publish("numbers", []byte(data)); err != nil {
+				t.Fatalf("failed to publish: %v", err)
+			}
+		}
+		values := <-result
+		if len(values) != 2 || values[0] != 1 || values[1] != 2 {
+			t.Errorf("expected [1 2], got %v", values)
+		}
+	})
+	t.Run("the messages received before the deadline are returned", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		result := collect(ctx, 2)
+		if err := conn.Publish("numbers", []byte("1")); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+		values := <-result
+		if len(values) != 1 || values[0] != 1 {
+			t.Errorf("expected [1], got %v", values)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+// Package natsjsontest provides helpers for testing code that uses natsjson.
+package natsjsontest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type ServerOpt func(*serverOptions)
+
+type serverOptions struct {
+	jetStream bool
+	storeDir  string
+	log       natsserver.Logger
+	token     string
+	user      string
+	password  string
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+}
+
+// WithJetStream enables or disables JetStream. JetStream is enabled by default.
+// If JetStream is disabled, the returned JetStream client is nil.
+func WithJetStream(enabled bool) ServerOpt {
+	return func(o *serverOptions) {
+		o.jetStream = enabled
+	}
+}
+
+// WithStoreDir sets the JetStream storage directory. By default, a temporary
+// directory is created, and removed when the server is cleaned up. A directory
+// provided with this option is not removed.
+func WithStoreDir(dir string) ServerOpt {
+	return func(o *serverOptions) {
+		o.storeDir = dir
+	}
+}
+
+// WithLogger sets the server's logger. By default, the server doesn't log.
+func WithLogger(log natsserver.Logger) ServerOpt {
+	return func(o *serverOptions) {
+		o.log = log
+	}
+}
+
+// WithToken requires clients to authenticate with the token. The returned
+// connection is authenticated with the token.
+func WithToken(token string) ServerOpt {
+	return func(o *serverOptions) {
+		o.token = token
+	}
+}
+
+// WithUserPassword requires clients to authenticate with the user and
+// password. The returned connection is authenticated with the user and
+// password.
+func WithUserPassword(user, password string) ServerOpt {
+	return func(o *serverOptions) {
+		o.user = user
+		o.password = password
+	}
+}
+
+// WithTLS requires clients to connect using TLS. The server uses the server
+// configuration, which must contain a certificate, and the returned connection
+// uses the client configuration, which must trust the server's certificate.
+func WithTLS(server, client *tls.Config) ServerOpt {
+	return func(o *serverOptions) {
+		o.serverTLS = server
+		o.clientTLS = client
+	}
+}
+
+// NewInProcessNATSServer starts a NATS server that doesn't listen on a TCP
+// socket, and returns a connection to it. The cleanup function shuts down the
+// server and removes any temporary storage. If an error is returned, the
+// server has already been shut down.
+func NewInProcessNATSServer(opts ...ServerOpt) (conn *natsclient.Conn, js jetstream.JetStream, cleanup func(), err error) {
+	o := serverOptions{
+		jetStream: true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cleanup = func() {}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+	storeDir := o.storeDir
+	if o.jetStream && storeDir == "" {
+		storeDir, err = os.MkdirTemp("", "nats_test")
+		if err != nil {
+			err = fmt.Errorf("failed to create temp directory for NATS storage: %w", err)
+			return
+		}
+		cleanup = func() {
+			os.RemoveAll(storeDir)
+		}
+	}
+	server, err := natsserver.NewServer(&natsserver.Options{
+		DontListen:    true, // Don't make a TCP socket.
+		JetStream:     o.jetStream,
+		StoreDir:      storeDir,
+		Authorization: o.token,
+		Username:      o.user,
+		Password:      o.password,
+		TLS:           o.serverTLS != nil,
+		TLSConfig:     o.serverTLS,
+		TLSTimeout:    5,
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to create NATS server: %w", err)
+		return
+	}
+	if o.log != nil {
+		server.SetLoggerV2(o.log, false, false, false)
+	}
+	server.Start()
+	removeStoreDir := cleanup
+	cleanup = func() {
+		server.Shutdown()
+		removeStoreDir()
+	}
+
+	if !server.ReadyForConnections(time.Second * 5) {
+		err = errors.New("failed to start server after 5 seconds")
+		return
+	}
+
+	// Create a connection.
+	clientOptions := []natsclient.Option{natsclient.InProcessServer(server)}
+	if o.token != "" {
+		clientOptions = append(clientOptions, natsclient.Token(o.token))
+	}
+	if o.user != "" {
+		clientOptions = append(clientOptions, natsclient.UserInfo(o.user, o.password))
+	}
+	if o.clientTLS != nil {
+		clientOptions = append(clientOptions, natsclient.Secure(o.clientTLS))
+	}
+	conn, err = natsclient.Connect("", clientOptions...)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to server: %w", err)
+		return
+	}
+	if !o.jetStream {
+		return
+	}
+
+	// Create a JetStream client.
+	js, err = jetstream.New(conn)
+	if err != nil {
+		err = fmt.Errorf("failed to create jetstream: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,136 @@
+package natsjsontest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewInProcessNATSServer(t *testing.T) {
+	t.Run("JetStream is enabled by default", func(t *testing.T) {
+		conn, js, cleanup, err := NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if !conn.IsConnected() {
+			t.Error("expected the connection to be connected")
+		}
+		if js == nil {
+			t.Fatal("expected a JetStream client")
+		}
+		if _, err := js.AccountInfo(context.Background()); err != nil {
+			t.Errorf("unexpected error getting account info: %v", err)
+		}
+	})
+	t.Run("JetStream can be disabled", func(t *testing.T) {
+		conn, js, cleanup, err := NewInProcessNATSServer(WithJetStream(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if js != nil {
+			t.Error("expected no JetStream client")
+		}
+		if err := conn.FlushTimeout(time.Second); err != nil {
+			t.Errorf("unexpected error flushing connection: %v", err)
+		}
+	})
+	t.Run("a provided store directory is not removed", func(t *testing.T) {
+		dir := t.TempDir()
+		_, _, cleanup, err := NewInProcessNATSServer(WithStoreDir(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cleanup()
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("expected store directory to exist: %v", err)
+		}
+	})
+	t.Run("with WithToken, the connection is authenticated", func(t *testing.T) {
+		conn, _, cleanup, err := NewInProcessNATSServer(WithJetStream(false), WithToken("s3cr3t"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if err := conn.FlushTimeout(time.Second); err != nil {
+			t.Errorf("unexpected error flushing connection: %v", err)
+		}
+	})
+	t.Run("with WithUserPassword, the connection is authenticated", func(t *testing.T) {
+		conn, js, cleanup, err := NewInProcessNATSServer(WithUserPassword("alice", "s3cr3t"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if !conn.IsConnected() {
+			t.Error("expected the connection to be connected")
+		}
+		if _, err := js.AccountInfo(context.Background()); err != nil {
+			t.Errorf("unexpected error getting account info: %v", err)
+		}
+	})
+	t.Run("with WithTLS, the connection uses TLS", func(t *testing.T) {
+		serverTLS, clientTLS := newTestTLSConfig(t)
+		conn, _, cleanup, err := NewInProcessNATSServer(WithJetStream(false), WithTLS(serverTLS, clientTLS))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if state, err := conn.TLSConnectionState(); err != nil || !state.HandshakeComplete {
+			t.Errorf("expected a TLS connection, got %v", err)
+		}
+	})
+}
+
+// newTestTLSConfig creates a self-signed certificate, and returns a server
+// configuration that uses it, and a client configuration that trusts it.
+func newTestTLSConfig(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	client = &tls.Config{
+		RootCAs:    pool,
+		ServerName: "localhost",
+		MinVersion: tls.VersionTLS12,
+	}
+	return server, client
+}
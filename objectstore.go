@@ -0,0 +1,125 @@
+package natsjson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nats-io/nats.go"
+)
+
+type ObjectStoreOpt[T any] func(*ObjectStore[T])
+
+// WithObjectStoreCodec sets the functions used to encode and decode values,
+// instead of streaming them with encoding/json. The encoded value is held in
+// memory.
+func WithObjectStoreCodec[T any](marshal func(v T) ([]byte, error), unmarshal func(data []byte, v *T) error) ObjectStoreOpt[T] {
+	return func(store *ObjectStore[T]) {
+		store.codec = &codec[T]{marshal: marshal, unmarshal: unmarshal}
+	}
+}
+
+// NewObjectStore creates a typed wrapper around a NATS object store, for
+// values that are too large to fit in a KV bucket. Values are streamed to and
+// from the object store with encoding/json, so that they aren't held in memory
+// as well as decoded, unless WithObjectStoreCodec is set.
+//
+// The jetstream package doesn't yet provide an object store, so this wraps the
+// object store from the nats package, e.g. created with:
+//
+//	js, _ := nc.JetStream()
+//	os, _ := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "documents"})
+func NewObjectStore[T any](os nats.ObjectStore, opts ...ObjectStoreOpt[T]) (store *ObjectStore[T]) {
+	store = &ObjectStore[T]{
+		os: os,
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store
+}
+
+type ObjectStore[T any] struct {
+	os    nats.ObjectStore
+	codec *codec[T]
+}
+
+// ObjectStore returns the underlying object store.
+func (store *ObjectStore[T]) ObjectStore() nats.ObjectStore {
+	return store.os
+}
+
+// Put stores the JSON encoded value in the object store under the given name.
+func (store *ObjectStore[T]) Put(ctx context.Context, name string, value T) (err error) {
+	meta := &nats.ObjectMeta{Name: name}
+	if store.codec != nil {
+		data, err := store.codec.marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %w", err)
+		}
+		if _, err = store.os.Put(meta, bytes.NewReader(data), nats.Context(ctx)); err != nil {
+			return fmt.Errorf("failed to put object: %w", err)
+		}
+		return nil
+	}
+	pr, pw := io.Pipe()
+	encoded := make(chan error, 1)
+	go func() {
+		err := json.NewEncoder(pw).Encode(value)
+		pw.CloseWithError(err)
+		encoded <- err
+	}()
+	_, err = store.os.Put(meta, pr, nats.Context(ctx))
+	// Unblock the encoder if Put returned before reading all of the value.
+	pr.Close()
+	if encodeErr := <-encoded; encodeErr != nil && !errors.Is(encodeErr, io.ErrClosedPipe) {
+		return fmt.Errorf("failed to encode object: %w", encodeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	return nil
+}
+
+// Get reads and decodes the named object. If the object doesn't exist, ok is false.
+func (store *ObjectStore[T]) Get(ctx context.Context, name string) (value T, ok bool, err error) {
+	result, err := store.os.Get(name, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			return value, false, nil
+		}
+		return value, false, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer result.Close()
+	if store.codec != nil {
+		data, err := io.ReadAll(result)
+		if err != nil {
+			return value, false, fmt.Errorf("failed to read object: %w", err)
+		}
+		if err = store.codec.unmarshal(data, &value); err != nil {
+			return value, false, fmt.Errorf("failed to decode object: %w", err)
+		}
+		return value, true, nil
+	}
+	dec := json.NewDecoder(result)
+	if err = dec.Decode(&value); err != nil {
+		return value, false, fmt.Errorf("failed to decode object: %w", err)
+	}
+	// Read to the end, so that the object's digest is verified.
+	if _, err = io.Copy(io.Discard, result); err != nil {
+		return value, false, fmt.Errorf("failed to read object: %w", err)
+	}
+	return value, true, nil
+}
+
+// Delete the named object. The underlying object store's Delete doesn't accept
+// a context, so the context is only checked before the object is deleted.
+func (store *ObjectStore[T]) Delete(ctx context.Context, name string) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return store.os.Delete(name)
+}
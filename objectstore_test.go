@@ -0,0 +1,143 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go"
+)
+
+type Document struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func TestObjectStore(t *testing.T) {
+	// Arrange.
+	conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected failure creating JetStream context: %v", err)
+	}
+	os, err := js.CreateObjectStore(&nats.ObjectStoreConfig{
+		Bucket: "test_objects",
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure creating object store: %v", err)
+	}
+
+	store := NewObjectStore[Document](os)
+
+	t.Run("getting a non-existent object returns ok=false", func(t *testing.T) {
+		_, ok, err := store.Get(ctx, "non-existent")
+		if err != nil {
+			t.Errorf("unexpected error getting object: %v", err)
+		}
+		if ok {
+			t.Error("expected ok=false, got ok=true")
+		}
+	})
+	t.Run("large objects can be stored and retrieved", func(t *testing.T) {
+		expected := Document{
+			Title: "large",
+			// Larger than the default KV max value size of 1MB.
+			Body: strings.Repeat("0123456789", 200_000),
+		}
+		if err := store.Put(ctx, "large", expected); err != nil {
+			t.Fatalf("unexpected error putting object: %v", err)
+		}
+		actual, ok, err := store.Get(ctx, "large")
+		if err != nil {
+			t.Fatalf("unexpected error getting object: %v", err)
+		}
+		if !ok {
+			t.Error("expected ok=true, got ok=false")
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("deleted objects are not found", func(t *testing.T) {
+		if err := store.Delete(ctx, "large"); err != nil {
+			t.Fatalf("unexpected error deleting object: %v", err)
+		}
+		_, ok, err := store.Get(ctx, "large")
+		if err != nil {
+			t.Errorf("unexpected error getting object: %v", err)
+		}
+		if ok {
+			t.Error("expected ok=false, got ok=true")
+		}
+	})
+	t.Run("Delete returns the context's error if it's cancelled", func(t *testing.T) {
+		if err := store.Put(ctx, "cancelled", Document{Title: "cancelled"}); err != nil {
+			t.Fatalf("unexpected error putting object: %v", err)
+		}
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := store.Delete(cancelled, "cancelled"); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if _, ok, err := store.Get(ctx, "cancelled"); err != nil || !ok {
+			t.Errorf("expected the object not to be deleted, got ok=%v, err=%v", ok, err)
+		}
+	})
+	t.Run("values that can't be encoded return an error", func(t *testing.T) {
+		store := NewObjectStore[any](os)
+		if err := store.Put(ctx, "invalid", make(chan int)); err == nil {
+			t.Error("expected an error, got nil")
+		}
+		if _, ok, err := store.Get(ctx, "invalid"); err != nil || ok {
+			t.Errorf("expected the object not to be stored, got ok=%v, err=%v", ok, err)
+		}
+	})
+	t.Run("WithObjectStoreCodec sets the functions used to encode and decode values", func(t *testing.T) {
+		// Arrange.
+		var marshalCalls, unmarshalCalls int
+		marshal := func(v Document) ([]byte, error) {
+			marshalCalls++
+			return []byte(v.Title + "\n" + v.Body), nil
+		}
+		unmarshal := func(data []byte, v *Document) error {
+			unmarshalCalls++
+			v.Title, v.Body, _ = strings.Cut(string(data), "\n")
+			return nil
+		}
+		store := NewObjectStore(os, WithObjectStoreCodec(marshal, unmarshal))
+		expected := Document{Title: "codec", Body: "body"}
+
+		// Act.
+		if err := store.Put(ctx, "codec", expected); err != nil {
+			t.Fatalf("unexpected error putting object: %v", err)
+		}
+		actual, ok, err := store.Get(ctx, "codec")
+		if err != nil || !ok {
+			t.Fatalf("expected the object to be found, got ok=%v, err=%v", ok, err)
+		}
+
+		// Assert.
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Error(diff)
+		}
+		if marshalCalls != 1 || unmarshalCalls != 1 {
+			t.Errorf("expected the codec to be used, got %d marshal calls and %d unmarshal calls", marshalCalls, unmarshalCalls)
+		}
+		result, err := os.GetBytes("codec")
+		if err != nil {
+			t.Fatalf("failed to get object: %v", err)
+		}
+		if string(result) != "codec\nbody" {
+			t.Errorf("expected the object to be encoded by the codec, got %q", result)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package natsjson
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool runs multiple Run loops concurrently using the same BatchProcessor, e.g.
+// to keep up with a stream that a single loop can't.
+type Pool[T any] struct {
+	bp      *BatchProcessor[T]
+	workers int
+}
+
+// NewPool creates a pool of workers that share the BatchProcessor, and its
+// consumer. Batches are processed concurrently, so the processor, and the
+// ErrorHandler and MessageErrorHandler callbacks, must be safe for concurrent
+// use.
+//
+// Ordered consumers, e.g. those created by NewBatchProcessorFromStream, don't
+// support concurrent fetches, and can't be used with a pool.
+func NewPool[T any](bp *BatchProcessor[T], workers int) *Pool[T] {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool[T]{
+		bp:      bp,
+		workers: workers,
+	}
+}
+
+// Run starts the workers, and processes batches until the context is
+// cancelled, or a worker returns an error. Once the context is cancelled, Run
+// waits for each worker to finish its current batch before returning. The first
+// error returned by a worker is returned, and stops the other workers.
+func (p *Pool[T]) Run(ctx context.Context) (err error) {
+	defer p.bp.logReconnects()()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if runErr := p.bp.run(ctx); runErr != nil {
+				once.Do(func() {
+					err = runErr
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
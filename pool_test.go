@@ -0,0 +1,101 @@
+package natsjson
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestPool(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "pool",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "pool", jetstream.ConsumerConfig{
+		Durable:       "poolProcessor",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	var m sync.Mutex
+	received := map[int]bool{}
+	var running, maxRunning int
+	p := func(ctx context.Context, msgs []BatchMessage) []error {
+		m.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		m.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		m.Lock()
+		defer m.Unlock()
+		running--
+		for _, msg := range msgs {
+			received[msg.Index] = true
+		}
+		return make([]error, len(msgs))
+	}
+	bp := NewBatchProcessor[BatchMessage](consumer, 5, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*50)))
+	pool := NewPool(bp, 4)
+	var messages []BatchMessage
+	for i := 0; i < 100; i++ {
+		messages = append(messages, BatchMessage{Index: i})
+	}
+	if err := NewPublisher[BatchMessage](conn).Publish("pool.message", messages...); err != nil {
+		t.Fatalf("unexpected failure sending test messages: %v", err)
+	}
+
+	// Act.
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.Run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.Lock()
+		n := len(received)
+		m.Unlock()
+		if n == len(messages) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for messages, received %d of %d", n, len(messages))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	// Assert.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the pool to stop")
+	}
+	m.Lock()
+	defer m.Unlock()
+	if running != 0 {
+		t.Errorf("expected all workers to finish before Run returned, but %d were running", running)
+	}
+	if maxRunning < 2 {
+		t.Errorf("expected batches to be processed concurrently, but at most %d were running", maxRunning)
+	}
+}
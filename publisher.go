@@ -1,34 +1,195 @@
 package natsjson
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+type PublisherOpt[T any] func(*Publisher[T])
+
+// WithPublisherLogger sets the logger used by the publisher.
+func WithPublisherLogger[T any](log *slog.Logger) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.Log = log
+	}
+}
+
+// WithPublisherValidator calls the function with each value before it's
+// marshalled, e.g. to check business rules. If the function returns an error,
+// the value isn't published, and the error is returned.
+func WithPublisherValidator[T any](validate func(v T) error) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.validate = validate
+	}
+}
+
 type Publisher[T any] struct {
-	NC *nats.Conn
+	Log *slog.Logger
+	// NC is the connection used to publish messages. Prefer Conn, which is
+	// consistent with the accessors of the other types in the package.
+	NC       *nats.Conn
+	tracing  *tracing
+	schema   Schema
+	compress bool
+	envelope *EnvelopeMeta
+	// schemaVersion is set in the SchemaVersionHeader if not empty.
+	schemaVersion string
+	js            jetstream.JetStream
+	msgID         func(v T) string
+	validate      func(v T) error
+	encode        jsonEncodeOpts
+	marshal       func(v T) ([]byte, error)
 }
 
 // NewPublisher creates a new publisher.
-func NewPublisher[T any](nc *nats.Conn) (p *Publisher[T]) {
-	return &Publisher[T]{
+func NewPublisher[T any](nc *nats.Conn, opts ...PublisherOpt[T]) (p *Publisher[T]) {
+	p = &Publisher[T]{
 		NC: nc,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.Log == nil {
+		p.Log = discardLogger()
+	}
+	return p
+}
+
+// Conn returns the connection used to publish messages.
+func (p *Publisher[T]) Conn() *nats.Conn {
+	return p.NC
 }
 
 // Publish a message to the given topic in JSON format.
 func (p *Publisher[T]) Publish(topic string, v ...T) error {
-	for _, vv := range v {
-		b, err := json.Marshal(vv)
+	return p.PublishWithContext(context.Background(), topic, v...)
+}
+
+// PublishWithContext publishes a message to the given topic in JSON format.
+// If tracing is enabled, the trace context is propagated in the message headers.
+func (p *Publisher[T]) PublishWithContext(ctx context.Context, topic string, v ...T) error {
+	for i, vv := range v {
+		msg, err := p.newMsg(topic, vv)
 		if err != nil {
-			return fmt.Errorf("failed to marshal message: %w", err)
+			return withIndex(err, i)
 		}
-		err = p.NC.Publish(topic, b)
-		if err != nil {
-			return fmt.Errorf("failed to publish message: %w", err)
+		if err = p.publishMsg(ctx, msg); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// publishMsg publishes the message, propagating the trace context if tracing is
+// enabled.
+func (p *Publisher[T]) publishMsg(ctx context.Context, msg *nats.Msg) (err error) {
+	p.Log.Debug("Publishing message", slog.String("subject", msg.Subject))
+	if p.tracing != nil {
+		err = p.tracing.publish(ctx, msg, p.NC.PublishMsg)
+	} else {
+		err = p.NC.PublishMsg(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+// newMsg creates a message containing the JSON encoded value.
+func (p *Publisher[T]) newMsg(topic string, v T) (msg *nats.Msg, err error) {
+	if p.validate != nil {
+		if err = p.validate(v); err != nil {
+			return nil, fmt.Errorf("failed to validate value: %w", err)
+		}
+	}
+	var b []byte
+	if p.marshal != nil {
+		b, err = p.marshal(v)
+	} else {
+		b, err = Marshal(v)
+	}
+	if err != nil {
+		p.Log.Warn("Failed to marshal message", slog.String("subject", topic), slog.Any("error", err))
+		return nil, MarshalError{Err: err}
+	}
+	if p.schema != nil {
+		if err = validateSchema(p.schema, b); err != nil {
+			return nil, fmt.Errorf("failed to validate message: %w", err)
+		}
+	}
+	if p.envelope != nil {
+		meta := *p.envelope
+		meta.Timestamp = time.Now().UTC()
+		if b, err = marshalEnvelope(meta, b); err != nil {
+			return nil, fmt.Errorf("failed to marshal envelope: %w", err)
+		}
+	}
+	if b, err = p.encode.format(b); err != nil {
+		return nil, fmt.Errorf("failed to indent message: %w", err)
+	}
+	msg = &nats.Msg{
+		Subject: topic,
+		Data:    b,
+	}
+	if p.compress {
+		if msg.Data, err = compress(b); err != nil {
+			return nil, fmt.Errorf("failed to compress message: %w", err)
+		}
+		msg.Header = nats.Header{}
+		msg.Header.Set(contentEncodingHeader, gzipContentEncoding)
+	}
+	if p.schemaVersion != "" {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(SchemaVersionHeader, p.schemaVersion)
+	}
+	if p.msgID != nil {
+		if id := p.msgID(v); id != "" {
+			if msg.Header == nil {
+				msg.Header = nats.Header{}
+			}
+			msg.Header.Set(nats.MsgIdHdr, id)
+		}
+	}
+	return msg, nil
+}
+
+// Flush waits for the server to process all published messages, so that they
+// aren't lost if the program exits. If the context doesn't have a deadline, the
+// connection's default flush timeout is used.
+func (p *Publisher[T]) Flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return p.NC.Flush()
+	}
+	return p.NC.FlushWithContext(ctx)
+}
+
+// Close drains the connection, waiting for published messages to be sent, and
+// for subscriptions on the connection to finish, before closing it. The
+// connection can't be used after Close is called.
+//
+// Draining is bounded by the connection's DrainTimeout. If the context is
+// cancelled first, Close returns the context's error, and the connection is
+// closed in the background.
+func (p *Publisher[T]) Close(ctx context.Context) error {
+	// Listen before draining, so that the CLOSED status isn't missed.
+	closed := p.NC.StatusChanged(nats.CLOSED)
+	if err := p.NC.Drain(); err != nil {
+		return fmt.Errorf("failed to drain connection: %w", err)
+	}
+	if p.NC.IsClosed() {
+		return nil
+	}
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,290 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultBufferSize          = 256
+	defaultBufferFlushInterval = 100 * time.Millisecond
+	defaultBufferAckTimeout    = 5 * time.Second
+)
+
+var (
+	// ErrPublisherClosed is returned when adding a value to a BufferedPublisher
+	// that has been closed.
+	ErrPublisherClosed = errors.New("publisher closed")
+	// ErrAckTimeout is passed to the error handler of a BufferedPublisher for
+	// each value that wasn't acknowledged within the ack timeout.
+	ErrAckTimeout = errors.New("timed out waiting for acknowledgement")
+)
+
+type BufferedPublisherOpt[T any] func(*BufferedPublisher[T])
+
+// WithBufferedPublisherSize sets the number of values that are buffered before
+// they're published. Defaults to 256.
+func WithBufferedPublisherSize[T any](n int) BufferedPublisherOpt[T] {
+	return func(bp *BufferedPublisher[T]) {
+		bp.size = n
+	}
+}
+
+// WithBufferedPublisherFlushInterval sets how often buffered values are
+// published, regardless of how many values are buffered. Defaults to 100ms.
+func WithBufferedPublisherFlushInterval[T any](d time.Duration) BufferedPublisherOpt[T] {
+	return func(bp *BufferedPublisher[T]) {
+		bp.interval = d
+	}
+}
+
+// WithBufferedPublisherAckTimeout sets how long to wait for the stream to
+// acknowledge published values, before they're passed to the error handler
+// with ErrAckTimeout. Defaults to 5 seconds.
+func WithBufferedPublisherAckTimeout[T any](d time.Duration) BufferedPublisherOpt[T] {
+	return func(bp *BufferedPublisher[T]) {
+		bp.ackTimeout = d
+	}
+}
+
+// WithBufferedPublisherErrorHandler sets a function that's called with each
+// value that the stream didn't acknowledge. If not set, the errors are logged.
+func WithBufferedPublisherErrorHandler[T any](handler func(v T, err error)) BufferedPublisherOpt[T] {
+	return func(bp *BufferedPublisher[T]) {
+		bp.onError = handler
+	}
+}
+
+// BufferedPublisher publishes values to JetStream in batches, without waiting
+// for each value to be acknowledged before publishing the next.
+type BufferedPublisher[T any] struct {
+	Log        *slog.Logger
+	p          *Publisher[T]
+	topic      string
+	size       int
+	interval   time.Duration
+	ackTimeout time.Duration
+	onError    func(v T, err error)
+
+	m      sync.Mutex
+	buffer []bufferedMsg[T]
+	closed bool
+	// pending is the number of batches that are waiting to be acknowledged.
+	pending int
+	// acked is closed when there are no pending batches.
+	acked chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+type bufferedMsg[T any] struct {
+	value T
+	msg   *nats.Msg
+	// span is the producer span of the message, if tracing is enabled.
+	span trace.Span
+}
+
+// NewBufferedPublisher creates a publisher that buffers values, and publishes
+// them to the topic once the buffer is full, or the flush interval has
+// elapsed. The messages are created by the publisher, so its schema,
+// compression and envelope options apply. The publisher must be created with
+// WithPublisherJetStream.
+//
+// Call Close to publish the remaining values and stop the background flush.
+func NewBufferedPublisher[T any](p *Publisher[T], topic string, opts ...BufferedPublisherOpt[T]) (bp *BufferedPublisher[T], err error) {
+	if p.js == nil {
+		return nil, ErrJetStreamRequired
+	}
+	bp = &BufferedPublisher[T]{
+		Log:        p.Log,
+		p:          p,
+		topic:      topic,
+		size:       defaultBufferSize,
+		interval:   defaultBufferFlushInterval,
+		ackTimeout: defaultBufferAckTimeout,
+		acked:      make(chan struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	close(bp.acked)
+	for _, opt := range opts {
+		opt(bp)
+	}
+	if bp.onError == nil {
+		bp.onError = func(v T, err error) {
+			bp.Log.Warn("Failed to publish message", slog.String("subject", bp.topic), slog.Any("error", err))
+		}
+	}
+	go bp.flushPeriodically()
+	return bp, nil
+}
+
+func (bp *BufferedPublisher[T]) flushPeriodically() {
+	defer close(bp.done)
+	ticker := time.NewTicker(bp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bp.stop:
+			return
+		case <-ticker.C:
+			bp.m.Lock()
+			bp.send()
+			bp.m.Unlock()
+		}
+	}
+}
+
+// Add adds the value to the buffer. If the buffer is full, the buffered values
+// are published, without waiting for them to be acknowledged. An error is
+// returned if the value can't be marshalled.
+func (bp *BufferedPublisher[T]) Add(v T) error {
+	return bp.AddWithContext(context.Background(), v)
+}
+
+// AddWithContext is the same as Add, but if tracing is enabled on the
+// publisher, the trace context is propagated in the message headers. The
+// producer span ends once the value is acknowledged.
+func (bp *BufferedPublisher[T]) AddWithContext(ctx context.Context, v T) error {
+	msg, err := bp.p.newMsg(bp.topic, v)
+	if err != nil {
+		return err
+	}
+	bp.m.Lock()
+	defer bp.m.Unlock()
+	if bp.closed {
+		return ErrPublisherClosed
+	}
+	bm := bufferedMsg[T]{value: v, msg: msg}
+	if bp.p.tracing != nil {
+		bm.span = bp.p.tracing.startProducerSpan(ctx, msg)
+	}
+	bp.buffer = append(bp.buffer, bm)
+	if len(bp.buffer) >= bp.size {
+		bp.send()
+	}
+	return nil
+}
+
+// send publishes the buffered messages asynchronously. The caller must hold
+// the lock, so that messages are published in the order they were added.
+func (bp *BufferedPublisher[T]) send() {
+	if len(bp.buffer) == 0 {
+		return
+	}
+	bp.Log.Debug("Publishing buffered messages", slog.String("subject", bp.topic), slog.Int("count", len(bp.buffer)))
+	futures := make([]jetstream.PubAckFuture, len(bp.buffer))
+	for i, bm := range bp.buffer {
+		var err error
+		futures[i], err = bp.p.js.PublishMsgAsync(bm.msg)
+		if err != nil {
+			bp.fail(bm, fmt.Errorf("failed to publish message: %w", err))
+		}
+	}
+	buffer := bp.buffer
+	bp.buffer = nil
+	if bp.pending == 0 {
+		bp.acked = make(chan struct{})
+	}
+	bp.pending++
+	go bp.waitForAcks(buffer, futures)
+}
+
+// waitForAcks waits for each message to be acknowledged, or the ack timeout to
+// elapse, and passes the messages that weren't acknowledged to the error
+// handler.
+func (bp *BufferedPublisher[T]) waitForAcks(buffer []bufferedMsg[T], futures []jetstream.PubAckFuture) {
+	defer func() {
+		bp.m.Lock()
+		defer bp.m.Unlock()
+		bp.pending--
+		if bp.pending == 0 {
+			close(bp.acked)
+		}
+	}()
+	timeout := time.NewTimer(bp.ackTimeout)
+	defer timeout.Stop()
+	var timedOut bool
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		// Check for an ack first, so that messages that were acked before the
+		// timeout aren't reported as failures.
+		select {
+		case <-f.Ok():
+			bp.succeed(buffer[i])
+			continue
+		case err := <-f.Err():
+			bp.fail(buffer[i], fmt.Errorf("failed to publish message: %w", err))
+			continue
+		default:
+		}
+		if timedOut {
+			bp.fail(buffer[i], ErrAckTimeout)
+			continue
+		}
+		select {
+		case <-f.Ok():
+			bp.succeed(buffer[i])
+		case err := <-f.Err():
+			bp.fail(buffer[i], fmt.Errorf("failed to publish message: %w", err))
+		case <-timeout.C:
+			timedOut = true
+			bp.fail(buffer[i], ErrAckTimeout)
+		}
+	}
+}
+
+func (bp *BufferedPublisher[T]) succeed(bm bufferedMsg[T]) {
+	if bm.span != nil {
+		bm.span.End()
+	}
+}
+
+func (bp *BufferedPublisher[T]) fail(bm bufferedMsg[T], err error) {
+	if bm.span != nil {
+		recordSpanError(bm.span, err)
+		bm.span.End()
+	}
+	bp.onError(bm.value, err)
+}
+
+// Flush publishes the buffered values, and waits for all published values to
+// be acknowledged, or the context to be cancelled. Values that weren't
+// acknowledged within the ack timeout are passed to the error handler.
+func (bp *BufferedPublisher[T]) Flush(ctx context.Context) error {
+	bp.m.Lock()
+	bp.send()
+	acked := bp.acked
+	bp.m.Unlock()
+	select {
+	case <-acked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the background flush, and flushes the remaining values. Values
+// can't be added once the publisher is closed.
+func (bp *BufferedPublisher[T]) Close(ctx context.Context) error {
+	bp.m.Lock()
+	if bp.closed {
+		bp.m.Unlock()
+		return nil
+	}
+	bp.closed = true
+	close(bp.stop)
+	bp.m.Unlock()
+	<-bp.done
+	return bp.Flush(ctx)
+}
@@ -0,0 +1,284 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestBufferedPublisher(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	stream, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "buffered",
+		Subjects: []string{"buffered.>"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js))
+	messageCount := func(t *testing.T, subject string) uint64 {
+		t.Helper()
+		info, err := stream.Info(ctx, jetstream.WithSubjectFilter(subject))
+		if err != nil {
+			t.Fatalf("failed to get stream info: %v", err)
+		}
+		return info.State.Subjects[subject]
+	}
+
+	t.Run("NewBufferedPublisher requires WithPublisherJetStream", func(t *testing.T) {
+		_, err := NewBufferedPublisher(NewPublisher[BatchMessage](conn), "buffered.a")
+		if !errors.Is(err, ErrJetStreamRequired) {
+			t.Errorf("expected ErrJetStreamRequired, got %v", err)
+		}
+	})
+	t.Run("values are published once the buffer is full", func(t *testing.T) {
+		// Arrange.
+		bp, err := NewBufferedPublisher(pub, "buffered.size", WithBufferedPublisherSize[BatchMessage](5), WithBufferedPublisherFlushInterval[BatchMessage](time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer bp.Close(ctx)
+
+		// Act.
+		for i := 0; i < 7; i++ {
+			if err := bp.Add(BatchMessage{Index: i}); err != nil {
+				t.Fatalf("unexpected error adding value: %v", err)
+			}
+		}
+		if err := bp.Flush(ctx); err != nil {
+			t.Fatalf("unexpected error flushing: %v", err)
+		}
+
+		// Assert.
+		if n := messageCount(t, "buffered.size"); n != 7 {
+			t.Errorf("expected 7 messages, got %d", n)
+		}
+	})
+	t.Run("values are published once the flush interval elapses", func(t *testing.T) {
+		// Arrange.
+		bp, err := NewBufferedPublisher(pub, "buffered.interval", WithBufferedPublisherFlushInterval[BatchMessage](10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer bp.Close(ctx)
+
+		// Act.
+		if err := bp.Add(BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+
+		// Assert.
+		var n uint64
+		for i := 0; i < 50 && n == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			n = messageCount(t, "buffered.interval")
+		}
+		if n != 1 {
+			t.Errorf("expected 1 message, got %d", n)
+		}
+	})
+	t.Run("Close publishes the remaining values", func(t *testing.T) {
+		// Arrange.
+		bp, err := NewBufferedPublisher(pub, "buffered.close", WithBufferedPublisherFlushInterval[BatchMessage](time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := bp.Add(BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+
+		// Act.
+		if err := bp.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		// Assert.
+		if n := messageCount(t, "buffered.close"); n != 1 {
+			t.Errorf("expected 1 message, got %d", n)
+		}
+		if err := bp.Add(BatchMessage{Index: 2}); !errors.Is(err, ErrPublisherClosed) {
+			t.Errorf("expected ErrPublisherClosed, got %v", err)
+		}
+	})
+	t.Run("values that aren't acknowledged are passed to the error handler", func(t *testing.T) {
+		// Arrange.
+		var m sync.Mutex
+		var failed []BatchMessage
+		onError := func(v BatchMessage, err error) {
+			m.Lock()
+			defer m.Unlock()
+			failed = append(failed, v)
+		}
+		bp, err := NewBufferedPublisher(pub, "not-a-stream", WithBufferedPublisherErrorHandler(onError))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Act.
+		if err := bp.Add(BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := bp.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		// Assert.
+		m.Lock()
+		defer m.Unlock()
+		if len(failed) != 1 || failed[0].Index != 1 {
+			t.Errorf("expected the value to be passed to the error handler, got %v", failed)
+		}
+	})
+	t.Run("values that aren't acknowledged within the ack timeout are passed to the error handler", func(t *testing.T) {
+		// Arrange.
+		// The subscriber receives the message, but never acknowledges it.
+		sub, err := conn.SubscribeSync("no-ack")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		errs := make(chan error, 1)
+		onError := func(v BatchMessage, err error) {
+			errs <- err
+		}
+		bp, err := NewBufferedPublisher(pub, "no-ack", WithBufferedPublisherErrorHandler(onError), WithBufferedPublisherAckTimeout[BatchMessage](50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Act.
+		if err := bp.Add(BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := bp.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		// Assert.
+		if err := <-errs; !errors.Is(err, ErrAckTimeout) {
+			t.Errorf("expected ErrAckTimeout, got %v", err)
+		}
+	})
+	t.Run("Flush returns when the context is cancelled", func(t *testing.T) {
+		// Arrange.
+		sub, err := conn.SubscribeSync("no-ack-flush")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		bp, err := NewBufferedPublisher(pub, "no-ack-flush", WithBufferedPublisherErrorHandler(func(v BatchMessage, err error) {}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := bp.Add(BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+
+		// Act.
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		err = bp.Flush(ctx)
+
+		// Assert.
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+	t.Run("trace context is propagated in the message headers", func(t *testing.T) {
+		// Arrange.
+		tp := &testTracerProvider{}
+		traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		publishCtx := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			TraceFlags: trace.FlagsSampled,
+		}))
+		pub := NewPublisher(conn, WithPublisherJetStream[BatchMessage](js), WithPublisherTracing[BatchMessage](tp, propagation.TraceContext{}))
+		bp, err := NewBufferedPublisher(pub, "buffered.tracing")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Act.
+		if err := bp.AddWithContext(publishCtx, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected error adding value: %v", err)
+		}
+		if err := bp.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		// Assert.
+		msg, err := stream.GetLastMsgForSubject(ctx, "buffered.tracing")
+		if err != nil {
+			t.Fatalf("failed to get message: %v", err)
+		}
+		if traceparent := propagation.HeaderCarrier(msg.Header).Get("traceparent"); !strings.Contains(traceparent, traceID.String()) {
+			t.Errorf("expected the traceparent header to contain the trace ID, got %q", traceparent)
+		}
+		if len(tp.spans) != 1 || tp.spans[0].name != "buffered.tracing publish" {
+			t.Errorf("expected a producer span, got %v", tp.spans)
+		}
+	})
+}
+
+func BenchmarkPublish(b *testing.B) {
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "benchmark",
+		Subjects: []string{"benchmark"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		b.Fatalf("failed to create stream: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js))
+
+	b.Run("PublishJetStream", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := pub.PublishJetStream(ctx, "benchmark", BatchMessage{Index: i}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("BufferedPublisher", func(b *testing.B) {
+		bp, err := NewBufferedPublisher(pub, "benchmark", WithBufferedPublisherErrorHandler(func(v BatchMessage, err error) {
+			b.Error(err)
+		}))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			if err := bp.Add(BatchMessage{Index: i}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := bp.Close(ctx); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
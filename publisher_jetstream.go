@@ -0,0 +1,110 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithPublisherJetStream sets the JetStream context used by PublishJetStream,
+// which waits for the stream to acknowledge each message.
+func WithPublisherJetStream[T any](js jetstream.JetStream) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.js = js
+	}
+}
+
+// WithPublisherMsgID sets the Nats-Msg-Id header of each message to the ID
+// returned by the function, unless it's empty, so that the stream discards duplicates that are
+// published within its duplicate window. Duplicates are reported by the
+// Duplicate field of the PubAck returned when publishing to JetStream.
+func WithPublisherMsgID[T any](id func(v T) string) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.msgID = id
+	}
+}
+
+var (
+	// ErrJetStreamRequired is returned when publishing to JetStream without
+	// setting WithPublisherJetStream.
+	ErrJetStreamRequired = errors.New("publisher requires JetStream, use WithPublisherJetStream")
+	// ErrSequenceMismatch is returned when a message wasn't published, because
+	// the last sequence of the stream, or subject, wasn't the expected sequence.
+	ErrSequenceMismatch = errors.New("stream sequence mismatch")
+)
+
+// PublishJetStream publishes a message to the given topic in JSON format, and
+// waits for the stream to acknowledge it.
+func (p *Publisher[T]) PublishJetStream(ctx context.Context, topic string, v T, opts ...jetstream.PublishOpt) (ack *jetstream.PubAck, err error) {
+	if p.js == nil {
+		return nil, ErrJetStreamRequired
+	}
+	msg, err := p.newMsg(topic, v)
+	if err != nil {
+		return nil, err
+	}
+	return p.publishJetStreamMsg(ctx, msg, opts...)
+}
+
+// publishJetStreamMsg publishes the message to JetStream, propagating the trace
+// context if tracing is enabled, and waits for the stream to acknowledge it.
+func (p *Publisher[T]) publishJetStreamMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (ack *jetstream.PubAck, err error) {
+	p.Log.Debug("Publishing message to JetStream", slog.String("subject", msg.Subject))
+	publish := func(msg *nats.Msg) (err error) {
+		ack, err = p.js.PublishMsg(ctx, msg, opts...)
+		return err
+	}
+	if p.tracing != nil {
+		err = p.tracing.publish(ctx, msg, publish)
+	} else {
+		err = publish(msg)
+	}
+	var apiErr jetstream.JetStreamError
+	if errors.As(err, &apiErr) && apiErr.APIError() != nil {
+		if apiErr.APIError().ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
+			return nil, ErrSequenceMismatch
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish message: %w", err)
+	}
+	return ack, nil
+}
+
+// PublishJetStreamMany publishes the values to the given topic in order, and
+// waits for the stream to acknowledge each one. The acks are returned in the
+// same order as the values. If a value can't be published, the acks of the
+// values that were published before it are returned along with the error.
+func (p *Publisher[T]) PublishJetStreamMany(ctx context.Context, topic string, v ...T) (acks []*jetstream.PubAck, err error) {
+	acks = make([]*jetstream.PubAck, 0, len(v))
+	for i, vv := range v {
+		ack, err := p.PublishJetStream(ctx, topic, vv)
+		if err != nil {
+			if _, ok := err.(MarshalError); ok {
+				// The index is already part of the error message.
+				return acks, withIndex(err, i)
+			}
+			return acks, fmt.Errorf("value %d: %w", i, err)
+		}
+		acks = append(acks, ack)
+	}
+	return acks, nil
+}
+
+// PublishIfLastSequence publishes the message only if the last sequence of the
+// stream is seq, so that concurrent writers can't interleave messages. If it
+// isn't, ErrSequenceMismatch is returned.
+func (p *Publisher[T]) PublishIfLastSequence(ctx context.Context, topic string, v T, seq uint64) (ack *jetstream.PubAck, err error) {
+	return p.PublishJetStream(ctx, topic, v, jetstream.WithExpectLastSequence(seq))
+}
+
+// PublishIfLastSubjectSequence publishes the message only if the sequence of
+// the last message on the topic is seq. If it isn't, ErrSequenceMismatch is
+// returned. Use a seq of 0 to publish only if there are no messages on the topic.
+func (p *Publisher[T]) PublishIfLastSubjectSequence(ctx context.Context, topic string, v T, seq uint64) (ack *jetstream.PubAck, err error) {
+	return p.PublishJetStream(ctx, topic, v, jetstream.WithExpectLastSequencePerSubject(seq))
+}
@@ -0,0 +1,121 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestPublisherJetStream(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "log",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	pub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js))
+
+	t.Run("PublishJetStream requires WithPublisherJetStream", func(t *testing.T) {
+		_, err := NewPublisher[BatchMessage](conn).PublishJetStream(ctx, "log.a", BatchMessage{})
+		if !errors.Is(err, ErrJetStreamRequired) {
+			t.Errorf("expected ErrJetStreamRequired, got %v", err)
+		}
+	})
+	t.Run("PublishJetStream returns the stream sequence", func(t *testing.T) {
+		ack, err := pub.PublishJetStream(ctx, "log.a", BatchMessage{Index: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ack.Stream != "log" || ack.Sequence != 1 {
+			t.Errorf("expected stream %q at sequence 1, got %q at %d", "log", ack.Stream, ack.Sequence)
+		}
+	})
+	t.Run("PublishIfLastSequence appends at the expected sequence", func(t *testing.T) {
+		ack, err := pub.PublishIfLastSequence(ctx, "log.a", BatchMessage{Index: 2}, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ack.Sequence != 2 {
+			t.Errorf("expected sequence 2, got %d", ack.Sequence)
+		}
+	})
+	t.Run("PublishIfLastSequence returns ErrSequenceMismatch if another writer appended first", func(t *testing.T) {
+		_, err := pub.PublishIfLastSequence(ctx, "log.a", BatchMessage{Index: 3}, 1)
+		if !errors.Is(err, ErrSequenceMismatch) {
+			t.Errorf("expected ErrSequenceMismatch, got %v", err)
+		}
+	})
+	t.Run("PublishIfLastSubjectSequence checks the sequence of the subject", func(t *testing.T) {
+		ack, err := pub.PublishIfLastSubjectSequence(ctx, "log.b", BatchMessage{Index: 1}, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = pub.PublishIfLastSubjectSequence(ctx, "log.b", BatchMessage{Index: 2}, 0)
+		if !errors.Is(err, ErrSequenceMismatch) {
+			t.Errorf("expected ErrSequenceMismatch, got %v", err)
+		}
+		if _, err = pub.PublishIfLastSubjectSequence(ctx, "log.b", BatchMessage{Index: 2}, ack.Sequence); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("PublishJetStreamMany returns an ack for each value", func(t *testing.T) {
+		pub := NewPublisher[BatchMessage](conn, WithPublisherJetStream[BatchMessage](js), WithPublisherMsgID(func(v BatchMessage) string {
+			return strconv.Itoa(v.Index)
+		}))
+		acks, err := pub.PublishJetStreamMany(ctx, "log.c", BatchMessage{Index: 1}, BatchMessage{Index: 2}, BatchMessage{Index: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(acks) != 3 {
+			t.Fatalf("expected 3 acks, got %d", len(acks))
+		}
+		if acks[0].Duplicate || acks[1].Duplicate {
+			t.Error("expected the first two values not to be duplicates")
+		}
+		if !acks[2].Duplicate {
+			t.Error("expected the third value to be a duplicate")
+		}
+		if acks[2].Sequence != acks[0].Sequence {
+			t.Errorf("expected the duplicate to have the sequence of the original %d, got %d", acks[0].Sequence, acks[2].Sequence)
+		}
+	})
+	t.Run("PublishJetStreamMany returns the acks of the values published before an error", func(t *testing.T) {
+		acks, err := pub.PublishJetStreamMany(ctx, "not-a-stream", BatchMessage{Index: 1})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if len(acks) != 0 {
+			t.Errorf("expected no acks, got %d", len(acks))
+		}
+	})
+	t.Run("PublishJetStreamMany errors include the index of the value once", func(t *testing.T) {
+		pub := NewPublisher(conn, WithPublisherJetStream[float64](js))
+		_, err := pub.PublishJetStreamMany(ctx, "log.d", 1, math.NaN())
+		var me MarshalError
+		if !errors.As(err, &me) || me.Index != 1 {
+			t.Fatalf("expected a MarshalError for value 1, got %v", err)
+		}
+		if expected := "failed to marshal message 1: json: unsupported value: NaN"; err.Error() != expected {
+			t.Errorf("expected %q, got %q", expected, err.Error())
+		}
+		_, err = pub.PublishJetStreamMany(ctx, "not-a-stream", 1)
+		if err == nil || !strings.HasPrefix(err.Error(), "value 0: ") {
+			t.Errorf("expected the error to include the index, got %v", err)
+		}
+	})
+}
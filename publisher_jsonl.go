@@ -0,0 +1,45 @@
+package natsjson
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// PublishJSONL reads JSON lines from r, and publishes each line to the topic.
+// See PublishJSONLWithContext.
+func (p *Publisher[T]) PublishJSONL(topic string, r io.Reader) (n int, err error) {
+	return p.PublishJSONLWithContext(context.Background(), topic, r)
+}
+
+// PublishJSONLWithContext reads JSON lines from r, and publishes each line to
+// the topic. Each line is unmarshalled into T, so that it's validated before
+// it's published. Empty lines are skipped. Publishing stops at the first line
+// that can't be read or published, and the error includes the line number.
+// The number of messages that were published is returned.
+func (p *Publisher[T]) PublishJSONLWithContext(ctx context.Context, topic string, r io.Reader) (n int, err error) {
+	p.Log.Debug("Publishing JSON lines", slog.String("subject", topic))
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var line int
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var v T
+		if err = Unmarshal(scanner.Bytes(), &v); err != nil {
+			return n, fmt.Errorf("failed to read line %d: %w", line, err)
+		}
+		if err = p.PublishWithContext(ctx, topic, v); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+	}
+	if err = scanner.Err(); err != nil {
+		return n, fmt.Errorf("failed to read line %d: %w", line+1, err)
+	}
+	return n, nil
+}
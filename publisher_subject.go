@@ -0,0 +1,43 @@
+package natsjson
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectPublisher publishes messages to a single subject.
+type SubjectPublisher[T any] struct {
+	p       *Publisher[T]
+	subject string
+}
+
+// NewSubjectPublisher creates a publisher that publishes messages to the
+// subject. The options are the same as those of NewPublisher.
+func NewSubjectPublisher[T any](nc *nats.Conn, subject string, opts ...PublisherOpt[T]) *SubjectPublisher[T] {
+	return &SubjectPublisher[T]{
+		p:       NewPublisher(nc, opts...),
+		subject: subject,
+	}
+}
+
+// Subject returns the subject that messages are published to.
+func (sp *SubjectPublisher[T]) Subject() string {
+	return sp.subject
+}
+
+// Publisher returns the underlying publisher, which can publish to any subject.
+func (sp *SubjectPublisher[T]) Publisher() *Publisher[T] {
+	return sp.p
+}
+
+// Publish a message to the subject in JSON format.
+func (sp *SubjectPublisher[T]) Publish(v ...T) error {
+	return sp.p.Publish(sp.subject, v...)
+}
+
+// PublishWithContext publishes a message to the subject in JSON format.
+// If tracing is enabled, the trace context is propagated in the message headers.
+func (sp *SubjectPublisher[T]) PublishWithContext(ctx context.Context, v ...T) error {
+	return sp.p.PublishWithContext(ctx, sp.subject, v...)
+}
@@ -0,0 +1,233 @@
+package natsjson
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go"
+)
+
+func TestPublisher(t *testing.T) {
+	// Arrange.
+	conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	t.Run("publishes are logged at debug level", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		pub := NewPublisher[int](conn, WithPublisherLogger[int](log))
+		if err := pub.Publish("numbers", 1); err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		if !strings.Contains(buf.String(), `"subject":"numbers"`) {
+			t.Errorf("expected the subject to be logged, got %q", buf.String())
+		}
+	})
+	t.Run("marshal failures are logged at warn level", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+		pub := NewPublisher[float64](conn, WithPublisherLogger[float64](log))
+		if err := pub.Publish("numbers", math.NaN()); err == nil {
+			t.Fatal("expected an error publishing NaN, got nil")
+		}
+		if !strings.Contains(buf.String(), `"level":"WARN"`) {
+			t.Errorf("expected a warning to be logged, got %q", buf.String())
+		}
+	})
+	t.Run("Flush waits for messages to be processed by the server", func(t *testing.T) {
+		sub, err := conn.SubscribeSync("flushed")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		pub := NewPublisher[int](conn)
+		if err := pub.Publish("flushed", 1); err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := pub.Flush(ctx); err != nil {
+			t.Fatalf("unexpected error flushing: %v", err)
+		}
+		if _, err := sub.NextMsg(5 * time.Second); err != nil {
+			t.Errorf("expected the message to be received: %v", err)
+		}
+	})
+	t.Run("Close sends pending messages and closes the connection", func(t *testing.T) {
+		closeConn, _, closeShutdown, err := natsjsontest.NewInProcessNATSServer(natsjsontest.WithJetStream(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeShutdown()
+		var received atomic.Int64
+		if _, err = closeConn.Subscribe("closed", func(msg *nats.Msg) {
+			received.Add(1)
+		}); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		pub := NewPublisher[int](closeConn)
+		for i := 0; i < 100; i++ {
+			if err := pub.Publish("closed", i); err != nil {
+				t.Fatalf("unexpected error publishing: %v", err)
+			}
+		}
+
+		if err := pub.Close(ctx); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+
+		if !closeConn.IsClosed() {
+			t.Error("expected the connection to be closed")
+		}
+		if n := received.Load(); n != 100 {
+			t.Errorf("expected 100 messages to be received, got %d", n)
+		}
+	})
+	t.Run("Close returns when the context is cancelled", func(t *testing.T) {
+		closeConn, _, closeShutdown, err := natsjsontest.NewInProcessNATSServer(natsjsontest.WithJetStream(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeShutdown()
+		// The subscription doesn't finish until it's released, so draining
+		// can't complete.
+		release := make(chan struct{})
+		defer close(release)
+		if _, err = closeConn.Subscribe("blocked", func(msg *nats.Msg) {
+			<-release
+		}); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		pub := NewPublisher[int](closeConn)
+		if err := pub.Publish("blocked", 1); err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		if err := pub.Flush(ctx); err != nil {
+			t.Fatalf("unexpected error flushing: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		err = pub.Close(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+	t.Run("Flush uses the default timeout if the context doesn't have a deadline", func(t *testing.T) {
+		if err := NewPublisher[int](conn).Flush(context.Background()); err != nil {
+			t.Errorf("unexpected error flushing: %v", err)
+		}
+	})
+	t.Run("NewSubjectPublisher publishes to the subject", func(t *testing.T) {
+		sub, err := conn.SubscribeSync("subject")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		pub := NewSubjectPublisher[int](conn, "subject")
+		if err := pub.Publish(1, 2); err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		for _, expected := range []string{"1", "2"} {
+			msg, err := sub.NextMsg(5 * time.Second)
+			if err != nil {
+				t.Fatalf("expected the message to be received: %v", err)
+			}
+			if string(msg.Data) != expected {
+				t.Errorf("expected %q, got %q", expected, msg.Data)
+			}
+		}
+	})
+	t.Run("PublishJSONL publishes each line", func(t *testing.T) {
+		sub, err := conn.SubscribeSync("jsonl")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		pub := NewPublisher[int](conn)
+		n, err := pub.PublishJSONL("jsonl", strings.NewReader("1\n\n2\n"))
+		if err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("expected 2 messages to be published, got %d", n)
+		}
+		for _, expected := range []string{"1", "2"} {
+			msg, err := sub.NextMsg(5 * time.Second)
+			if err != nil {
+				t.Fatalf("expected the message to be received: %v", err)
+			}
+			if string(msg.Data) != expected {
+				t.Errorf("expected %q, got %q", expected, msg.Data)
+			}
+		}
+	})
+	t.Run("PublishJSONL stops at the first malformed line", func(t *testing.T) {
+		pub := NewPublisher[int](conn)
+		n, err := pub.PublishJSONL("jsonl", strings.NewReader("1\n\"two\"\n3\n"))
+		if err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+		if !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("expected the error to include the line number, got %v", err)
+		}
+		if n != 1 {
+			t.Errorf("expected 1 message to be published, got %d", n)
+		}
+	})
+	t.Run("values that fail validation aren't published", func(t *testing.T) {
+		sub, err := conn.SubscribeSync("validated")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		errNegative := errors.New("negative")
+		validate := func(v int) error {
+			if v < 0 {
+				return errNegative
+			}
+			return nil
+		}
+		pub := NewPublisher[int](conn, WithPublisherValidator(validate))
+		if err := pub.Publish("validated", -1); !errors.Is(err, errNegative) {
+			t.Errorf("expected the validation error, got %v", err)
+		}
+		if err := pub.Publish("validated", 1); err != nil {
+			t.Fatalf("unexpected error publishing: %v", err)
+		}
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("expected the message to be received: %v", err)
+		}
+		if string(msg.Data) != "1" {
+			t.Errorf("expected only the valid value to be published, got %q", msg.Data)
+		}
+	})
+	t.Run("marshal failures include the index of the value", func(t *testing.T) {
+		pub := NewPublisher[float64](conn)
+		err := pub.Publish("numbers", 1, math.NaN(), 2)
+		var me MarshalError
+		if !errors.As(err, &me) {
+			t.Fatalf("expected a MarshalError, got %v", err)
+		}
+		if me.Index != 1 {
+			t.Errorf("expected index 1, got %d", me.Index)
+		}
+		if !errors.Is(err, ErrMarshalFailed) {
+			t.Errorf("expected errors.Is to match ErrMarshalFailed")
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// QuarantineErrorHeader contains the reason that a message was quarantined.
+	QuarantineErrorHeader = "Natsjson-Quarantine-Error"
+	// QuarantineSubjectHeader contains the original subject of a quarantined message.
+	QuarantineSubjectHeader = "Natsjson-Quarantine-Subject"
+)
+
+// WithQuarantine republishes messages that can't be decoded to the subject,
+// with their original data and headers, plus headers containing the error and
+// the original subject. The subject should be captured by a stream so that the
+// messages can be inspected later.
+func WithQuarantine[T any](js jetstream.JetStream, subject string) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.js = js
+		bp.quarantineSubject = subject
+	}
+}
+
+// WithStreamProcessorQuarantine republishes messages that can't be decoded to
+// the subject, in the same way as WithQuarantine.
+func WithStreamProcessorQuarantine[T any](js jetstream.JetStream, subject string) StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.js = js
+		sp.quarantineSubject = subject
+	}
+}
+
+func (d *decoder[T]) quarantine(ctx context.Context, msg jetstream.Msg, decodeErr error) (err error) {
+	if d.quarantineSubject == "" {
+		return ErrQuarantineNotSet
+	}
+	qmsg := &nats.Msg{
+		Subject: d.quarantineSubject,
+		Data:    msg.Data(),
+		Header:  nats.Header{},
+	}
+	for k, v := range msg.Headers() {
+		qmsg.Header[k] = v
+	}
+	qmsg.Header.Set(QuarantineErrorHeader, decodeErr.Error())
+	qmsg.Header.Set(QuarantineSubjectHeader, msg.Subject())
+	_, err = d.js.PublishMsg(ctx, qmsg)
+	return err
+}
+
+// ErrQuarantineNotSet is returned by ReplayQuarantined if the processor wasn't
+// created with WithQuarantine, or WithStreamProcessorQuarantine.
+var ErrQuarantineNotSet = errors.New("quarantine subject not set, use WithQuarantine")
+
+// ReplayQuarantined republishes the messages on the quarantine subject, e.g.
+// once a bug that stopped them from being decoded has been fixed. The messages
+// are republished with their original data and headers, without the headers
+// added by the quarantine, to the target subject, or their original subject if
+// the target is empty. Each message is deleted from the quarantine stream once
+// it has been republished, so that it's only replayed once.
+func (b *BatchProcessor[T]) ReplayQuarantined(ctx context.Context, target string) (err error) {
+	if b.quarantineSubject == "" {
+		return ErrQuarantineNotSet
+	}
+	name, err := b.js.StreamNameBySubject(ctx, b.quarantineSubject)
+	if err != nil {
+		return fmt.Errorf("failed to find quarantine stream: %w", err)
+	}
+	stream, err := b.js.Stream(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get quarantine stream: %w", err)
+	}
+	consumer, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{b.quarantineSubject},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ordered consumer: %w", err)
+	}
+	defer func() {
+		if info := consumer.CachedInfo(); info != nil {
+			_ = stream.DeleteConsumer(context.Background(), info.Name)
+		}
+	}()
+	b.Log.Debug("Replaying quarantined messages", slog.String("subject", b.quarantineSubject))
+	for {
+		mb, err := consumer.FetchNoWait(b.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to fetch: %w", err)
+		}
+		var n int
+		for msg := range mb.Messages() {
+			n++
+			if err = b.replay(ctx, stream, msg, target); err != nil {
+				return err
+			}
+		}
+		if err = mb.Error(); err != nil {
+			return fmt.Errorf("failed to fetch: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+func (b *BatchProcessor[T]) replay(ctx context.Context, stream jetstream.Stream, msg jetstream.Msg, target string) (err error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return fmt.Errorf("failed to get message metadata: %w", err)
+	}
+	subject := target
+	if subject == "" {
+		subject = msg.Headers().Get(QuarantineSubjectHeader)
+	}
+	if subject == "" {
+		return fmt.Errorf("quarantined message %d has no original subject", meta.Sequence.Stream)
+	}
+	rmsg := &nats.Msg{
+		Subject: subject,
+		Data:    msg.Data(),
+		Header:  nats.Header{},
+	}
+	for k, v := range msg.Headers() {
+		rmsg.Header[k] = v
+	}
+	rmsg.Header.Del(QuarantineErrorHeader)
+	rmsg.Header.Del(QuarantineSubjectHeader)
+	b.Log.Debug("Replaying quarantined message", slog.String("subject", subject), slog.Uint64("seq", meta.Sequence.Stream))
+	if _, err = b.js.PublishMsg(ctx, rmsg); err != nil {
+		return fmt.Errorf("failed to republish quarantined message %d: %w", meta.Sequence.Stream, err)
+	}
+	if err = stream.DeleteMsg(ctx, meta.Sequence.Stream); err != nil {
+		return fmt.Errorf("failed to delete quarantined message %d: %w", meta.Sequence.Stream, err)
+	}
+	return nil
+}
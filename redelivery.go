@@ -0,0 +1,63 @@
+package natsjson
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithNakDelay redelivers messages that the processor returns an error for
+// after the delay, instead of immediately.
+func WithNakDelay[T any](d time.Duration) BatchProcessorOpt[T] {
+	return WithNakBackoff[T](func(attempt int) time.Duration {
+		return d
+	})
+}
+
+// WithNakBackoff redelivers messages that the processor returns an error for
+// after the delay returned by the backoff function, e.g. to increase the delay
+// exponentially. The attempt is the number of times that the message has been
+// delivered, starting at 1.
+func WithNakBackoff[T any](backoff func(attempt int) time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.nakBackoff = backoff
+	}
+}
+
+// nakDecision returns the decision for a message that failed processing.
+func (b *BatchProcessor[T]) nakDecision(msg jetstream.Msg) AckDecision {
+	if b.nakBackoff == nil {
+		return Nak
+	}
+	attempt := 1
+	if md, err := msg.Metadata(); err == nil {
+		attempt = int(md.NumDelivered)
+	}
+	return NakWithDelay(b.nakBackoff(attempt))
+}
+
+// WithRedeliveryThreshold sets the number of deliveries at which OnRedelivery
+// is called, e.g. to alert before a message reaches the consumer's MaxDeliver.
+// Defaults to 2, so that OnRedelivery is called for every redelivery.
+func WithRedeliveryThreshold[T any](deliveries int) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.redeliveryThreshold = deliveries
+	}
+}
+
+// observeRedelivery calls OnRedelivery if the message has been delivered at
+// least as many times as the threshold.
+func (b *BatchProcessor[T]) observeRedelivery(msg jetstream.Msg, value T) {
+	if b.OnRedelivery == nil {
+		return
+	}
+	threshold := b.redeliveryThreshold
+	if threshold < 2 {
+		threshold = 2
+	}
+	info := newMessageInfo(msg, value)
+	if info.NumDelivered < uint64(threshold) {
+		return
+	}
+	b.OnRedelivery(info, int(info.NumDelivered))
+}
@@ -0,0 +1,162 @@
+package natsjson
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrorHeader is set on replies sent by a Responder when the request failed.
+// Its value is the error message, and the reply has an empty body.
+const ErrorHeader = "Natsjson-Error"
+
+// ResponseError is returned by Request when the reply has the ErrorHeader set.
+type ResponseError struct {
+	Message string `json:"error"`
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+type ResponderOpt[Req, Resp any] func(*Responder[Req, Resp])
+
+// WithResponderLogger sets the logger used by the responder.
+func WithResponderLogger[Req, Resp any](log *slog.Logger) ResponderOpt[Req, Resp] {
+	return func(r *Responder[Req, Resp]) {
+		r.Log = log
+	}
+}
+
+// Responder replies to JSON requests with JSON responses.
+type Responder[Req, Resp any] struct {
+	Log *slog.Logger
+	// NC is the connection used to receive requests. Prefer Conn, which is
+	// consistent with the accessors of the other types in the package.
+	NC *nats.Conn
+}
+
+// Conn returns the connection used to receive requests.
+func (r *Responder[Req, Resp]) Conn() *nats.Conn {
+	return r.NC
+}
+
+// NewResponder creates a new responder.
+func NewResponder[Req, Resp any](nc *nats.Conn, opts ...ResponderOpt[Req, Resp]) (r *Responder[Req, Resp]) {
+	r = &Responder[Req, Resp]{
+		NC: nc,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.Log == nil {
+		r.Log = discardLogger()
+	}
+	return r
+}
+
+// Serve subscribes to the subject, and replies to each request with the result
+// of the handler, until the context is cancelled. If the request can't be
+// decoded, or the handler returns an error, the reply has an empty body, and
+// the ErrorHeader set to the error message.
+func (r *Responder[Req, Resp]) Serve(ctx context.Context, subject string, handler func(ctx context.Context, req Req) (Resp, error)) (err error) {
+	sub, err := r.NC.Subscribe(subject, func(msg *nats.Msg) {
+		r.handle(ctx, msg, handler)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	r.Log.Debug("Serving requests", slog.String("subject", subject))
+	<-ctx.Done()
+	r.Log.Debug("Stopping serving requests", slog.String("subject", subject))
+	return sub.Drain()
+}
+
+// RespondJSON subscribes to the subject, and replies to each request with the
+// JSON encoded result of the handler. Unlike Serve, it returns straight away,
+// and requests are handled until the subscription is unsubscribed or drained.
+// If the request can't be decoded, or the handler returns an error, the reply
+// has an empty body, and the ErrorHeader set to the error message, so that
+// Request returns it as a *ResponseError.
+func RespondJSON[Req, Resp any](nc *nats.Conn, subject string, handler func(req Req) (Resp, error), opts ...ResponderOpt[Req, Resp]) (sub *nats.Subscription, err error) {
+	r := NewResponder(nc, opts...)
+	h := func(ctx context.Context, req Req) (Resp, error) {
+		return handler(req)
+	}
+	sub, err = nc.Subscribe(subject, func(msg *nats.Msg) {
+		r.handle(context.Background(), msg, h)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+	return sub, nil
+}
+
+func (r *Responder[Req, Resp]) handle(ctx context.Context, msg *nats.Msg, handler func(ctx context.Context, req Req) (Resp, error)) {
+	var req Req
+	if err := Unmarshal(msg.Data, &req); err != nil {
+		r.Log.Warn("Failed to unmarshal request", slog.String("subject", msg.Subject), slog.Any("error", err))
+		r.respondError(msg, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		r.Log.Warn("Error handling request", slog.String("subject", msg.Subject), slog.Any("error", err))
+		r.respondError(msg, err)
+		return
+	}
+	data, err := Marshal(resp)
+	if err != nil {
+		r.Log.Warn("Failed to marshal response", slog.String("subject", msg.Subject), slog.Any("error", err))
+		r.respondError(msg, fmt.Errorf("failed to marshal response: %w", err))
+		return
+	}
+	if err = msg.Respond(data); err != nil {
+		r.Log.Warn("Failed to respond", slog.String("subject", msg.Subject), slog.Any("error", err))
+	}
+}
+
+func (r *Responder[Req, Resp]) respondError(msg *nats.Msg, err error) {
+	reply := &nats.Msg{
+		Header: nats.Header{},
+	}
+	reply.Header.Set(ErrorHeader, errorHeaderValue(err))
+	if err = msg.RespondMsg(reply); err != nil {
+		r.Log.Warn("Failed to respond", slog.String("subject", msg.Subject), slog.Any("error", err))
+	}
+}
+
+// Request sends the request as JSON, and decodes the JSON response. If the
+// responder replied with an error, it's returned as a *ResponseError.
+func Request[Req, Resp any](ctx context.Context, nc *nats.Conn, subject string, req Req) (resp Resp, err error) {
+	data, err := Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	msg, err := nc.RequestWithContext(ctx, subject, data)
+	if err != nil {
+		return resp, fmt.Errorf("failed to send request: %w", err)
+	}
+	if message := msg.Header.Get(ErrorHeader); message != "" {
+		return resp, &ResponseError{Message: message}
+	}
+	if err = Unmarshal(msg.Data, &resp); err != nil {
+		return resp, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return resp, nil
+}
+
+// headerValueReplacer removes line breaks, which can't be sent in headers.
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// errorHeaderValue returns the value of the ErrorHeader for the error. It's
+// never empty, so that the reply is recognised as an error.
+func errorHeaderValue(err error) string {
+	if v := headerValueReplacer.Replace(err.Error()); v != "" {
+		return v
+	}
+	return "unknown error"
+}
@@ -0,0 +1,172 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+)
+
+type AddRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type AddResponse struct {
+	Sum int `json:"sum"`
+}
+
+func TestResponder(t *testing.T) {
+	// Arrange.
+	conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	add := func(ctx context.Context, req AddRequest) (AddResponse, error) {
+		if req.A < 0 || req.B < 0 {
+			return AddResponse{}, errors.New("negative numbers are not supported")
+		}
+		return AddResponse{Sum: req.A + req.B}, nil
+	}
+	r := NewResponder[AddRequest, AddResponse](conn)
+	served := make(chan error, 1)
+	go func() {
+		served <- r.Serve(ctx, "add", add)
+	}()
+	// Wait for the subscription to be created.
+	reqCtx, reqCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer reqCancel()
+	for {
+		if _, err := Request[AddRequest, AddResponse](reqCtx, conn, "add", AddRequest{}); err == nil {
+			break
+		}
+		if reqCtx.Err() != nil {
+			t.Fatal("timed out waiting for the responder to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("the response is returned", func(t *testing.T) {
+		resp, err := Request[AddRequest, AddResponse](reqCtx, conn, "add", AddRequest{A: 1, B: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Sum != 3 {
+			t.Errorf("expected 3, got %d", resp.Sum)
+		}
+	})
+	t.Run("handler errors are returned as a ResponseError", func(t *testing.T) {
+		_, err := Request[AddRequest, AddResponse](reqCtx, conn, "add", AddRequest{A: -1, B: 2})
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			t.Fatalf("expected a ResponseError, got %v", err)
+		}
+		if respErr.Message != "negative numbers are not supported" {
+			t.Errorf("unexpected error message: %q", respErr.Message)
+		}
+	})
+	t.Run("invalid requests are returned as a ResponseError", func(t *testing.T) {
+		msg, err := conn.RequestWithContext(reqCtx, "add", []byte("{ _this_is_not_json_ }"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.Header.Get(ErrorHeader) == "" {
+			t.Error("expected the error header to be set")
+		}
+	})
+	t.Run("Serve returns when the context is cancelled", func(t *testing.T) {
+		cancel()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("timed out waiting for Serve to return")
+		}
+	})
+}
+
+func TestRespondJSON(t *testing.T) {
+	// Arrange.
+	conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := RespondJSON(conn, "add", func(req AddRequest) (AddResponse, error) {
+		if req.A < 0 && req.B < 0 {
+			return AddResponse{}, errors.Join(errors.New("a is negative"), errors.New("b is negative"))
+		}
+		if req.A < 0 || req.B < 0 {
+			return AddResponse{}, errors.New("negative numbers are not supported")
+		}
+		return AddResponse{Sum: req.A + req.B}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error subscribing: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	t.Run("the response is returned", func(t *testing.T) {
+		resp, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: 1, B: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Sum != 3 {
+			t.Errorf("expected 3, got %d", resp.Sum)
+		}
+	})
+	t.Run("handler errors are returned as a ResponseError", func(t *testing.T) {
+		_, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: -1})
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			t.Fatalf("expected a ResponseError, got %v", err)
+		}
+		if respErr.Message != "negative numbers are not supported" {
+			t.Errorf("unexpected message %q", respErr.Message)
+		}
+	})
+	t.Run("error replies have the error header set to the message, and an empty body", func(t *testing.T) {
+		msg, err := conn.RequestWithContext(ctx, "add", []byte(`{"a":-1}`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v := msg.Header.Get(ErrorHeader); v != "negative numbers are not supported" {
+			t.Errorf("unexpected error header %q", v)
+		}
+		if len(msg.Data) != 0 {
+			t.Errorf("expected an empty body, got %q", msg.Data)
+		}
+	})
+	t.Run("line breaks are removed from error messages", func(t *testing.T) {
+		_, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: -1, B: -1})
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			t.Fatalf("expected a ResponseError, got %v", err)
+		}
+		if respErr.Message != "a is negative b is negative" {
+			t.Errorf("unexpected message %q", respErr.Message)
+		}
+	})
+	t.Run("requests aren't handled once the subscription is drained", func(t *testing.T) {
+		if err := sub.Drain(); err != nil {
+			t.Fatalf("unexpected error draining: %v", err)
+		}
+		for sub.IsValid() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: 1, B: 2}); err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+}
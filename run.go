@@ -0,0 +1,113 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// WithMaxBackoff sets the maximum delay between attempts when Run encounters
+// consecutive errors. Defaults to 30 seconds.
+func WithMaxBackoff[T any](d time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.maxBackoff = d
+	}
+}
+
+// WithReconnectLogging logs when the connection reconnects to the server while
+// Run is running. The connection's reconnect handler isn't changed, so it can
+// be set by the connection's owner.
+func WithReconnectLogging[T any](nc *nats.Conn) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.nc = nc
+	}
+}
+
+// Run processes batches until the context is cancelled. When Process returns
+// an error, the error is logged, and Run waits before trying again, doubling
+// the delay after each consecutive error, up to the maximum set by
+// WithMaxBackoff. The delay is reset after the first successful fetch.
+// ErrNoMessages isn't treated as an error, and Run fetches again straight away,
+// unless WithFetchNoWait is set. If the processor was created with an invalid
+// option, Run returns the error straight away.
+func (b *BatchProcessor[T]) Run(ctx context.Context) (err error) {
+	defer b.logReconnects()()
+	return b.run(ctx)
+}
+
+// logReconnects logs reconnects if WithReconnectLogging is set, and returns a
+// function that stops logging. It listens for status changes, instead of
+// setting a reconnect handler, so that concurrent calls don't replace each
+// other's handler, or the one set by the connection's owner.
+func (b *BatchProcessor[T]) logReconnects() (stop func()) {
+	if b.nc == nil {
+		return func() {}
+	}
+	// The connection's status changes to CONNECTED each time it reconnects.
+	connected := b.nc.StatusChanged(nats.CONNECTED)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-connected:
+				b.Log.Info("Reconnected to NATS", slog.String("url", b.nc.ConnectedUrlRedacted()))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func (b *BatchProcessor[T]) run(ctx context.Context) (err error) {
+	maxBackoff := b.maxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	var backoff time.Duration
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err = b.Process(ctx)
+		var delay time.Duration
+		switch {
+		case err == nil:
+			backoff = 0
+		case errors.Is(err, ErrNoMessages):
+			backoff = 0
+			if b.fetchNoWait {
+				// Avoid polling the server in a tight loop.
+				delay = defaultMinBackoff
+			}
+		case errors.Is(err, ErrInvalidOption), errors.Is(err, ErrIncompatibleAckPolicy):
+			// Retrying won't help.
+			return err
+		default:
+			if ctx.Err() != nil {
+				return nil
+			}
+			backoff = min(max(backoff*2, defaultMinBackoff), maxBackoff)
+			delay = backoff
+			b.Log.Warn("Failed to process batch, retrying", slog.Any("error", err), slog.Duration("delay", delay))
+		}
+		if delay == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// failingConsumer is a consumer where every fetch fails.
+type failingConsumer struct {
+	jetstream.Consumer
+	fetches []time.Time
+}
+
+func (c *failingConsumer) Fetch(batch int, opts ...jetstream.FetchOpt) (jetstream.MessageBatch, error) {
+	c.fetches = append(c.fetches, time.Now())
+	return nil, errors.New("connection closed")
+}
+
+func (c *failingConsumer) CachedInfo() *jetstream.ConsumerInfo {
+	return nil
+}
+
+// writerFunc is an io.Writer that calls the function.
+type writerFunc func(p []byte) (n int, err error)
+
+func (f writerFunc) Write(p []byte) (n int, err error) {
+	return f(p)
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Run processes batches until the context is cancelled", func(t *testing.T) {
+		// Arrange.
+		conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shutdown()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "run",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+		consumer, err := EnsureConsumer(ctx, js, "run", jetstream.ConsumerConfig{
+			Durable:       "runProcessor",
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		received := make(chan BatchMessage, 10)
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			for _, msg := range msgs {
+				received <- msg
+			}
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond*50)), WithReconnectLogging[BatchMessage](conn))
+		done := make(chan error, 1)
+		go func() {
+			done <- bp.Run(ctx)
+		}()
+
+		// Act.
+		if err := NewPublisher[BatchMessage](conn).Publish("run.message", BatchMessage{Index: 0}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for message %d", i)
+			}
+		}
+		cancel()
+
+		// Assert.
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Run to return")
+		}
+	})
+	t.Run("WithReconnectLogging logs reconnects without replacing the connection's reconnect handler", func(t *testing.T) {
+		// Arrange.
+		c, err := natsjsontest.NewInProcessNATSCluster(2, natsjsontest.WithJetStream(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conn := c.Conns[0]
+		reconnected := make(chan struct{})
+		conn.SetReconnectHandler(func(nc *nats.Conn) {
+			close(reconnected)
+		})
+		logged := make(chan string, 2)
+		log := slog.New(slog.NewTextHandler(writerFunc(func(p []byte) (n int, err error) {
+			logged <- string(p)
+			return len(p), nil
+		}), nil))
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](&failingConsumer{}, 10, p, WithReconnectLogging[BatchMessage](conn), WithLogger[BatchMessage](log))
+		// Run and Pool.Run may log reconnects at the same time.
+		for i := 0; i < 2; i++ {
+			stop := bp.logReconnects()
+			defer stop()
+		}
+
+		// Act.
+		c.ShutdownServer(0)
+
+		// Assert.
+		select {
+		case <-reconnected:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the reconnect handler to be called")
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case msg := <-logged:
+				if !strings.Contains(msg, "Reconnected to NATS") {
+					t.Errorf("unexpected log message: %s", msg)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for reconnect %d to be logged", i)
+			}
+		}
+	})
+	t.Run("a failed fetch is not reported as ErrNoMessages", func(t *testing.T) {
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](&failingConsumer{}, 10, p)
+		err := bp.Process(context.Background())
+		if err == nil || errors.Is(err, ErrNoMessages) {
+			t.Errorf("expected a fetch error, got %v", err)
+		}
+	})
+	t.Run("Run backs off after consecutive errors, up to the maximum", func(t *testing.T) {
+		// Arrange.
+		consumer := &failingConsumer{}
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p, WithMaxBackoff[BatchMessage](time.Millisecond*200))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*800)
+		defer cancel()
+
+		// Act.
+		err := bp.Run(ctx)
+
+		// Assert.
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// Fetches at 0ms, 100ms, 300ms, 500ms and 700ms.
+		if len(consumer.fetches) < 3 || len(consumer.fetches) > 6 {
+			t.Errorf("expected the fetches to be delayed, got %d fetches", len(consumer.fetches))
+		}
+		for i := 2; i < len(consumer.fetches); i++ {
+			if delay := consumer.fetches[i].Sub(consumer.fetches[i-1]); delay < time.Millisecond*150 {
+				t.Errorf("fetch %d: expected a delay of at least 150ms, got %v", i, delay)
+			}
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package natsjson
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+const defaultScheduledRetryDelay = time.Second
+
+type ScheduledPublisherOpt[T any] func(*ScheduledPublisher[T])
+
+// WithScheduledPublisherLogger sets the logger used by the scheduled publisher.
+// Defaults to the logger of the Publisher.
+func WithScheduledPublisherLogger[T any](log *slog.Logger) ScheduledPublisherOpt[T] {
+	return func(sp *ScheduledPublisher[T]) {
+		sp.Log = log
+	}
+}
+
+// WithScheduledPublisherRetryDelay sets how long to wait before trying again
+// when a due message can't be published. Defaults to 1 second.
+func WithScheduledPublisherRetryDelay[T any](d time.Duration) ScheduledPublisherOpt[T] {
+	return func(sp *ScheduledPublisher[T]) {
+		sp.retryDelay = d
+	}
+}
+
+// ScheduledPublisher publishes values once they're due, e.g. to retry an
+// operation in 30 seconds.
+//
+// Scheduled values are stored in a KV bucket until they're due, so they survive
+// restarts. When Run starts, it reads the schedule from the bucket, and
+// publishes any values that became due while it wasn't running straight away.
+// Once a value has been published, it's deleted from the bucket. The bucket
+// should only be used for the schedule, and a history of 1 is sufficient.
+//
+// If the process stops after a value is published, but before it's deleted
+// from the bucket, the value is published again when Run restarts. Each
+// message is published with the schedule ID in the Nats-Msg-Id header, unless
+// WithPublisherMsgID is set, so that a stream discards the duplicate if it's
+// published within the stream's duplicate window. For the same reason, running
+// Run in more than one process may publish duplicates.
+type ScheduledPublisher[T any] struct {
+	Log        *slog.Logger
+	kv         jetstream.KeyValue
+	publisher  *Publisher[T]
+	retryDelay time.Duration
+}
+
+// NewScheduledPublisher creates a publisher that stores scheduled values in the
+// bucket, and publishes them with the publisher when they're due. If the
+// publisher is configured with WithPublisherJetStream, Run waits for the stream
+// to acknowledge each message before removing it from the schedule.
+func NewScheduledPublisher[T any](kv jetstream.KeyValue, publisher *Publisher[T], opts ...ScheduledPublisherOpt[T]) (sp *ScheduledPublisher[T]) {
+	sp = &ScheduledPublisher[T]{
+		Log:        publisher.Log,
+		kv:         kv,
+		publisher:  publisher,
+		retryDelay: defaultScheduledRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(sp)
+	}
+	return sp
+}
+
+// scheduledMsg is stored in the bucket until it's due.
+type scheduledMsg[T any] struct {
+	Subject string    `json:"subject"`
+	Due     time.Time `json:"due"`
+	Value   T         `json:"value"`
+}
+
+// PublishAt schedules the value to be published to the subject at the due
+// time, and returns the ID of the scheduled message, which can be passed to
+// Cancel. The value is published by Run.
+func (sp *ScheduledPublisher[T]) PublishAt(ctx context.Context, subject string, due time.Time, v T) (id string, err error) {
+	if sp.publisher.validate != nil {
+		if err = sp.publisher.validate(v); err != nil {
+			return "", fmt.Errorf("failed to validate value: %w", err)
+		}
+	}
+	data, err := Marshal(scheduledMsg[T]{
+		Subject: subject,
+		Due:     due.UTC(),
+		Value:   v,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal scheduled message: %w", err)
+	}
+	id = nuid.Next()
+	if _, err = sp.kv.Create(ctx, id, data); err != nil {
+		return "", fmt.Errorf("failed to store scheduled message: %w", err)
+	}
+	sp.Log.Debug("Scheduled message", slog.String("id", id), slog.String("subject", subject), slog.Time("due", due))
+	return id, nil
+}
+
+// PublishAfter schedules the value to be published to the subject once the
+// delay has elapsed. See PublishAt.
+func (sp *ScheduledPublisher[T]) PublishAfter(ctx context.Context, subject string, delay time.Duration, v T) (id string, err error) {
+	return sp.PublishAt(ctx, subject, time.Now().Add(delay), v)
+}
+
+// Cancel removes the scheduled message from the schedule. If the message has
+// already been published, Cancel has no effect.
+func (sp *ScheduledPublisher[T]) Cancel(ctx context.Context, id string) (err error) {
+	if err = sp.kv.Delete(ctx, id); err != nil {
+		return fmt.Errorf("failed to cancel scheduled message: %w", err)
+	}
+	return nil
+}
+
+// Run publishes scheduled messages as they become due, until the context is
+// cancelled. Messages that are scheduled, or cancelled, while Run is running
+// are picked up straight away.
+func (sp *ScheduledPublisher[T]) Run(ctx context.Context) (err error) {
+	w, err := sp.kv.WatchAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to watch schedule: %w", err)
+	}
+	defer w.Stop()
+
+	var q scheduleQueue[T]
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		if q.Len() > 0 {
+			timer.Reset(time.Until(q.entries[0].msg.Due))
+		} else {
+			timer.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-w.Updates():
+			if !ok {
+				if ctx.Err() != nil {
+					// The watcher is stopped when the context is cancelled.
+					return nil
+				}
+				return errors.New("schedule watcher closed")
+			}
+			if entry == nil {
+				// All existing entries have been received.
+				continue
+			}
+			if entry.Operation() != jetstream.KeyValuePut {
+				q.remove(entry.Key())
+				continue
+			}
+			var msg scheduledMsg[T]
+			if err := Unmarshal(entry.Value(), &msg); err != nil {
+				sp.Log.Error("Failed to unmarshal scheduled message, skipping", slog.String("id", entry.Key()), slog.Any("error", err))
+				continue
+			}
+			q.set(entry.Key(), entry.Revision(), msg)
+		case <-timer.C:
+			now := time.Now()
+			for q.Len() > 0 && !q.entries[0].msg.Due.After(now) {
+				sp.publishDue(ctx, &q, q.entries[0])
+			}
+		}
+	}
+}
+
+// publishDue publishes the due entry, and removes it from the schedule. If the
+// message can't be published, it's retried after the retry delay.
+func (sp *ScheduledPublisher[T]) publishDue(ctx context.Context, q *scheduleQueue[T], e *scheduleEntry[T]) {
+	log := sp.Log.With(slog.String("id", e.id), slog.String("subject", e.msg.Subject))
+	msg, err := sp.publisher.newMsg(e.msg.Subject, e.msg.Value)
+	if err != nil {
+		// The message will never be valid, so there's no point in retrying.
+		log.Error("Failed to create scheduled message, removing it from the schedule", slog.Any("error", err))
+		sp.delete(ctx, log, q, e)
+		return
+	}
+	if msg.Header.Get(nats.MsgIdHdr) == "" {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(nats.MsgIdHdr, e.id)
+	}
+	if sp.publisher.js != nil {
+		_, err = sp.publisher.publishJetStreamMsg(ctx, msg)
+	} else {
+		err = sp.publisher.publishMsg(ctx, msg)
+	}
+	if err != nil {
+		log.Warn("Failed to publish scheduled message, retrying", slog.Duration("delay", sp.retryDelay), slog.Any("error", err))
+		e.msg.Due = time.Now().Add(sp.retryDelay)
+		heap.Fix(q, e.index)
+		return
+	}
+	sp.delete(ctx, log, q, e)
+}
+
+// delete removes the entry from the schedule, unless it's been rescheduled or
+// cancelled since it was read.
+func (sp *ScheduledPublisher[T]) delete(ctx context.Context, log *slog.Logger, q *scheduleQueue[T], e *scheduleEntry[T]) {
+	q.remove(e.id)
+	if err := sp.kv.Delete(ctx, e.id, jetstream.LastRevision(e.rev)); err != nil {
+		log.Warn("Failed to remove published message from the schedule", slog.Any("error", err))
+	}
+}
+
+type scheduleEntry[T any] struct {
+	id    string
+	rev   uint64
+	msg   scheduledMsg[T]
+	index int
+}
+
+// scheduleQueue is a heap of entries ordered by due time.
+type scheduleQueue[T any] struct {
+	entries []*scheduleEntry[T]
+	byID    map[string]*scheduleEntry[T]
+}
+
+func (q *scheduleQueue[T]) Len() int { return len(q.entries) }
+func (q *scheduleQueue[T]) Less(i, j int) bool {
+	return q.entries[i].msg.Due.Before(q.entries[j].msg.Due)
+}
+func (q *scheduleQueue[T]) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+func (q *scheduleQueue[T]) Push(x any) {
+	e := x.(*scheduleEntry[T])
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+func (q *scheduleQueue[T]) Pop() any {
+	e := q.entries[len(q.entries)-1]
+	q.entries = q.entries[:len(q.entries)-1]
+	return e
+}
+
+// set adds the entry, or replaces it if the ID is already scheduled.
+func (q *scheduleQueue[T]) set(id string, rev uint64, msg scheduledMsg[T]) {
+	if q.byID == nil {
+		q.byID = map[string]*scheduleEntry[T]{}
+	}
+	if e, ok := q.byID[id]; ok {
+		e.rev, e.msg = rev, msg
+		heap.Fix(q, e.index)
+		return
+	}
+	e := &scheduleEntry[T]{id: id, rev: rev, msg: msg}
+	q.byID[id] = e
+	heap.Push(q, e)
+}
+
+func (q *scheduleQueue[T]) remove(id string) {
+	e, ok := q.byID[id]
+	if !ok {
+		return
+	}
+	delete(q.byID, id)
+	heap.Remove(q, e.index)
+}
@@ -0,0 +1,127 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestScheduledPublisher(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: "schedule",
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	sp := NewScheduledPublisher(kv, NewPublisher[StreamMessage](conn))
+	run := func(t *testing.T) (stop func()) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(ctx)
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- sp.Run(ctx)
+		}()
+		return func() {
+			cancel()
+			if err := <-stopped; err != nil {
+				t.Errorf("unexpected error from Run: %v", err)
+			}
+		}
+	}
+	subscribe := func(t *testing.T, subject string) *nats.Subscription {
+		t.Helper()
+		sub, err := conn.SubscribeSync(subject)
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		t.Cleanup(func() { sub.Unsubscribe() })
+		return sub
+	}
+
+	t.Run("messages are published once they're due", func(t *testing.T) {
+		sub := subscribe(t, "scheduled.due")
+		stop := run(t)
+		defer stop()
+
+		start := time.Now()
+		id, err := sp.PublishAfter(ctx, "scheduled.due", 200*time.Millisecond, StreamMessage{Index: 1})
+		if err != nil {
+			t.Fatalf("unexpected error scheduling: %v", err)
+		}
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("expected a message: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("expected the message to be published after 200ms, got %v", elapsed)
+		}
+		if msg.Header.Get(nats.MsgIdHdr) != id {
+			t.Errorf("expected the message ID to be %q, got %q", id, msg.Header.Get(nats.MsgIdHdr))
+		}
+		if string(msg.Data) != `{"index":1}` {
+			t.Errorf("unexpected message data: %s", msg.Data)
+		}
+		// The message is removed from the schedule once it's published.
+		for {
+			if _, err = kv.Get(ctx, id); errors.Is(err, jetstream.ErrKeyNotFound) {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("expected the scheduled message to be removed, got %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	t.Run("messages that became due while not running are published on start", func(t *testing.T) {
+		sub := subscribe(t, "scheduled.restart")
+		if _, err := sp.PublishAt(ctx, "scheduled.restart", time.Now().Add(-time.Minute), StreamMessage{Index: 2}); err != nil {
+			t.Fatalf("unexpected error scheduling: %v", err)
+		}
+
+		stop := run(t)
+		defer stop()
+
+		if _, err := sub.NextMsg(5 * time.Second); err != nil {
+			t.Fatalf("expected a message: %v", err)
+		}
+	})
+	t.Run("cancelled messages aren't published", func(t *testing.T) {
+		sub := subscribe(t, "scheduled.cancel")
+		stop := run(t)
+		defer stop()
+
+		id, err := sp.PublishAfter(ctx, "scheduled.cancel", 200*time.Millisecond, StreamMessage{Index: 3})
+		if err != nil {
+			t.Fatalf("unexpected error scheduling: %v", err)
+		}
+		if err := sp.Cancel(ctx, id); err != nil {
+			t.Fatalf("unexpected error cancelling: %v", err)
+		}
+		if msg, err := sub.NextMsg(500 * time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+			t.Errorf("expected no message, got %v, %v", msg, err)
+		}
+	})
+	t.Run("messages that fail validation aren't scheduled", func(t *testing.T) {
+		errInvalid := errors.New("invalid")
+		sp := NewScheduledPublisher(kv, NewPublisher(conn, WithPublisherValidator(func(v StreamMessage) error {
+			return errInvalid
+		})))
+		if _, err := sp.PublishAfter(ctx, "scheduled.invalid", time.Second, StreamMessage{}); !errors.Is(err, errInvalid) {
+			t.Errorf("expected the validation error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Schema validates a decoded JSON document, i.e. the result of unmarshalling
+// JSON into an any value. Compiled schemas from JSON Schema libraries such as
+// github.com/santhosh-tekuri/jsonschema implement this interface.
+type Schema interface {
+	Validate(v any) error
+}
+
+var ErrSchemaViolation = errors.New("schema violation")
+
+func validateSchema(schema Schema, data []byte) (err error) {
+	var v any
+	if err = Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err = schema.Validate(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrSchemaViolation, err)
+	}
+	return nil
+}
+
+// SchemaViolationPolicy determines what the batch processor does with messages
+// that fail schema validation.
+type SchemaViolationPolicy int
+
+const (
+	// AckSchemaViolations acknowledges invalid messages so that they're not
+	// redelivered.
+	AckSchemaViolations SchemaViolationPolicy = iota
+	// NakSchemaViolations negatively acknowledges invalid messages so that
+	// they're redelivered.
+	NakSchemaViolations
+)
+
+// WithPublisherSchema validates each message against the schema before it's
+// published. Messages that fail validation are not published.
+func WithPublisherSchema[T any](schema Schema) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.schema = schema
+	}
+}
+
+// WithSchema validates each message against the schema before it's processed.
+// Messages that fail validation are passed to the ErrorHandler, and are then
+// acked or nacked depending on the policy.
+func WithSchema[T any](schema Schema, policy SchemaViolationPolicy) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.schema = schema
+		bp.schemaViolationPolicy = policy
+	}
+}
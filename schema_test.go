@@ -0,0 +1,107 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// nonNegativeIndexSchema requires the Index field of a BatchMessage to be non-negative.
+type nonNegativeIndexSchema struct{}
+
+func (nonNegativeIndexSchema) Validate(v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("expected an object")
+	}
+	index, ok := m["Index"].(float64)
+	if !ok {
+		return errors.New("expected Index to be a number")
+	}
+	if index < 0 {
+		return errors.New("expected Index to be non-negative")
+	}
+	return nil
+}
+
+func TestSchema(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	stream, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:    "schema",
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	t.Run("the publisher rejects messages that violate the schema", func(t *testing.T) {
+		pub := NewPublisher[BatchMessage](conn, WithPublisherSchema[BatchMessage](nonNegativeIndexSchema{}))
+		err := pub.Publish("schema.publish", BatchMessage{Index: -1})
+		if !errors.Is(err, ErrSchemaViolation) {
+			t.Errorf("expected ErrSchemaViolation, got %v", err)
+		}
+		info, err := stream.Info(ctx)
+		if err != nil {
+			t.Fatalf("failed to get stream info: %v", err)
+		}
+		if info.State.Msgs != 0 {
+			t.Errorf("expected no messages to be published, got %d", info.State.Msgs)
+		}
+	})
+	t.Run("the batch processor rejects messages that violate the schema", func(t *testing.T) {
+		// Arrange.
+		consumer, err := EnsureConsumer(ctx, js, "schema", jetstream.ConsumerConfig{
+			Durable:       "schemaProcessor",
+			MemoryStorage: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		pub := NewPublisher[BatchMessage](conn)
+		if err = pub.Publish("schema.consume", BatchMessage{Index: -1}, BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test messages: %v", err)
+		}
+
+		// Act.
+		var actual []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			actual = append(actual, msgs...)
+			return make([]error, len(msgs))
+		}
+		bp := NewBatchProcessor[BatchMessage](consumer, 10, p,
+			WithFetchOpts[BatchMessage](jetstream.FetchMaxWait(time.Millisecond)),
+			WithSchema[BatchMessage](nonNegativeIndexSchema{}, AckSchemaViolations))
+		var rejected []BatchMessage
+		bp.ErrorHandler = func(msg BatchMessage, err error) {
+			if !errors.Is(err, ErrSchemaViolation) {
+				t.Errorf("expected ErrSchemaViolation, got %v", err)
+			}
+			rejected = append(rejected, msg)
+		}
+		for i := 0; i < 2; i++ {
+			if err := bp.Process(ctx); err != nil && !errors.Is(err, ErrNoMessages) {
+				t.Fatalf("unexpected error processing batch: %v", err)
+			}
+		}
+
+		// Assert.
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}}, actual); diff != "" {
+			t.Error(diff)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: -1}}, rejected); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
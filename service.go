@@ -0,0 +1,84 @@
+package natsjson
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Service is a NATS micro service with typed JSON endpoints, which supports
+// discovery and stats using the micro protocol.
+type Service struct {
+	Log *slog.Logger
+	svc micro.Service
+}
+
+// NewService creates and starts a micro service. Add endpoints with AddEndpoint.
+func NewService(nc *nats.Conn, config micro.Config) (s *Service, err error) {
+	svc, err := micro.AddService(nc, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add service: %w", err)
+	}
+	return &Service{
+		Log: discardLogger(),
+		svc: svc,
+	}, nil
+}
+
+// Info returns the service's information, including its endpoints.
+func (s *Service) Info() micro.Info {
+	return s.svc.Info()
+}
+
+// Stats returns the service's request, error and processing time statistics.
+func (s *Service) Stats() micro.Stats {
+	return s.svc.Stats()
+}
+
+// Stop stops the service, draining its endpoints.
+func (s *Service) Stop() error {
+	return s.svc.Stop()
+}
+
+// AddEndpoint adds an endpoint to the service that decodes each request to Req,
+// and replies with the Resp returned by the handler. If the request can't be
+// decoded, or the handler returns an error, the reply is a micro error with an
+// empty body, and the ErrorHeader set to the error message, so that it can be
+// used with Request. The context is passed to the handler.
+func AddEndpoint[Req, Resp any](ctx context.Context, s *Service, name string, handler func(ctx context.Context, req Req) (Resp, error), opts ...micro.EndpointOpt) error {
+	h := micro.ContextHandler(ctx, func(ctx context.Context, r micro.Request) {
+		var req Req
+		if err := Unmarshal(r.Data(), &req); err != nil {
+			s.Log.Warn("Failed to unmarshal request", slog.String("subject", r.Subject()), slog.Any("error", err))
+			s.respondError(r, "400", fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			s.Log.Warn("Error handling request", slog.String("subject", r.Subject()), slog.Any("error", err))
+			s.respondError(r, "500", err)
+			return
+		}
+		data, err := Marshal(resp)
+		if err != nil {
+			s.Log.Warn("Failed to marshal response", slog.String("subject", r.Subject()), slog.Any("error", err))
+			s.respondError(r, "500", fmt.Errorf("failed to marshal response: %w", err))
+			return
+		}
+		if err = r.Respond(data); err != nil {
+			s.Log.Warn("Failed to respond", slog.String("subject", r.Subject()), slog.Any("error", err))
+		}
+	})
+	return s.svc.AddEndpoint(name, h, opts...)
+}
+
+func (s *Service) respondError(r micro.Request, code string, err error) {
+	message := errorHeaderValue(err)
+	headers := micro.Headers{ErrorHeader: []string{message}}
+	if err = r.Error(code, message, nil, micro.WithHeaders(headers)); err != nil {
+		s.Log.Warn("Failed to respond", slog.String("subject", r.Subject()), slog.Any("error", err))
+	}
+}
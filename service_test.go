@@ -0,0 +1,77 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestService(t *testing.T) {
+	// Arrange.
+	conn, _, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	svc, err := NewService(conn, micro.Config{
+		Name:    "calculator",
+		Version: "1.0.0",
+	})
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Stop()
+	add := func(ctx context.Context, req AddRequest) (AddResponse, error) {
+		if req.A < 0 || req.B < 0 {
+			return AddResponse{}, errors.New("negative numbers are not supported")
+		}
+		return AddResponse{Sum: req.A + req.B}, nil
+	}
+	if err := AddEndpoint(ctx, svc, "add", add); err != nil {
+		t.Fatalf("failed to add endpoint: %v", err)
+	}
+
+	t.Run("the response is returned", func(t *testing.T) {
+		resp, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: 1, B: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Sum != 3 {
+			t.Errorf("expected 3, got %d", resp.Sum)
+		}
+	})
+	t.Run("handler errors are returned as a ResponseError", func(t *testing.T) {
+		_, err := Request[AddRequest, AddResponse](ctx, conn, "add", AddRequest{A: -1, B: 2})
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			t.Fatalf("expected a ResponseError, got %v", err)
+		}
+		if respErr.Message != "negative numbers are not supported" {
+			t.Errorf("unexpected error message: %q", respErr.Message)
+		}
+	})
+	t.Run("stats are recorded by the service", func(t *testing.T) {
+		// Stats are updated after the response is sent, so wait for them.
+		var requests int
+		for i := 0; i < 50; i++ {
+			stats := svc.Stats()
+			if len(stats.Endpoints) != 1 {
+				t.Fatalf("expected 1 endpoint, got %d", len(stats.Endpoints))
+			}
+			if requests = stats.Endpoints[0].NumRequests; requests == 2 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if requests != 2 {
+			t.Errorf("expected 2 requests, got %d", requests)
+		}
+	})
+}
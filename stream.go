@@ -0,0 +1,205 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type StreamProcessorOpt[T any] func(*StreamProcessor[T])
+
+// WithPullMaxMessages sets the maximum number of messages that are buffered by
+// the client. Defaults to the jetstream package's default of 100.
+func WithPullMaxMessages[T any](n int) StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.pullMaxMessages = n
+	}
+}
+
+// WithStreamProcessorLogger sets the logger used by the StreamProcessor.
+func WithStreamProcessorLogger[T any](log *slog.Logger) StreamProcessorOpt[T] {
+	return func(sp *StreamProcessor[T]) {
+		sp.Log = log
+	}
+}
+
+// ErrAlreadyStarted is returned by Start if the processor is already running.
+var ErrAlreadyStarted = errors.New("already started")
+
+// NewStreamProcessor creates a processor that receives messages continuously
+// using the consumer's Consume method, and passes them to the handler one at a
+// time. Each message is acked if the handler returns nil, and nacked otherwise.
+func NewStreamProcessor[T any](consumer jetstream.Consumer, handler func(ctx context.Context, msg T) error, opts ...StreamProcessorOpt[T]) *StreamProcessor[T] {
+	h := func(ctx context.Context, msg T, headers nats.Header) error {
+		return handler(ctx, msg)
+	}
+	return NewStreamProcessorWithHeaders(consumer, h, opts...)
+}
+
+// NewStreamProcessorWithHeaders is the same as NewStreamProcessor, but also
+// passes the message's headers to the handler, e.g. to read a tenant or
+// correlation ID.
+func NewStreamProcessorWithHeaders[T any](consumer jetstream.Consumer, handler func(ctx context.Context, msg T, headers nats.Header) error, opts ...StreamProcessorOpt[T]) *StreamProcessor[T] {
+	sp := &StreamProcessor[T]{
+		consumer: consumer,
+		handler:  handler,
+	}
+	sp.configure(opts)
+	return sp
+}
+
+// configure applies the options, and sets the defaults. It's also used by
+// NewMessageIterator, which shares the buffering and decoding options.
+func (s *StreamProcessor[T]) configure(opts []StreamProcessorOpt[T]) {
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.Log == nil {
+		s.Log = discardLogger()
+	}
+}
+
+// StreamProcessor is a streaming alternative to BatchProcessor, for consumers
+// that receive a steady flow of messages.
+type StreamProcessor[T any] struct {
+	Log             *slog.Logger
+	consumer        jetstream.Consumer
+	handler         func(ctx context.Context, msg T, headers nats.Header) error
+	pullMaxMessages int
+	decoder[T]
+	// ErrorHandler is called with each message that couldn't be processed.
+	ErrorHandler func(msg T, err error)
+	// MessageErrorHandler is called with the context passed to Start, and each
+	// message that couldn't be processed, along with the message's metadata.
+	// If both ErrorHandler and MessageErrorHandler are set, both are called.
+	MessageErrorHandler func(ctx context.Context, info MessageInfo[T], err error)
+	// OnDecodeError is called with the raw data of each message that couldn't
+	// be decoded, and returns what to do with the message. If not set, the
+	// message is acked and skipped.
+	OnDecodeError func(raw []byte, subject string, err error) DecodeAction
+
+	// m guards cc and done, and is held while starting and stopping.
+	m       sync.Mutex
+	cc      jetstream.ConsumeContext
+	stopped atomic.Bool
+	// done is closed when the processor is stopped.
+	done chan struct{}
+	// handling is held while a message is being handled, so that Wait can wait
+	// for the handler to return.
+	handling sync.Mutex
+}
+
+// Consumer returns the consumer that messages are received from.
+func (s *StreamProcessor[T]) Consumer() jetstream.Consumer {
+	return s.consumer
+}
+
+// Start starts receiving messages in the background. The context is passed to
+// the handler. Call Stop to stop receiving messages, and Wait to wait for the
+// handler to return.
+func (s *StreamProcessor[T]) Start(ctx context.Context) (err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cc != nil && !s.stopped.Load() {
+		return ErrAlreadyStarted
+	}
+	var opts []jetstream.PullConsumeOpt
+	if s.pullMaxMessages > 0 {
+		opts = append(opts, jetstream.PullMaxMessages(s.pullMaxMessages))
+	}
+	opts = append(opts, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		s.Log.Warn("Error consuming messages", slog.Any("error", err))
+	}))
+	s.Log.Debug("Starting stream processor")
+	cc, err := s.consumer.Consume(func(msg jetstream.Msg) {
+		s.handle(ctx, msg)
+	}, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to consume: %w", err)
+	}
+	s.cc = cc
+	s.done = make(chan struct{})
+	s.stopped.Store(false)
+	return nil
+}
+
+func (s *StreamProcessor[T]) handle(ctx context.Context, msg jetstream.Msg) {
+	s.handling.Lock()
+	defer s.handling.Unlock()
+	if s.stopped.Load() {
+		// Messages that were buffered before Stop was called are redelivered.
+		if err := msg.Nak(); err != nil {
+			s.Log.Warn("Failed to nack message", slog.Any("error", err))
+		}
+		return
+	}
+	value, _, _, err := s.decodeMsg(msg)
+	if err != nil {
+		s.Log.Warn("Failed to unmarshal, skipping invalid message", slog.String("subject", msg.Subject()), slog.Any("error", err))
+		if errors.Is(err, ErrUnknownSchemaVersion) {
+			s.handleError(ctx, msg, value, err)
+		}
+		if ackErr := s.skip(ctx, s.Log, msg, s.OnDecodeError, err); ackErr != nil {
+			s.Log.Warn("Failed to acknowledge invalid message", slog.Any("error", ackErr))
+		}
+		return
+	}
+	op := msg.Ack
+	if err = s.handler(ctx, value, msg.Headers()); err != nil {
+		s.Log.Warn("Error processing message", slog.Any("error", err))
+		s.handleError(ctx, msg, value, err)
+		op = msg.Nak
+	}
+	if ackErr := op(); ackErr != nil {
+		s.Log.Warn("Failed to acknowledge message", slog.Any("error", ackErr))
+	}
+}
+
+func (s *StreamProcessor[T]) handleError(ctx context.Context, msg jetstream.Msg, value T, err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(value, err)
+	}
+	if s.MessageErrorHandler != nil {
+		s.MessageErrorHandler(ctx, newMessageInfo(msg, value), err)
+	}
+}
+
+// Stop stops receiving messages. It doesn't wait for the handler to return if
+// a message is being processed, so it can be called from the handler, e.g. to
+// stop after a particular message. Call Wait to wait for the handler. Messages
+// that have been received, but not yet passed to the handler, are nacked.
+func (s *StreamProcessor[T]) Stop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cc == nil || s.stopped.Load() {
+		return
+	}
+	s.Log.Debug("Stopping stream processor")
+	// Set the flag first, so that messages that are dispatched while the
+	// consumer is stopping are nacked instead of being handled.
+	s.stopped.Store(true)
+	s.cc.Stop()
+	close(s.done)
+}
+
+// Wait waits until the processor has been stopped, and the handler has
+// returned if a message was being processed. If the processor hasn't been
+// started, Wait returns straight away. Wait must not be called from the
+// handler.
+func (s *StreamProcessor[T]) Wait() {
+	s.m.Lock()
+	done := s.done
+	s.m.Unlock()
+	if done == nil {
+		return
+	}
+	<-done
+	s.handling.Lock()
+	defer s.handling.Unlock()
+}
@@ -0,0 +1,400 @@
+package natsjson
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type StreamMessage struct {
+	Index int `json:"index"`
+}
+
+func TestStreamProcessor(t *testing.T) {
+	// Arrange.
+	conn, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	_, err = EnsureStream(ctx, js, jetstream.StreamConfig{
+		Name:     "stream",
+		Subjects: []string{"stream-message"},
+		Storage:  jetstream.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	consumer, err := EnsureConsumer(ctx, js, "stream", jetstream.ConsumerConfig{
+		Durable:       "streamProcessor",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Second,
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	publish := func(t *testing.T, data ...string) {
+		t.Helper()
+		for _, d := range data {
+			if err := conn.Publish("stream-message", []byte(d)); err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+		}
+	}
+	waitFor := func(t *testing.T, ch <-chan StreamMessage, n int) (received []StreamMessage) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case msg := <-ch:
+				received = append(received, msg)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for message %d of %d", i+1, n)
+			}
+		}
+		return received
+	}
+
+	t.Run("messages are passed to the handler and acked", func(t *testing.T) {
+		// Arrange.
+		received := make(chan StreamMessage, 10)
+		h := func(ctx context.Context, msg StreamMessage) error {
+			received <- msg
+			return nil
+		}
+		sp := NewStreamProcessor[StreamMessage](consumer, h, WithPullMaxMessages[StreamMessage](5))
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{"index":0}`, `{"index":1}`, `{"index":2}`)
+
+		// Assert.
+		msgs := waitFor(t, received, 3)
+		for i, msg := range msgs {
+			if msg.Index != i {
+				t.Errorf("expected index %d, got %d", i, msg.Index)
+			}
+		}
+		sp.Stop()
+		// Acks are sent asynchronously, so wait for the server to receive them.
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := WaitForDrain(waitCtx, consumer); err != nil {
+			t.Errorf("expected no pending acks: %v", err)
+		}
+	})
+	t.Run("Start returns an error if the processor is already running", func(t *testing.T) {
+		sp := NewStreamProcessor[StreamMessage](consumer, func(ctx context.Context, msg StreamMessage) error { return nil })
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		if err := sp.Start(ctx); err != ErrAlreadyStarted {
+			t.Errorf("expected ErrAlreadyStarted, got %v", err)
+		}
+	})
+	t.Run("invalid messages are skipped", func(t *testing.T) {
+		// Arrange.
+		received := make(chan StreamMessage, 10)
+		h := func(ctx context.Context, msg StreamMessage) error {
+			received <- msg
+			return nil
+		}
+		var m sync.Mutex
+		var decodeErrs []string
+		sp := NewStreamProcessor[StreamMessage](consumer, h)
+		sp.OnDecodeError = func(raw []byte, subject string, err error) DecodeAction {
+			m.Lock()
+			defer m.Unlock()
+			decodeErrs = append(decodeErrs, string(raw))
+			return DecodeAck
+		}
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{ _this_is_not_json_ }`, `{"index":1}`)
+
+		// Assert.
+		msgs := waitFor(t, received, 1)
+		if msgs[0].Index != 1 {
+			t.Errorf("expected index 1, got %d", msgs[0].Index)
+		}
+		m.Lock()
+		defer m.Unlock()
+		if len(decodeErrs) != 1 || decodeErrs[0] != `{ _this_is_not_json_ }` {
+			t.Errorf("expected the invalid message to be passed to OnDecodeError, got %v", decodeErrs)
+		}
+	})
+	t.Run("messages that fail processing are nacked and redelivered", func(t *testing.T) {
+		// Arrange.
+		received := make(chan StreamMessage, 10)
+		var attempts int
+		var errs []error
+		h := func(ctx context.Context, msg StreamMessage) error {
+			attempts++
+			if attempts == 1 {
+				return fmt.Errorf("failed attempt %d", attempts)
+			}
+			received <- msg
+			return nil
+		}
+		sp := NewStreamProcessor[StreamMessage](consumer, h)
+		sp.ErrorHandler = func(msg StreamMessage, err error) {
+			errs = append(errs, err)
+		}
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{"index":5}`)
+
+		// Assert.
+		msgs := waitFor(t, received, 1)
+		if msgs[0].Index != 5 {
+			t.Errorf("expected index 5, got %d", msgs[0].Index)
+		}
+		if attempts != 2 {
+			t.Errorf("expected 2 attempts, got %d", attempts)
+		}
+		if len(errs) != 1 {
+			t.Errorf("expected 1 error to be passed to the error handler, got %v", errs)
+		}
+	})
+	t.Run("MessageErrorHandler receives the context and the message's metadata", func(t *testing.T) {
+		// Arrange.
+		type ctxKey struct{}
+		ctx := context.WithValue(ctx, ctxKey{}, "value")
+		received := make(chan StreamMessage, 10)
+		var attempts int
+		h := func(ctx context.Context, msg StreamMessage) error {
+			attempts++
+			if attempts == 1 {
+				return fmt.Errorf("failed attempt %d", attempts)
+			}
+			received <- msg
+			return nil
+		}
+		var m sync.Mutex
+		var infos []MessageInfo[StreamMessage]
+		var values []any
+		sp := NewStreamProcessor[StreamMessage](consumer, h)
+		sp.MessageErrorHandler = func(ctx context.Context, info MessageInfo[StreamMessage], err error) {
+			m.Lock()
+			defer m.Unlock()
+			infos = append(infos, info)
+			values = append(values, ctx.Value(ctxKey{}))
+		}
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{"index":8}`)
+
+		// Assert.
+		waitFor(t, received, 1)
+		m.Lock()
+		defer m.Unlock()
+		if len(infos) != 1 {
+			t.Fatalf("expected 1 error handler call, got %d", len(infos))
+		}
+		if infos[0].Value.Index != 8 || infos[0].Subject != "stream-message" || infos[0].NumDelivered != 1 {
+			t.Errorf("unexpected message info: %+v", infos[0])
+		}
+		if values[0] != "value" {
+			t.Errorf("expected the context passed to Start, got value %v", values[0])
+		}
+	})
+	t.Run("with WithStreamProcessorEmptyAsZeroValue, empty messages are passed to the handler", func(t *testing.T) {
+		// Arrange.
+		received := make(chan StreamMessage, 10)
+		h := func(ctx context.Context, msg StreamMessage) error {
+			received <- msg
+			return nil
+		}
+		sp := NewStreamProcessor[StreamMessage](consumer, h, WithStreamProcessorEmptyAsZeroValue[StreamMessage]())
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, ``, `{"index":9}`)
+
+		// Assert.
+		msgs := waitFor(t, received, 2)
+		if msgs[0].Index != 0 || msgs[1].Index != 9 {
+			t.Errorf("expected indexes 0 and 9, got %v", msgs)
+		}
+	})
+	t.Run("Wait waits for the handler to return after Stop", func(t *testing.T) {
+		// Arrange.
+		started := make(chan struct{})
+		var finished bool
+		h := func(ctx context.Context, msg StreamMessage) error {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			finished = true
+			return nil
+		}
+		sp := NewStreamProcessor[StreamMessage](consumer, h)
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		publish(t, `{"index":6}`)
+		<-started
+
+		// Act.
+		sp.Stop()
+		sp.Wait()
+
+		// Assert.
+		if !finished {
+			t.Error("expected Wait to wait for the handler to finish")
+		}
+	})
+	t.Run("Stop can be called from the handler", func(t *testing.T) {
+		// Arrange.
+		var sp *StreamProcessor[StreamMessage]
+		stopped := make(chan struct{})
+		h := func(ctx context.Context, msg StreamMessage) error {
+			sp.Stop()
+			close(stopped)
+			return nil
+		}
+		sp = NewStreamProcessor[StreamMessage](consumer, h)
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+
+		// Act.
+		publish(t, `{"index":10}`)
+
+		// Assert.
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Stop to return")
+		}
+		sp.Wait()
+	})
+	t.Run("NewStreamProcessorWithHeaders passes the headers to the handler", func(t *testing.T) {
+		// Arrange.
+		received := make(chan string, 10)
+		h := func(ctx context.Context, msg StreamMessage, headers nats.Header) error {
+			received <- headers.Get("Tenant-Id")
+			return nil
+		}
+		sp := NewStreamProcessorWithHeaders[StreamMessage](consumer, h)
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		msg := nats.NewMsg("stream-message")
+		msg.Header.Set("Tenant-Id", "tenantA")
+		msg.Data = []byte(`{"index":7}`)
+		if err := conn.PublishMsg(msg); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+
+		// Assert.
+		select {
+		case tenant := <-received:
+			if tenant != "tenantA" {
+				t.Errorf("expected tenant %q, got %q", "tenantA", tenant)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	})
+	t.Run("with WithStreamProcessorQuarantine, invalid messages are republished to the quarantine subject", func(t *testing.T) {
+		// Arrange.
+		quarantine, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "stream_quarantine",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("failed to create quarantine stream: %v", err)
+		}
+		received := make(chan StreamMessage, 10)
+		h := func(ctx context.Context, msg StreamMessage) error {
+			received <- msg
+			return nil
+		}
+		sp := NewStreamProcessor[StreamMessage](consumer, h, WithStreamProcessorQuarantine[StreamMessage](js, "stream_quarantine.invalid"))
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{ _this_is_not_json_ }`, `{"index":11}`)
+
+		// Assert.
+		waitFor(t, received, 1)
+		msg, err := quarantine.GetLastMsgForSubject(ctx, "stream_quarantine.invalid")
+		if err != nil {
+			t.Fatalf("failed to get quarantined message: %v", err)
+		}
+		if string(msg.Data) != `{ _this_is_not_json_ }` {
+			t.Errorf("unexpected quarantined data: %s", msg.Data)
+		}
+		if subject := msg.Header.Get(QuarantineSubjectHeader); subject != "stream-message" {
+			t.Errorf("expected original subject %q, got %q", "stream-message", subject)
+		}
+	})
+	t.Run("messages are terminated if DecodeQuarantine is returned without a quarantine subject", func(t *testing.T) {
+		// Arrange.
+		received := make(chan StreamMessage, 10)
+		h := func(ctx context.Context, msg StreamMessage) error {
+			received <- msg
+			return nil
+		}
+		var m sync.Mutex
+		var decodeErrs int
+		sp := NewStreamProcessor[StreamMessage](consumer, h)
+		sp.OnDecodeError = func(raw []byte, subject string, err error) DecodeAction {
+			m.Lock()
+			defer m.Unlock()
+			decodeErrs++
+			return DecodeQuarantine
+		}
+
+		// Act.
+		if err := sp.Start(ctx); err != nil {
+			t.Fatalf("unexpected error starting processor: %v", err)
+		}
+		defer sp.Stop()
+		publish(t, `{ _this_is_not_json_ }`, `{"index":12}`)
+
+		// Assert.
+		waitFor(t, received, 1)
+		// Give a nacked message time to be redelivered.
+		time.Sleep(200 * time.Millisecond)
+		m.Lock()
+		defer m.Unlock()
+		if decodeErrs != 1 {
+			t.Errorf("expected the invalid message to be delivered once, got %d deliveries", decodeErrs)
+		}
+	})
+}
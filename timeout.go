@@ -0,0 +1,45 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProcessTimeout is returned by Process when the processor doesn't return
+// within the timeout set by WithProcessTimeout.
+var ErrProcessTimeout = errors.New("processor timed out")
+
+// WithProcessTimeout cancels the context passed to the processor after the
+// timeout. If the processor hasn't returned by then, the batch is nacked, and
+// Process returns ErrProcessTimeout without waiting for the processor, so that
+// a processor that doesn't respect the context can't block the consumer.
+func WithProcessTimeout[T any](d time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.processTimeout = d
+	}
+}
+
+// process calls the processor, applying the timeout if set.
+func (b *BatchProcessor[T]) process(ctx context.Context, msgs []T) (errs []error, err error) {
+	if b.processTimeout <= 0 {
+		return b.processor(ctx, msgs), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.processTimeout)
+	defer cancel()
+	done := make(chan []error, 1)
+	go func() {
+		done <- b.processor(ctx, msgs)
+	}()
+	select {
+	case errs = <-done:
+		return errs, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v: %w", ErrProcessTimeout, b.processTimeout, ctx.Err())
+		}
+		// The parent context was cancelled, so wait for the processor to handle it.
+		return <-done, nil
+	}
+}
@@ -0,0 +1,80 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var ErrStreamNameRequired = errors.New("stream name is required")
+
+// EnsureStream creates the stream, or updates it to match the configuration if
+// it already exists. If no subjects are configured, the stream captures all
+// subjects under its name, e.g. "orders.>" for the "orders" stream.
+func EnsureStream(ctx context.Context, js jetstream.JetStream, cfg jetstream.StreamConfig) (stream jetstream.Stream, err error) {
+	if cfg.Name == "" {
+		return nil, ErrStreamNameRequired
+	}
+	if len(cfg.Subjects) == 0 {
+		cfg.Subjects = []string{cfg.Name + ".>"}
+	}
+	stream, err = js.CreateOrUpdateStream(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create or update stream %q: %w", cfg.Name, err)
+	}
+	return stream, nil
+}
+
+// EnsureConsumer creates the consumer on the stream, or updates it to match the
+// configuration if it already exists. If no durable name is configured, an
+// ephemeral consumer is created.
+func EnsureConsumer(ctx context.Context, js jetstream.JetStream, stream string, cfg jetstream.ConsumerConfig) (consumer jetstream.Consumer, err error) {
+	if stream == "" {
+		return nil, ErrStreamNameRequired
+	}
+	consumer, err = js.CreateOrUpdateConsumer(ctx, stream, cfg)
+	if err != nil {
+		name := cfg.Name
+		if name == "" {
+			name = cfg.Durable
+		}
+		return nil, fmt.Errorf("failed to create or update consumer %q on stream %q: %w", name, stream, err)
+	}
+	return consumer, nil
+}
+
+// NewBatchProcessorFromConfig creates or updates the consumer on the stream
+// using EnsureConsumer, and returns a BatchProcessor that reads from it, so
+// that the consumer's configuration, e.g. its durable name, ack policy and max
+// deliver, is kept alongside the processor.
+//
+// WithStartSequence and WithStartTime set the consumer's deliver policy, and
+// return ErrInvalidOption if the configuration already sets a deliver policy.
+// The server doesn't allow the deliver policy of an existing consumer to be
+// changed, so they only take effect when the consumer is created.
+func NewBatchProcessorFromConfig[T any](ctx context.Context, js jetstream.JetStream, stream string, cfg jetstream.ConsumerConfig, batchSize int, processor func(ctx context.Context, messages []T) []error, opts ...BatchProcessorOpt[T]) (bp *BatchProcessor[T], err error) {
+	bp = newBatchProcessor(batchSize, processor, opts...)
+	if bp.optErr != nil {
+		return nil, bp.optErr
+	}
+	if policy, ok := bp.deliverPolicy(); ok {
+		if cfg.DeliverPolicy != jetstream.DeliverAllPolicy {
+			return nil, fmt.Errorf("%w: the start position can't be set when the consumer configuration sets a deliver policy", ErrInvalidOption)
+		}
+		cfg.DeliverPolicy = policy
+		cfg.OptStartSeq = bp.startSequence
+		cfg.OptStartTime = bp.optStartTime()
+	}
+	consumer, err := EnsureConsumer(ctx, js, stream, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bp.setConsumer(consumer)
+	bp.checkAckPolicy(consumer)
+	if bp.optErr != nil {
+		return nil, bp.optErr
+	}
+	return bp, nil
+}
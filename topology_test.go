@@ -0,0 +1,172 @@
+package natsjson
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/natsjson/natsjsontest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestTopology(t *testing.T) {
+	// Arrange.
+	_, js, shutdown, err := natsjsontest.NewInProcessNATSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	ctx := context.Background()
+
+	t.Run("EnsureStream requires a name", func(t *testing.T) {
+		_, err := EnsureStream(ctx, js, jetstream.StreamConfig{})
+		if err != ErrStreamNameRequired {
+			t.Errorf("expected ErrStreamNameRequired, got %v", err)
+		}
+	})
+	t.Run("EnsureStream defaults the subjects to the stream name", func(t *testing.T) {
+		stream, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "orders",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating stream: %v", err)
+		}
+		if diff := cmp.Diff([]string{"orders.>"}, stream.CachedInfo().Config.Subjects); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("EnsureStream can be called repeatedly", func(t *testing.T) {
+		_, err := EnsureStream(ctx, js, jetstream.StreamConfig{
+			Name:    "orders",
+			Storage: jetstream.MemoryStorage,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error updating stream: %v", err)
+		}
+	})
+	t.Run("EnsureConsumer creates a durable consumer", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			consumer, err := EnsureConsumer(ctx, js, "orders", jetstream.ConsumerConfig{
+				Durable:       "orderProcessor",
+				MemoryStorage: true,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error creating consumer: %v", err)
+			}
+			if name := consumer.CachedInfo().Name; name != "orderProcessor" {
+				t.Errorf("expected consumer name %q, got %q", "orderProcessor", name)
+			}
+		}
+	})
+	t.Run("EnsureConsumer returns an error if the stream doesn't exist", func(t *testing.T) {
+		_, err := EnsureConsumer(ctx, js, "non_existent", jetstream.ConsumerConfig{
+			Durable: "orderProcessor",
+		})
+		if err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+	t.Run("EnsureConsumer errors include the consumer's name", func(t *testing.T) {
+		_, err := EnsureConsumer(ctx, js, "non_existent", jetstream.ConsumerConfig{
+			Name: "orderProcessor",
+		})
+		if err == nil || !strings.Contains(err.Error(), `consumer "orderProcessor"`) {
+			t.Errorf("expected the error to include the consumer's name, got %v", err)
+		}
+	})
+	t.Run("NewBatchProcessorFromConfig creates the consumer", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](nil, WithPublisherJetStream[BatchMessage](js))
+		if _, err := pub.PublishJetStream(ctx, "orders.created", BatchMessage{Index: 1}); err != nil {
+			t.Fatalf("unexpected failure sending test message: %v", err)
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+
+		// Act.
+		bp, err := NewBatchProcessorFromConfig(ctx, js, "orders", jetstream.ConsumerConfig{
+			Durable:       "orderBatchProcessor",
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			MaxDeliver:    3,
+			MemoryStorage: true,
+		}, 10, p, WithBatchMaxWait[BatchMessage](time.Millisecond*100))
+		if err != nil {
+			t.Fatalf("unexpected error creating processor: %v", err)
+		}
+
+		// Assert.
+		info := bp.Consumer().CachedInfo()
+		if info.Name != "orderBatchProcessor" || info.Config.MaxDeliver != 3 {
+			t.Errorf("expected consumer orderBatchProcessor with MaxDeliver 3, got %q with %d", info.Name, info.Config.MaxDeliver)
+		}
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: 1}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("NewBatchProcessorFromConfig returns an error if the stream doesn't exist", func(t *testing.T) {
+		_, err := NewBatchProcessorFromConfig(ctx, js, "non_existent", jetstream.ConsumerConfig{Durable: "orderProcessor"}, 10, func(ctx context.Context, msgs []BatchMessage) []error { return nil })
+		if err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+	t.Run("NewBatchProcessorFromConfig applies WithStartSequence to the consumer", func(t *testing.T) {
+		// Arrange.
+		pub := NewPublisher[BatchMessage](nil, WithPublisherJetStream[BatchMessage](js))
+		var seq uint64
+		for i := 10; i < 13; i++ {
+			ack, err := pub.PublishJetStream(ctx, "orders.replayed", BatchMessage{Index: i})
+			if err != nil {
+				t.Fatalf("unexpected failure sending test message: %v", err)
+			}
+			if i == 11 {
+				seq = ack.Sequence
+			}
+		}
+		var processed []BatchMessage
+		p := func(ctx context.Context, msgs []BatchMessage) []error {
+			processed = append(processed, msgs...)
+			return nil
+		}
+
+		// Act.
+		bp, err := NewBatchProcessorFromConfig(ctx, js, "orders", jetstream.ConsumerConfig{
+			Durable:       "orderReplayProcessor",
+			FilterSubject: "orders.replayed",
+			MemoryStorage: true,
+		}, 10, p, WithBatchMaxWait[BatchMessage](time.Millisecond*100), WithStartSequence[BatchMessage](seq))
+		if err != nil {
+			t.Fatalf("unexpected error creating processor: %v", err)
+		}
+
+		// Assert.
+		info := bp.Consumer().CachedInfo()
+		if info.Config.DeliverPolicy != jetstream.DeliverByStartSequencePolicy || info.Config.OptStartSeq != seq {
+			t.Errorf("expected the consumer to start at sequence %d, got policy %v at %d", seq, info.Config.DeliverPolicy, info.Config.OptStartSeq)
+		}
+		if err := bp.Process(ctx); err != nil {
+			t.Fatalf("unexpected error processing batch: %v", err)
+		}
+		if diff := cmp.Diff([]BatchMessage{{Index: 11}, {Index: 12}}, processed); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("NewBatchProcessorFromConfig returns an error if the start position conflicts with the deliver policy", func(t *testing.T) {
+		_, err := NewBatchProcessorFromConfig(ctx, js, "orders", jetstream.ConsumerConfig{
+			Durable:       "orderConflictProcessor",
+			DeliverPolicy: jetstream.DeliverNewPolicy,
+		}, 10, func(ctx context.Context, msgs []BatchMessage) []error { return nil }, WithStartTime[BatchMessage](time.Now()))
+		if !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("expected ErrInvalidOption, got %v", err)
+		}
+	})
+}
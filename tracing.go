@@ -0,0 +1,86 @@
+package natsjson
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/a-h/natsjson"
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newTracing(tp trace.TracerProvider, propagator propagation.TextMapPropagator) *tracing {
+	return &tracing{
+		tracer:     tp.Tracer(tracerName),
+		propagator: propagator,
+	}
+}
+
+// WithPublisherTracing starts a producer span for each published message, and
+// injects the trace context into the message headers.
+func WithPublisherTracing[T any](tp trace.TracerProvider, propagator propagation.TextMapPropagator) PublisherOpt[T] {
+	return func(p *Publisher[T]) {
+		p.tracing = newTracing(tp, propagator)
+	}
+}
+
+// WithTracing extracts the trace context from the headers of each message, and
+// starts a consumer span for each message as a child of the producer's span.
+// The span's status records any decode or processing error.
+func WithTracing[T any](tp trace.TracerProvider, propagator propagation.TextMapPropagator) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		bp.tracing = newTracing(tp, propagator)
+	}
+}
+
+func (t *tracing) publish(ctx context.Context, msg *nats.Msg, publish func(msg *nats.Msg) error) (err error) {
+	span := t.startProducerSpan(ctx, msg)
+	defer span.End()
+	err = publish(msg)
+	recordSpanError(span, err)
+	return err
+}
+
+// startProducerSpan starts a producer span for the message, and injects the
+// trace context into the message headers. The caller must end the span.
+func (t *tracing) startProducerSpan(ctx context.Context, msg *nats.Msg) trace.Span {
+	ctx, span := t.tracer.Start(ctx, msg.Subject+" publish", trace.WithSpanKind(trace.SpanKindProducer))
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(msg.Header))
+	return span
+}
+
+func (t *tracing) startConsumerSpan(ctx context.Context, subject string, header nats.Header) trace.Span {
+	if header != nil {
+		ctx = t.propagator.Extract(ctx, propagation.HeaderCarrier(header))
+	}
+	_, span := t.tracer.Start(ctx, subject+" process", trace.WithSpanKind(trace.SpanKindConsumer))
+	return span
+}
+
+// recordSpanError records the error, if any, as the span's status.
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// endSpans records the error, if any, on each span, and ends it.
+func endSpans(spans []trace.Span, err error) {
+	for _, span := range spans {
+		if span != nil {
+			recordSpanError(span, err)
+			span.End()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package natsjson
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithBatchWindow returns a partial batch once the window has elapsed since
+// the first message of the batch was received, instead of waiting for a full
+// batch, so that messages are processed with low latency when there's little
+// traffic, and in full batches during bursts. The wait for the first message
+// is set by WithBatchMaxWait. The window must be greater than zero, and is
+// ignored if WithFetchNoWait is set.
+func WithBatchWindow[T any](d time.Duration) BatchProcessorOpt[T] {
+	return func(bp *BatchProcessor[T]) {
+		if d <= 0 {
+			bp.optErr = errors.Join(bp.optErr, fmt.Errorf("%w: batch window must be greater than 0, got %v", ErrInvalidOption, d))
+			return
+		}
+		bp.window = d
+	}
+}
+
+// fetchWindow fetches the first message of a batch, then fetches the rest of
+// the batch until it's full, or the window elapses.
+func (b *BatchProcessor[T]) fetchWindow(batchSize int) (mb jetstream.MessageBatch, err error) {
+	first, err := b.consumer.Fetch(1, b.fetchOpts...)
+	if err != nil {
+		return nil, err
+	}
+	wb := &windowBatch{
+		msgs: make(chan jetstream.Msg, batchSize),
+	}
+	go func() {
+		defer close(wb.msgs)
+		var received bool
+		for msg := range first.Messages() {
+			received = true
+			wb.msgs <- msg
+		}
+		if wb.err = first.Error(); wb.err != nil || !received || batchSize <= 1 {
+			return
+		}
+		rest, err := b.consumer.Fetch(batchSize-1, jetstream.FetchMaxWait(b.window))
+		if err != nil {
+			wb.err = err
+			return
+		}
+		for msg := range rest.Messages() {
+			wb.msgs <- msg
+		}
+		wb.err = rest.Error()
+	}()
+	return wb, nil
+}
+
+// windowBatch combines the fetches made by fetchWindow into a single batch.
+type windowBatch struct {
+	msgs chan jetstream.Msg
+	// err is set before msgs is closed.
+	err error
+}
+
+func (wb *windowBatch) Messages() <-chan jetstream.Msg {
+	return wb.msgs
+}
+
+func (wb *windowBatch) Error() error {
+	return wb.err
+}